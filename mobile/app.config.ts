@@ -4,6 +4,7 @@ export default ({ config }: ConfigContext): ExpoConfig => ({
   ...config,
   name: "Tribe Tracker",
   slug: "tribe-tracker",
+  scheme: "tribetracker",
   version: "0.1.0",
   orientation: "portrait",
   userInterfaceStyle: "dark",
@@ -8,6 +8,7 @@ import { SafeAreaProvider } from "react-native-safe-area-context";
 import { GestureHandlerRootView } from "react-native-gesture-handler";
 import * as SplashScreen from "expo-splash-screen";
 import { SyncProvider } from "./views/contexts/Sync";
+import { DeepLinkProvider } from "./views/contexts/DeepLinks";
 import { runMigrations } from "./db/migrations";
 import DB from "./db";
 import { useEffect } from "react";
@@ -29,7 +30,9 @@ export default function App() {
             <NavigationContainer>
               <ToastProvider>
                 <SyncProvider>
-                  <AppNavigator />
+                  <DeepLinkProvider>
+                    <AppNavigator />
+                  </DeepLinkProvider>
                 </SyncProvider>
               </ToastProvider>
             </NavigationContainer>
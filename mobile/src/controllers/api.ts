@@ -12,6 +12,30 @@ const store = new AsyncAuthStore({
 const apiUrl = SecureStore.getItem("API_URL") ?? "";
 const pb = new PocketBase(apiUrl, store);
 
+// The server sends a file token for the protected file URLs of a response in
+// a header, and keeps the tokens out of the URLs themselves.
+let fileToken: string | undefined;
+const fileTokenListeners = new Set<() => void>();
+pb.afterSend = (response, data) => {
+  const token = response.headers.get("X-File-Token");
+  if (token && token !== fileToken) {
+    fileToken = token;
+    fileTokenListeners.forEach((listener) => listener());
+  }
+
+  return data;
+};
+
+export function getFileToken(): string | undefined {
+  return fileToken;
+}
+
+// For useSyncExternalStore, so avatars load once the first token arrives.
+export function subscribeFileToken(listener: () => void): () => void {
+  fileTokenListeners.add(listener);
+  return () => fileTokenListeners.delete(listener);
+}
+
 export function getBaseUrl(): string {
   return pb.baseURL;
 }
@@ -88,13 +112,22 @@ export async function refreshAuth() {
   await pb.collection("users").authRefresh();
 }
 
-export function getAvatarUri(avatar: string): string {
-  const user = pb.authStore.record?.id;
-  if (!user) {
-    throw new Error("Not authorized.");
+// avatarSource adds a file token to an avatar URL resolved by the server.
+// The URL without the token keys the image cache, so a new token doesn't
+// download the avatar again.
+export function avatarSource(
+  avatarUrl: string | undefined,
+  token: string | undefined,
+): { uri: string; cacheKey: string } | undefined {
+  if (!avatarUrl || !token) {
+    return undefined;
   }
 
-  return `${getBaseUrl()}api/files/users/${user}/${avatar}`;
+  const separator = avatarUrl.includes("?") ? "&" : "?";
+  return {
+    uri: `${avatarUrl}${separator}token=${encodeURIComponent(token)}`,
+    cacheKey: avatarUrl,
+  };
 }
 
 export async function updateMe(
@@ -168,6 +201,54 @@ export async function createFamily(
   }
 }
 
+export async function joinFamily(
+  token: string,
+): Promise<
+  | { success: true; familyMember: ApiFamilyMember }
+  | { success: false; error: Error }
+> {
+  try {
+    const familyMember = await pb.send<ApiFamilyMember>(
+      `/mobile/families/join`,
+      {
+        method: "POST",
+        body: { token },
+      },
+    );
+    return { success: true, familyMember };
+  } catch (error) {
+    if (error instanceof Error) {
+      return { success: false, error };
+    }
+
+    return { success: false, error: new Error("Unknown error.") };
+  }
+}
+
+export async function redeemInvitation(
+  token: string,
+): Promise<
+  | { success: true; familyMember: ApiFamilyMember }
+  | { success: false; error: Error }
+> {
+  try {
+    const familyMember = await pb.send<ApiFamilyMember>(
+      `/mobile/invitations/redeem`,
+      {
+        method: "POST",
+        body: { token },
+      },
+    );
+    return { success: true, familyMember };
+  } catch (error) {
+    if (error instanceof Error) {
+      return { success: false, error };
+    }
+
+    return { success: false, error: new Error("Unknown error.") };
+  }
+}
+
 type ApiLocation = Omit<Location, "createdAt"> & {
   createdAt: string;
 };
@@ -176,7 +257,9 @@ type SyncResponse = {
   users: ApiUser[];
   families: ApiFamily[];
   familyMembers: ApiFamilyMember[];
+  deletedFamilyMembers: string[];
   locations: ApiLocation[];
+  syncedAt: string;
 };
 
 export async function getSyncData(after: Date): Promise<SyncResponse> {
@@ -189,6 +272,7 @@ export async function getSyncData(after: Date): Promise<SyncResponse> {
 export async function createLocation(
   lat: number,
   lon: number,
+  recordedAt?: Date,
 ): Promise<
   { success: true; location: ApiLocation } | { success: false; error: Error }
 > {
@@ -201,6 +285,7 @@ export async function createLocation(
     const created = await pb.collection<ApiLocation>("locations").create({
       user,
       coordinates: { lat, lon },
+      recordedAt: recordedAt?.toISOString(),
     });
 
     return { success: true, location: created };
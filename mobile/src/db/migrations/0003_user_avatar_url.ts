@@ -0,0 +1,26 @@
+import * as SecureStore from "expo-secure-store";
+import type { Migration } from "../migrations";
+
+const UserAvatarUrl: Migration = {
+  name: "user_avatar_url",
+
+  up: async (db) => {
+    await db.execAsync("ALTER TABLE users ADD COLUMN avatarUrl TEXT");
+
+    // Users synced before the server resolved avatar URLs aren't synced
+    // again until they change, so their URLs are filled in once here.
+    const apiUrl = SecureStore.getItem("API_URL");
+    if (apiUrl) {
+      await db.runAsync(
+        `
+        UPDATE users
+        SET avatarUrl = ? || 'api/files/users/' || id || '/' || avatar
+        WHERE avatar IS NOT NULL AND avatar != ''
+        `,
+        apiUrl,
+      );
+    }
+  },
+};
+
+export default UserAvatarUrl;
@@ -155,6 +155,7 @@ export type FamilyMemberUser = {
   firstName: string;
   lastName: string;
   avatar?: string;
+  avatarUrl?: string;
   joinedAt: Date;
 };
 
@@ -167,6 +168,7 @@ export async function getFamilyMembers(
     u.firstName,
     u.lastName,
     u.avatar,
+    u.avatarUrl,
     fm.createdAt as joinedAt
   FROM families f
   JOIN familyMembers fm
@@ -182,6 +184,7 @@ export async function getFamilyMembers(
     firstName: string;
     lastName: string;
     avatar?: string;
+    avatarUrl?: string;
     joinedAt: string;
   }>(query, id);
 
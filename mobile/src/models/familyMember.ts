@@ -85,6 +85,18 @@ export async function upsertFamilyMembers(familyMembers: FamilyMember[]) {
   await statement.finalizeAsync();
 }
 
+export async function deleteFamilyMembers(ids: string[]) {
+  const statement = await DB.prepareAsync(
+    "DELETE FROM familyMembers WHERE id = $familyMemberId",
+  );
+
+  await Promise.all(
+    ids.map((id) => statement.executeAsync({ $familyMemberId: id })),
+  );
+
+  await statement.finalizeAsync();
+}
+
 export async function deleteAllFamilyMembers() {
   await DB.runAsync("DELETE FROM familyMembers");
 }
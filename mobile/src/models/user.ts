@@ -7,6 +7,9 @@ export type User = {
   firstName: string;
   lastName: string;
   avatar?: string;
+  // Resolved by the server. Avatars are protected, so the URL only loads
+  // with a file token added, see API.avatarSource.
+  avatarUrl?: string;
   createdAt: Date;
   updatedAt: Date;
 };
@@ -21,10 +24,11 @@ export async function upsertUser(
     firstName,
     lastName,
     avatar,
+    avatarUrl,
     createdAt,
     updatedAt
   ) VALUES (
-    ?, ?, ?, ?, ?, ?, ?
+    ?, ?, ?, ?, ?, ?, ?, ?
   )
   ON CONFLICT (id)
   DO UPDATE SET
@@ -32,12 +36,14 @@ export async function upsertUser(
     firstName = excluded.firstName,
     lastName = excluded.lastName,
     avatar = excluded.avatar,
+    avatarUrl = excluded.avatarUrl,
     updatedAt = excluded.updatedAt
   RETURNING id,
     email,
     firstName,
     lastName,
     avatar,
+    avatarUrl,
     createdAt,
     updatedAt
   `;
@@ -48,6 +54,7 @@ export async function upsertUser(
     firstName: string;
     lastName: string;
     avatar: string;
+    avatarUrl: string;
     createdAt: string;
     updatedAt: string;
   }>(
@@ -57,6 +64,7 @@ export async function upsertUser(
     user.firstName,
     user.lastName,
     user.avatar ?? null,
+    user.avatarUrl ?? null,
     user.createdAt.toISOString(),
     user.updatedAt.toISOString(),
   );
@@ -86,10 +94,18 @@ export async function upsertUsers(users: User[]) {
     firstName,
     lastName,
     avatar,
+    avatarUrl,
     createdAt,
     updatedAt
   ) VALUES (
-    $id, $email, $firstName, $lastName, $avatar, $createdAt, $updatedAt
+    $id,
+    $email,
+    $firstName,
+    $lastName,
+    $avatar,
+    $avatarUrl,
+    $createdAt,
+    $updatedAt
   )
   ON CONFLICT (id)
   DO UPDATE SET
@@ -97,18 +113,29 @@ export async function upsertUsers(users: User[]) {
     firstName = excluded.firstName,
     lastName = excluded.lastName,
     avatar = excluded.avatar,
+    avatarUrl = excluded.avatarUrl,
     updatedAt = excluded.updatedAt
   `);
 
   await Promise.all(
     users.map(
-      ({ id, email, firstName, lastName, avatar, createdAt, updatedAt }) =>
+      ({
+        id,
+        email,
+        firstName,
+        lastName,
+        avatar,
+        avatarUrl,
+        createdAt,
+        updatedAt,
+      }) =>
         statement.executeAsync({
           $id: id,
           $email: email,
           $firstName: firstName,
           $lastName: lastName,
           $avatar: !avatar ? null : avatar,
+          $avatarUrl: !avatarUrl ? null : avatarUrl,
           $createdAt: createdAt.toISOString(),
           $updatedAt: updatedAt.toISOString(),
         }),
@@ -125,6 +152,7 @@ export async function getUser(id: string): Promise<User | null> {
     firstName,
     lastName,
     avatar,
+    avatarUrl,
     createdAt,
     updatedAt
   from users
@@ -136,6 +164,8 @@ export async function getUser(id: string): Promise<User | null> {
     email: string;
     firstName: string;
     lastName: string;
+    avatar?: string;
+    avatarUrl?: string;
     createdAt: string;
     updatedAt: string;
   }>(query, id);
@@ -22,7 +22,11 @@ TaskManager.defineTask(TASK_NAME, async ({ data, error }) => {
   const latest = locations[locations.length - 1];
   const { latitude, longitude } = latest.coords;
 
-  await API.createLocation(latitude, longitude).catch(() => {
+  await API.createLocation(
+    latitude,
+    longitude,
+    new Date(latest.timestamp),
+  ).catch(() => {
     /* couldn't reach the server */
   });
 });
@@ -2,20 +2,25 @@ import type { User } from "../../models/user";
 import { StyleSheet, View } from "react-native";
 import { Text, useTheme } from "@ui-kitten/components";
 import { Image } from "expo-image";
+import { useSyncExternalStore } from "react";
 import * as API from "../../controllers/api";
 
-type AvatarHeroProps = Pick<User, "avatar" | "firstName" | "lastName"> & {
+type AvatarHeroProps = Pick<User, "avatarUrl" | "firstName" | "lastName"> & {
   size: number;
 };
 
 export default function AvatarHero({
-  avatar,
+  avatarUrl,
   firstName,
   lastName,
   size,
 }: AvatarHeroProps) {
   const theme = useTheme();
-  const uri = API.getAvatarUri(avatar ?? "");
+  const fileToken = useSyncExternalStore(
+    API.subscribeFileToken,
+    API.getFileToken,
+  );
+  const source = API.avatarSource(avatarUrl, fileToken);
 
   return (
     <View
@@ -28,14 +33,14 @@ export default function AvatarHero({
         },
       ]}
     >
-      {!avatar ? (
+      {!source ? (
         <Text category="h1" style={styles.text}>
           {`${firstName[0] + lastName[0]}`.toUpperCase()}
         </Text>
       ) : (
         <Image
           alt="user avatar"
-          source={uri}
+          source={source}
           contentFit="cover"
           style={[
             styles.image,
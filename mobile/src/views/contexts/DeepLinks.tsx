@@ -0,0 +1,73 @@
+import { ReactNode, useEffect } from "react";
+import { Linking } from "react-native";
+import * as API from "../../controllers/api";
+import { useSync } from "./Sync";
+import { useToast } from "./Toast";
+
+type DeepLinkProviderProps = {
+  children: ReactNode;
+};
+
+// Links opened from the server's landing pages, e.g.
+// tribetracker://join/{token} or tribetracker://invitations/{token}.
+function parseDeepLink(url: string): { action: string; token: string } | null {
+  const match = url.match(/^tribetracker:\/\/([^/?#]+)\/([^/?#]+)/);
+  if (!match) {
+    return null;
+  }
+
+  return { action: match[1], token: decodeURIComponent(match[2]) };
+}
+
+export const DeepLinkProvider = ({ children }: DeepLinkProviderProps) => {
+  const { sync } = useSync();
+  const toast = useToast();
+
+  useEffect(() => {
+    const handleUrl = async (url: string | null) => {
+      const link = url ? parseDeepLink(url) : null;
+      if (!link) return;
+
+      if (!API.isSignedIn()) {
+        toast.danger("Sign in, then open the link again.");
+        return;
+      }
+
+      switch (link.action) {
+        case "join": {
+          const res = await API.joinFamily(link.token);
+          if (!res.success) {
+            toast.danger(res.error.message);
+            return;
+          }
+          break;
+        }
+        case "invitations": {
+          const res = await API.redeemInvitation(link.token);
+          if (!res.success) {
+            toast.danger(res.error.message);
+            return;
+          }
+          break;
+        }
+        default:
+          return;
+      }
+
+      await sync().catch(() => {
+        /* ignore */
+      });
+    };
+
+    Linking.getInitialURL().then(handleUrl);
+    const subscription = Linking.addEventListener("url", ({ url }) =>
+      handleUrl(url),
+    );
+
+    return () => {
+      subscription.remove();
+    };
+  }, []);
+
+  return <>{children}</>;
+};
@@ -11,7 +11,10 @@ import * as Location from "expo-location";
 import { deleteUsers, upsertUsers } from "../../models/user";
 import { deleteFamilies, upsertFamilies } from "../../models/family";
 import { upsertLocations } from "../../models/locations";
-import { upsertFamilyMembers } from "../../models/familyMember";
+import {
+  deleteFamilyMembers,
+  upsertFamilyMembers,
+} from "../../models/familyMember";
 
 const SyncContext = createContext<{
   lastSyncedAt: Date;
@@ -100,7 +103,7 @@ async function getLocation(): Promise<Location.LocationObjectCoords | null> {
   return coords;
 }
 
-async function syncWithAPI(lastSyncedAt: Date) {
+async function syncWithAPI(lastSyncedAt: Date): Promise<Date> {
   if (!API.isSignedIn()) {
     throw new Error("Not authenticated.");
   }
@@ -113,8 +116,14 @@ async function syncWithAPI(lastSyncedAt: Date) {
     await API.createLocation(coords.latitude, coords.longitude).catch(() => {});
   }
 
-  const { users, families, familyMembers, locations } =
-    await API.getSyncData(lastSyncedAt);
+  const {
+    users,
+    families,
+    familyMembers,
+    deletedFamilyMembers,
+    locations,
+    syncedAt,
+  } = await API.getSyncData(lastSyncedAt);
 
   await upsertAndDeleteRecentUsers(users);
   await upsertAndDeleteFamilies(families);
@@ -124,12 +133,16 @@ async function syncWithAPI(lastSyncedAt: Date) {
       createdAt: new Date(familyMember.createdAt),
     })),
   );
+  await deleteFamilyMembers(deletedFamilyMembers);
   await upsertLocations(
     locations.map((location) => ({
       ...location,
       createdAt: new Date(location.createdAt),
     })),
   );
+
+  // The server's high-water mark, so clock skew can't skip records.
+  return new Date(syncedAt);
 }
 
 const storedLastSyncedAt = !SecureStore.getItem("LAST_SYNCED_AT")
@@ -150,7 +163,7 @@ export const SyncProvider = ({ children }: SyncProviderProps) => {
 
   useEffect(() => {
     syncWithAPI(storedLastSyncedAt)
-      .then(() => updateLastSyncedAt(new Date()))
+      .then(updateLastSyncedAt)
       .catch(() => {
         /* ignore */
       });
@@ -162,8 +175,7 @@ export const SyncProvider = ({ children }: SyncProviderProps) => {
         lastSyncedAt,
         resetSync,
         sync: async () => {
-          await syncWithAPI(lastSyncedAt);
-          updateLastSyncedAt(new Date());
+          updateLastSyncedAt(await syncWithAPI(lastSyncedAt));
         },
       }}
     >
@@ -15,8 +15,9 @@ import {
 import { NativeStackScreenProps } from "@react-navigation/native-stack";
 import { StackParamList } from "../AppNavigator";
 import { Pressable, StyleSheet, View } from "react-native";
-import { useState } from "react";
+import { useState, useSyncExternalStore } from "react";
 import { useToast } from "../contexts/Toast";
+import { useSync } from "../contexts/Sync";
 import * as ImagePicker from "expo-image-picker";
 import BackArrowIcon from "../components/BackArrowIcon";
 import { Image } from "expo-image";
@@ -38,6 +39,11 @@ export default function ProfileEditScreen({
 }: ProfileEditScreenProps) {
   const theme = useTheme();
   const toast = useToast();
+  const { sync } = useSync();
+  const fileToken = useSyncExternalStore(
+    API.subscribeFileToken,
+    API.getFileToken,
+  );
   const { bottom } = useSafeAreaInsets();
   const [avatar, setAvatar] = useState<string | undefined>();
   const [firstName, setFirstName] = useState("");
@@ -65,6 +71,9 @@ export default function ProfileEditScreen({
       mediaTypes: ["images"],
       aspect: [1, 1],
       quality: 1,
+      // iOS hands out HEIC photos as is otherwise, which the server rejects
+      preferredAssetRepresentationMode:
+        ImagePicker.UIImagePickerPreferredAssetRepresentationMode.Compatible,
     });
 
     if (!result.assets) {
@@ -97,6 +106,11 @@ export default function ProfileEditScreen({
         throw new Error("Failed to update local user. Please re-sync.");
       }
 
+      // a new avatar is only served from the URL the server resolves for it
+      sync().catch(() => {
+        /* ignore */
+      });
+
       navigation.pop();
     } catch (e) {
       if (e instanceof Error) {
@@ -147,7 +161,11 @@ export default function ProfileEditScreen({
                 ) : (
                   <Image
                     alt="user avatar"
-                    source={avatar}
+                    source={
+                      avatar === query.result?.avatar
+                        ? API.avatarSource(query.result?.avatarUrl, fileToken)
+                        : avatar
+                    }
                     contentFit="cover"
                     style={styles.image}
                   />
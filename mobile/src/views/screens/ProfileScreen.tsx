@@ -90,7 +90,7 @@ export default function ProfileScreen({ navigation }: ProfileScreenProps) {
               <View>
                 <AvatarHero
                   size={AVATAR_SIZE}
-                  avatar={query.result.avatar}
+                  avatarUrl={query.result.avatarUrl}
                   firstName={query.result.firstName}
                   lastName={query.result.lastName}
                 />
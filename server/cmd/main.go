@@ -5,31 +5,37 @@ import (
 	"os"
 
 	"github.com/pocketbase/pocketbase"
+	"github.com/pocketbase/pocketbase/core"
 	"github.com/pocketbase/pocketbase/plugins/migratecmd"
 
+	"github.com/ian-shakespeare/tribe-tracker/server/internal/config"
 	"github.com/ian-shakespeare/tribe-tracker/server/internal/handlers"
 	_ "github.com/ian-shakespeare/tribe-tracker/server/migrations"
 )
 
-func getEnvWithFallback(name, fallback string) string {
-	if value, exists := os.LookupEnv(name); exists {
-		return value
+func main() {
+	cfg, err := config.Load(os.Getenv("CONFIG_FILE"))
+	if err != nil {
+		log.Fatal(err)
 	}
 
-	return fallback
-}
-
-func main() {
 	app := pocketbase.New()
 
 	migratecmd.MustRegister(app, app.RootCmd, migratecmd.Config{
 		Automigrate: true,
 	})
 
-	handlers.Bind(app)
-	app.Settings().Meta.AppName = "Tribe Tracker"
-	app.Settings().Meta.AppURL = getEnvWithFallback("API_URL", "http://localhost:8090")
-	app.Settings().Meta.HideControls = true
+	handlers.Bind(app, cfg)
+
+	// the settings are loaded from the database during bootstrap, so the
+	// config is applied afterwards
+	app.OnBootstrap().BindFunc(func(e *core.BootstrapEvent) error {
+		if err := e.Next(); err != nil {
+			return err
+		}
+
+		return cfg.Apply(e.App)
+	})
 
 	if err := app.Start(); err != nil {
 		log.Fatal(err)
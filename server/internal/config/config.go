@@ -0,0 +1,329 @@
+// Package config loads the server configuration from an optional YAML file
+// and environment variables, and applies it to the PocketBase settings.
+package config
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	validation "github.com/go-ozzo/ozzo-validation/v4"
+	"github.com/go-ozzo/ozzo-validation/v4/is"
+	"github.com/pocketbase/pocketbase/core"
+	"gopkg.in/yaml.v3"
+)
+
+// Config is the server configuration. Environment variables override the
+// values of the file, which override the defaults. The custom routes and
+// hooks are bound with it too.
+//
+// The SMTP, Storage and Backups sections are optional: when they are neither
+// in the file nor in the environment, the settings made from the dashboard
+// are left alone.
+type Config struct {
+	App        App        `yaml:"app"`
+	SMTP       *SMTP      `yaml:"smtp"`
+	Storage    *S3        `yaml:"storage"`
+	Backups    *Backups   `yaml:"backups"`
+	Retention  Retention  `yaml:"retention"`
+	Quotas     Quotas     `yaml:"quotas"`
+	RateLimits RateLimits `yaml:"rateLimits"`
+	Push       Push       `yaml:"push"`
+}
+
+type App struct {
+	Name string `yaml:"name"`
+	// URL is the public URL of the server, used in emails and links.
+	URL string `yaml:"url"`
+	// SenderName and SenderAddress are the sender of emails. Empty values
+	// keep the current settings.
+	SenderName    string `yaml:"senderName"`
+	SenderAddress string `yaml:"senderAddress"`
+	HideControls  bool   `yaml:"hideControls"`
+}
+
+type SMTP struct {
+	Enabled  bool   `yaml:"enabled"`
+	Host     string `yaml:"host"`
+	Port     int    `yaml:"port"`
+	Username string `yaml:"username"`
+	Password string `yaml:"password"`
+	// AuthMethod is PLAIN or LOGIN.
+	AuthMethod string `yaml:"authMethod"`
+	TLS        bool   `yaml:"tls"`
+	LocalName  string `yaml:"localName"`
+}
+
+// S3 is an S3 compatible storage, used for uploaded files or backups.
+type S3 struct {
+	Enabled        bool   `yaml:"enabled"`
+	Bucket         string `yaml:"bucket"`
+	Region         string `yaml:"region"`
+	Endpoint       string `yaml:"endpoint"`
+	AccessKey      string `yaml:"accessKey"`
+	Secret         string `yaml:"secret"`
+	ForcePathStyle bool   `yaml:"forcePathStyle"`
+}
+
+type Backups struct {
+	// Cron schedules automatic backups. Empty disables them.
+	Cron    string `yaml:"cron"`
+	MaxKeep int    `yaml:"maxKeep"`
+	S3      S3     `yaml:"s3"`
+}
+
+type Retention struct {
+	// LogDays is how long request logs are kept. Zero disables logging.
+	LogDays int `yaml:"logDays"`
+	// LocationDays is how long recorded locations are kept. Zero keeps them
+	// forever.
+	LocationDays int `yaml:"locationDays"`
+}
+
+// Quotas limit how much a user or family can grow. A zero quota is
+// unlimited.
+type Quotas struct {
+	MembersPerFamily   int `yaml:"membersPerFamily"`
+	FamiliesPerUser    int `yaml:"familiesPerUser"`
+	LocationsPerMinute int `yaml:"locationsPerMinute"`
+}
+
+// RateLimit is a per-user token bucket. A zero PerMinute disables it.
+type RateLimit struct {
+	PerMinute float64 `yaml:"perMinute"`
+	Burst     int     `yaml:"burst"`
+}
+
+// RateLimits of the custom routes. Sync is applied to /mobile/sync and
+// /mobile/sync/push, which clients call the most, and Default to every other
+// /mobile route.
+type RateLimits struct {
+	Sync    RateLimit `yaml:"sync"`
+	Default RateLimit `yaml:"default"`
+}
+
+// Push holds the credentials of the push notification services.
+type Push struct {
+	// ExpoAccessToken authenticates requests to the Expo push service when
+	// enhanced push security is enabled for the project.
+	ExpoAccessToken string `yaml:"expoAccessToken"`
+}
+
+// Default quotas match the limits of the original members relation, with
+// room for a location update every few seconds. Default rate limits leave
+// room for a sync every few seconds with bursts when the app comes back to
+// the foreground.
+func Default() Config {
+	return Config{
+		App: App{
+			Name:         "Tribe Tracker",
+			URL:          "http://localhost:8090",
+			HideControls: true,
+		},
+		Retention: Retention{
+			LogDays: 5,
+		},
+		Quotas: Quotas{
+			MembersPerFamily:   99,
+			FamiliesPerUser:    10,
+			LocationsPerMinute: 12,
+		},
+		RateLimits: RateLimits{
+			Sync:    RateLimit{PerMinute: 30, Burst: 10},
+			Default: RateLimit{PerMinute: 60, Burst: 20},
+		},
+	}
+}
+
+// Load reads the YAML file at path, if any, then the environment, and
+// validates the result.
+func Load(path string) (Config, error) {
+	config := Default()
+
+	if path != "" {
+		if err := config.readFile(path); err != nil {
+			return Config{}, err
+		}
+	}
+
+	if err := config.readEnv(os.LookupEnv); err != nil {
+		return Config{}, err
+	}
+
+	if err := config.Validate(); err != nil {
+		return Config{}, fmt.Errorf("invalid config: %w", err)
+	}
+
+	return config, nil
+}
+
+func (c *Config) readFile(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to open config file: %w", err)
+	}
+	defer f.Close()
+
+	decoder := yaml.NewDecoder(f)
+	// misspelled keys would otherwise be silently ignored
+	decoder.KnownFields(true)
+
+	if err := decoder.Decode(c); err != nil && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("failed to read config file %s: %w", path, err)
+	}
+
+	return nil
+}
+
+func (c Config) Validate() error {
+	return validation.ValidateStruct(&c,
+		validation.Field(&c.App),
+		validation.Field(&c.SMTP, validation.By(validSettings(func(s SMTP) validation.Validatable { return s.settings() }))),
+		validation.Field(&c.Storage, validation.By(validSettings(func(s S3) validation.Validatable { return s.settings() }))),
+		validation.Field(&c.Backups, validation.By(validSettings(func(b Backups) validation.Validatable { return b.settings() }))),
+		validation.Field(&c.Retention),
+		validation.Field(&c.Quotas),
+		validation.Field(&c.RateLimits),
+	)
+}
+
+func (a App) Validate() error {
+	return validation.ValidateStruct(&a,
+		validation.Field(&a.Name, validation.Required, validation.Length(1, 255)),
+		validation.Field(&a.URL, validation.Required, is.URL),
+		validation.Field(&a.SenderName, validation.Length(0, 255)),
+		validation.Field(&a.SenderAddress, is.EmailFormat),
+	)
+}
+
+func (r Retention) Validate() error {
+	return validation.ValidateStruct(&r,
+		validation.Field(&r.LogDays, validation.Min(0)),
+		validation.Field(&r.LocationDays, validation.Min(0)),
+	)
+}
+
+func (q Quotas) Validate() error {
+	return validation.ValidateStruct(&q,
+		validation.Field(&q.MembersPerFamily, validation.Min(0)),
+		validation.Field(&q.FamiliesPerUser, validation.Min(0)),
+		validation.Field(&q.LocationsPerMinute, validation.Min(0)),
+	)
+}
+
+func (r RateLimits) Validate() error {
+	return validation.ValidateStruct(&r,
+		validation.Field(&r.Sync),
+		validation.Field(&r.Default),
+	)
+}
+
+func (r RateLimit) Validate() error {
+	return validation.ValidateStruct(&r,
+		validation.Field(&r.PerMinute, validation.Min(0.0)),
+		validation.Field(&r.Burst, validation.Min(0)),
+	)
+}
+
+// validSettings validates an optional section with the rules of the
+// PocketBase settings it is applied to.
+func validSettings[T any](settings func(T) validation.Validatable) validation.RuleFunc {
+	return func(value any) error {
+		section, _ := value.(*T)
+		if section == nil {
+			return nil
+		}
+
+		return settings(*section).Validate()
+	}
+}
+
+// Apply writes the configuration to the app settings. The settings are only
+// saved when they changed, so applying the same configuration on every start
+// is a no-op.
+func (c Config) Apply(app core.App) error {
+	settings, err := app.Settings().Clone()
+	if err != nil {
+		return err
+	}
+
+	before := appliedSettings(settings)
+
+	settings.Meta.AppName = c.App.Name
+	settings.Meta.AppURL = c.App.URL
+	settings.Meta.HideControls = c.App.HideControls
+	if c.App.SenderName != "" {
+		settings.Meta.SenderName = c.App.SenderName
+	}
+	if c.App.SenderAddress != "" {
+		settings.Meta.SenderAddress = c.App.SenderAddress
+	}
+
+	settings.Logs.MaxDays = c.Retention.LogDays
+
+	if c.SMTP != nil {
+		settings.SMTP = c.SMTP.settings()
+	}
+	if c.Storage != nil {
+		settings.S3 = c.Storage.settings()
+	}
+	if c.Backups != nil {
+		settings.Backups = c.Backups.settings()
+	}
+
+	if appliedSettings(settings) == before {
+		return nil
+	}
+
+	if err := app.Save(settings); err != nil {
+		return fmt.Errorf("failed to apply config: %w", err)
+	}
+
+	return nil
+}
+
+// settingsSnapshot holds the settings Apply writes. Unlike the settings JSON,
+// it includes the secrets so changing only a password is still saved.
+type settingsSnapshot struct {
+	Meta    core.MetaConfig
+	Logs    core.LogsConfig
+	SMTP    core.SMTPConfig
+	S3      core.S3Config
+	Backups core.BackupsConfig
+}
+
+func appliedSettings(settings *core.Settings) settingsSnapshot {
+	return settingsSnapshot{
+		Meta:    settings.Meta,
+		Logs:    settings.Logs,
+		SMTP:    settings.SMTP,
+		S3:      settings.S3,
+		Backups: settings.Backups,
+	}
+}
+
+func (s SMTP) settings() core.SMTPConfig {
+	return core.SMTPConfig{
+		Enabled:    s.Enabled,
+		Host:       s.Host,
+		Port:       s.Port,
+		Username:   s.Username,
+		Password:   s.Password,
+		AuthMethod: s.AuthMethod,
+		TLS:        s.TLS,
+		LocalName:  s.LocalName,
+	}
+}
+
+func (s S3) settings() core.S3Config {
+	return core.S3Config(s)
+}
+
+func (b Backups) settings() core.BackupsConfig {
+	return core.BackupsConfig{
+		Cron:        b.Cron,
+		CronMaxKeep: b.MaxKeep,
+		S3:          b.S3.settings(),
+	}
+}
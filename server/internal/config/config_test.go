@@ -0,0 +1,179 @@
+package config_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ian-shakespeare/tribe-tracker/server/internal/config"
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/tests"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	testDataDir = "../../testdata"
+
+	// paramsTable stores the app settings.
+	paramsTable = "_params"
+)
+
+func writeConfig(t *testing.T, content string) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	require.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+
+	return path
+}
+
+func TestLoad(t *testing.T) {
+	t.Run("defaults", func(t *testing.T) {
+		cfg, err := config.Load("")
+		require.NoError(t, err)
+		require.Equal(t, config.Default(), cfg)
+		require.Nil(t, cfg.SMTP)
+	})
+
+	t.Run("file", func(t *testing.T) {
+		path := writeConfig(t, `
+app:
+  name: Tribe Tracker Dev
+  url: https://tribe.example.com
+smtp:
+  enabled: true
+  host: smtp.example.com
+  port: 587
+quotas:
+  membersPerFamily: 20
+rateLimits:
+  sync:
+    perMinute: 10
+    burst: 2
+`)
+
+		cfg, err := config.Load(path)
+		require.NoError(t, err)
+		require.Equal(t, "Tribe Tracker Dev", cfg.App.Name)
+		require.Equal(t, "https://tribe.example.com", cfg.App.URL)
+		require.True(t, cfg.App.HideControls)
+		require.Equal(t, &config.SMTP{Enabled: true, Host: "smtp.example.com", Port: 587}, cfg.SMTP)
+		require.Nil(t, cfg.Storage)
+		require.Equal(t, 20, cfg.Quotas.MembersPerFamily)
+		require.Equal(t, config.Default().Quotas.FamiliesPerUser, cfg.Quotas.FamiliesPerUser)
+		require.Equal(t, config.RateLimit{PerMinute: 10, Burst: 2}, cfg.RateLimits.Sync)
+	})
+
+	t.Run("environment overrides the file", func(t *testing.T) {
+		path := writeConfig(t, `
+app:
+  url: https://tribe.example.com
+quotas:
+  membersPerFamily: 20
+`)
+		t.Setenv("API_URL", "https://tribe.example.org")
+		t.Setenv("QUOTA_MEMBERS_PER_FAMILY", "0")
+		t.Setenv("SMTP_HOST", "smtp.example.org")
+		t.Setenv("SMTP_PORT", "2525")
+		t.Setenv("BACKUPS_CRON", "0 3 * * *")
+		t.Setenv("BACKUPS_MAX_KEEP", "7")
+		t.Setenv("LOCATION_RETENTION_DAYS", "30")
+		t.Setenv("PUSH_EXPO_ACCESS_TOKEN", "expo-token")
+
+		cfg, err := config.Load(path)
+		require.NoError(t, err)
+		require.Equal(t, "https://tribe.example.org", cfg.App.URL)
+		require.Equal(t, 0, cfg.Quotas.MembersPerFamily)
+		require.Equal(t, &config.SMTP{Host: "smtp.example.org", Port: 2525}, cfg.SMTP)
+		require.Equal(t, &config.Backups{Cron: "0 3 * * *", MaxKeep: 7}, cfg.Backups)
+		require.Equal(t, 30, cfg.Retention.LocationDays)
+		require.Equal(t, "expo-token", cfg.Push.ExpoAccessToken)
+		require.Nil(t, cfg.Storage)
+	})
+
+	t.Run("example", func(t *testing.T) {
+		cfg, err := config.Load("../../config.example.yaml")
+		require.NoError(t, err)
+		require.Equal(t, "https://tribe.example.com", cfg.App.URL)
+		require.NotNil(t, cfg.Storage)
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		for name, content := range map[string]string{
+			"unknown key":         "app:\n  nmae: Typo\n",
+			"invalid url":         "app:\n  url: not a url\n",
+			"negative quota":      "quotas:\n  familiesPerUser: -1\n",
+			"negative retention":  "retention:\n  locationDays: -1\n",
+			"smtp without host":   "smtp:\n  enabled: true\n  port: 587\n",
+			"invalid backup cron": "backups:\n  cron: every night\n  maxKeep: 3\n",
+		} {
+			t.Run(name, func(t *testing.T) {
+				_, err := config.Load(writeConfig(t, content))
+				require.Error(t, err)
+			})
+		}
+	})
+
+	t.Run("invalid environment", func(t *testing.T) {
+		t.Setenv("RATE_LIMIT_BURST", "lots")
+
+		_, err := config.Load("")
+		require.ErrorContains(t, err, "RATE_LIMIT_BURST")
+	})
+
+	t.Run("missing file", func(t *testing.T) {
+		_, err := config.Load(filepath.Join(t.TempDir(), "missing.yaml"))
+		require.Error(t, err)
+	})
+}
+
+func TestApply(t *testing.T) {
+	app, err := tests.NewTestApp(testDataDir)
+	require.NoError(t, err)
+	defer app.Cleanup()
+
+	saves := 0
+	app.OnModelUpdate(paramsTable).BindFunc(func(e *core.ModelEvent) error {
+		saves++
+		return e.Next()
+	})
+	app.OnModelCreate(paramsTable).BindFunc(func(e *core.ModelEvent) error {
+		saves++
+		return e.Next()
+	})
+
+	smtpBefore := app.Settings().SMTP
+
+	cfg := config.Default()
+	cfg.App.URL = "https://tribe.example.com"
+	cfg.App.SenderAddress = "noreply@tribe.example.com"
+	cfg.Retention.LogDays = 30
+	cfg.Backups = &config.Backups{Cron: "0 3 * * *", MaxKeep: 7}
+
+	require.NoError(t, cfg.Apply(app))
+	require.Equal(t, 1, saves)
+
+	settings := app.Settings()
+	require.Equal(t, "Tribe Tracker", settings.Meta.AppName)
+	require.Equal(t, "https://tribe.example.com", settings.Meta.AppURL)
+	require.Equal(t, "noreply@tribe.example.com", settings.Meta.SenderAddress)
+	require.True(t, settings.Meta.HideControls)
+	require.Equal(t, 30, settings.Logs.MaxDays)
+	require.Equal(t, "0 3 * * *", settings.Backups.Cron)
+	require.Equal(t, 7, settings.Backups.CronMaxKeep)
+	require.Equal(t, smtpBefore, settings.SMTP)
+
+	// applying the same config again doesn't save anything
+	require.NoError(t, cfg.Apply(app))
+	require.Equal(t, 1, saves)
+
+	// secrets are compared too
+	cfg.SMTP = &config.SMTP{Host: "smtp.example.com", Port: 587, Password: "hunter2"}
+	require.NoError(t, cfg.Apply(app))
+	require.Equal(t, 2, saves)
+
+	cfg.SMTP.Password = "hunter3"
+	require.NoError(t, cfg.Apply(app))
+	require.Equal(t, 3, saves)
+	require.Equal(t, "hunter3", app.Settings().SMTP.Password)
+}
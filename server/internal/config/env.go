@@ -0,0 +1,140 @@
+package config
+
+import (
+	"fmt"
+	"strconv"
+)
+
+// lookupFunc looks up an environment variable, like os.LookupEnv.
+type lookupFunc func(name string) (string, bool)
+
+// envVar binds an environment variable to a config value, which must be a
+// *string, *int, *float64 or *bool.
+type envVar struct {
+	name  string
+	value any
+}
+
+func (c *Config) readEnv(lookup lookupFunc) error {
+	vars := []envVar{
+		{"APP_NAME", &c.App.Name},
+		{"API_URL", &c.App.URL},
+		{"MAIL_SENDER_NAME", &c.App.SenderName},
+		{"MAIL_SENDER_ADDRESS", &c.App.SenderAddress},
+		{"LOG_RETENTION_DAYS", &c.Retention.LogDays},
+		{"LOCATION_RETENTION_DAYS", &c.Retention.LocationDays},
+		{"QUOTA_MEMBERS_PER_FAMILY", &c.Quotas.MembersPerFamily},
+		{"QUOTA_FAMILIES_PER_USER", &c.Quotas.FamiliesPerUser},
+		{"QUOTA_LOCATIONS_PER_MINUTE", &c.Quotas.LocationsPerMinute},
+		{"RATE_LIMIT_SYNC_PER_MINUTE", &c.RateLimits.Sync.PerMinute},
+		{"RATE_LIMIT_SYNC_BURST", &c.RateLimits.Sync.Burst},
+		{"RATE_LIMIT_PER_MINUTE", &c.RateLimits.Default.PerMinute},
+		{"RATE_LIMIT_BURST", &c.RateLimits.Default.Burst},
+		{"PUSH_EXPO_ACCESS_TOKEN", &c.Push.ExpoAccessToken},
+	}
+
+	// the optional sections are only created when one of their variables
+	// is set
+	if c.SMTP == nil && anySet(lookup, smtpEnv(&SMTP{})) {
+		c.SMTP = &SMTP{}
+	}
+	if c.SMTP != nil {
+		vars = append(vars, smtpEnv(c.SMTP)...)
+	}
+
+	if c.Storage == nil && anySet(lookup, s3Env("S3_", &S3{})) {
+		c.Storage = &S3{}
+	}
+	if c.Storage != nil {
+		vars = append(vars, s3Env("S3_", c.Storage)...)
+	}
+
+	if c.Backups == nil && anySet(lookup, backupsEnv(&Backups{})) {
+		c.Backups = &Backups{}
+	}
+	if c.Backups != nil {
+		vars = append(vars, backupsEnv(c.Backups)...)
+	}
+
+	for _, v := range vars {
+		if err := v.read(lookup); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func smtpEnv(s *SMTP) []envVar {
+	return []envVar{
+		{"SMTP_ENABLED", &s.Enabled},
+		{"SMTP_HOST", &s.Host},
+		{"SMTP_PORT", &s.Port},
+		{"SMTP_USERNAME", &s.Username},
+		{"SMTP_PASSWORD", &s.Password},
+		{"SMTP_AUTH_METHOD", &s.AuthMethod},
+		{"SMTP_TLS", &s.TLS},
+		{"SMTP_LOCAL_NAME", &s.LocalName},
+	}
+}
+
+func s3Env(prefix string, s *S3) []envVar {
+	return []envVar{
+		{prefix + "ENABLED", &s.Enabled},
+		{prefix + "BUCKET", &s.Bucket},
+		{prefix + "REGION", &s.Region},
+		{prefix + "ENDPOINT", &s.Endpoint},
+		{prefix + "ACCESS_KEY", &s.AccessKey},
+		{prefix + "SECRET", &s.Secret},
+		{prefix + "FORCE_PATH_STYLE", &s.ForcePathStyle},
+	}
+}
+
+func backupsEnv(b *Backups) []envVar {
+	return append([]envVar{
+		{"BACKUPS_CRON", &b.Cron},
+		{"BACKUPS_MAX_KEEP", &b.MaxKeep},
+	}, s3Env("BACKUPS_S3_", &b.S3)...)
+}
+
+func anySet(lookup lookupFunc, vars []envVar) bool {
+	for _, v := range vars {
+		if _, ok := lookup(v.name); ok {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (v envVar) read(lookup lookupFunc) error {
+	raw, ok := lookup(v.name)
+	if !ok {
+		return nil
+	}
+
+	var err error
+	switch value := v.value.(type) {
+	case *string:
+		*value = raw
+	case *int:
+		*value, err = strconv.Atoi(raw)
+		if err != nil {
+			return fmt.Errorf("%s must be an integer: %w", v.name, err)
+		}
+	case *float64:
+		*value, err = strconv.ParseFloat(raw, 64)
+		if err != nil {
+			return fmt.Errorf("%s must be a number: %w", v.name, err)
+		}
+	case *bool:
+		*value, err = strconv.ParseBool(raw)
+		if err != nil {
+			return fmt.Errorf("%s must be true or false: %w", v.name, err)
+		}
+	default:
+		panic(fmt.Sprintf("unsupported config type %T", v.value))
+	}
+
+	return nil
+}
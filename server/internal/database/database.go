@@ -1,15 +1,98 @@
 package database
 
 import (
+	"encoding/json"
+	"errors"
+	"fmt"
 	"strings"
 	"time"
 
+	validation "github.com/go-ozzo/ozzo-validation/v4"
 	"github.com/ian-shakespeare/tribe-tracker/server/pkg/models"
 	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/tools/filesystem"
+	"github.com/pocketbase/pocketbase/tools/types"
 )
 
-func GetRecentUsers(db dbx.Builder, userId string, after time.Time) ([]models.User, error) {
-	afterStr := strings.ReplaceAll(after.Format(time.RFC3339), "T", " ")
+// IsUniqueViolation reports whether err was caused by a write that violated
+// a unique index.
+func IsUniqueViolation(err error) bool {
+	if err == nil {
+		return false
+	}
+
+	var errs validation.Errors
+	if errors.As(err, &errs) {
+		for _, fieldErr := range errs {
+			var validationErr validation.Error
+			if errors.As(fieldErr, &validationErr) && validationErr.Code() == "validation_not_unique" {
+				return true
+			}
+		}
+	}
+
+	return strings.Contains(err.Error(), "UNIQUE constraint failed")
+}
+
+// formatTime formats t the way PocketBase stores datetimes so that it can be
+// compared against date columns. The zero time formats as an empty string.
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+
+	return t.UTC().Format(types.DefaultDateLayout)
+}
+
+// familyFilter returns an SQL condition restricting column to the given
+// families, binding their ids into params. Without families it matches
+// every row.
+func familyFilter(column string, families []string, params dbx.Params) string {
+	if len(families) == 0 {
+		return "1 = 1"
+	}
+
+	placeholders := make([]string, len(families))
+	for i, family := range families {
+		name := fmt.Sprintf("family%d", i)
+		params[name] = family
+		placeholders[i] = "{:" + name + "}"
+	}
+
+	return column + " in (" + strings.Join(placeholders, ", ") + ")"
+}
+
+// sharingDeviceFilter returns an SQL condition keeping the locations sent
+// from the device currently sharing its user's location, joined as devices.
+// Locations without a device, sent before the user registered one, are only
+// kept while none of the user's devices shares its location.
+func sharingDeviceFilter(locations, devices string) string {
+	return `((` + devices + `.sharesLocation = true and ` + devices + `.revokedAt = '')
+      or (` + locations + `.device = '' and not exists (
+        select 1
+        from devices sd
+        where sd.user = ` + locations + `.user
+          and sd.sharesLocation = true
+          and sd.revokedAt = ''
+      )))`
+}
+
+// ReadTransaction runs fn in a single read transaction so that all of its
+// queries observe the same snapshot of the database.
+func ReadTransaction(app core.App, fn func(tx dbx.Builder) error) error {
+	db, ok := app.ConcurrentDB().(*dbx.DB)
+	if !ok {
+		return fn(app.ConcurrentDB())
+	}
+
+	return db.Transactional(func(tx *dbx.Tx) error {
+		return fn(tx)
+	})
+}
+
+func GetRecentUsers(db dbx.Builder, userId string, familyIds []string, after time.Time) ([]models.User, error) {
+	params := dbx.Params{"after": formatTime(after), "userId": userId}
 
 	query := `
     select u.id,
@@ -19,52 +102,62 @@ func GetRecentUsers(db dbx.Builder, userId string, after time.Time) ([]models.Us
       u.avatar,
       u.createdAt,
       max(u.updatedAt) updatedAt,
-      u.isDeleted
+      u.isDeleted,
+      u.version
     from familyMembers me
     join familyMembers fm
       on me.family = fm.family
     join users u
       on fm.user = u.id
     where me.user = {:userId}
+      and ` + familyFilter("me.family", familyIds, params) + `
       and u.updatedAt >= {:after}
     group by u.id
   `
 
 	var users []models.User
-	err := db.NewQuery(query).Bind(dbx.Params{"after": afterStr, "userId": userId}).All(&users)
+	err := db.NewQuery(query).Bind(params).All(&users)
 	return users, err
 }
 
-func GetRecentFamilies(db dbx.Builder, userId string, after time.Time) ([]models.Family, error) {
-	afterStr := strings.ReplaceAll(after.Format(time.RFC3339), "T", " ")
+func GetRecentFamilies(db dbx.Builder, userId string, familyIds []string, after time.Time) ([]models.Family, error) {
+	params := dbx.Params{"after": formatTime(after), "userId": userId}
 
 	query := `
     select f.id,
       f.name,
+      f.description,
+      f.icon,
+      f.color,
+      f.sharingPrecision,
+      f.image,
       f.createdBy,
       f.createdAt,
       max(f.updatedAt) updatedAt,
-      f.isDeleted
+      f.isDeleted,
+      f.version
     from familyMembers me
     join families f
       on me.family = f.id
     where me.user = {:userId}
+      and ` + familyFilter("me.family", familyIds, params) + `
       and f.updatedAt > {:after}
     group by f.id
   `
 
 	var families []models.Family
-	err := db.NewQuery(query).Bind(dbx.Params{"after": afterStr, "userId": userId}).All(&families)
+	err := db.NewQuery(query).Bind(params).All(&families)
 	return families, err
 }
 
-func GetRecentFamilyMembers(db dbx.Builder, userId string, after time.Time) ([]models.FamilyMember, error) {
-	afterStr := strings.ReplaceAll(after.Format(time.RFC3339), "T", " ")
+func GetRecentFamilyMembers(db dbx.Builder, userId string, familyIds []string, after time.Time) ([]models.FamilyMember, error) {
+	params := dbx.Params{"after": formatTime(after), "userId": userId}
 
 	query := `
     select fm.id,
       fm.family,
       fm.user,
+      fm.role,
       fm.createdAt
     from familyMembers me
     join families f
@@ -72,22 +165,49 @@ func GetRecentFamilyMembers(db dbx.Builder, userId string, after time.Time) ([]m
     join familyMembers fm
       on f.id = fm.family
     where me.user = {:userId}
+      and ` + familyFilter("me.family", familyIds, params) + `
       and fm.createdAt > {:after}
   `
 
 	var familyMembers []models.FamilyMember
-	err := db.NewQuery(query).Bind(dbx.Params{"after": afterStr, "userId": userId}).All(&familyMembers)
+	err := db.NewQuery(query).Bind(params).All(&familyMembers)
 	return familyMembers, err
 }
 
-func GetRecentLocations(db dbx.Builder, userId string, after time.Time) ([]models.Location, error) {
-	afterStr := strings.ReplaceAll(after.Format(time.RFC3339), "T", " ")
+// GetRecentDeletedFamilyMembers returns the ids of the family members
+// deleted after the given time from the user's families, including the
+// user's own memberships.
+func GetRecentDeletedFamilyMembers(db dbx.Builder, userId string, familyIds []string, after time.Time) ([]string, error) {
+	params := dbx.Params{"after": formatTime(after), "userId": userId}
+
+	query := `
+    select d.member
+    from deletedFamilyMembers d
+    where (d.user = {:userId}
+        or d.family in (
+          select me.family
+          from familyMembers me
+          where me.user = {:userId}
+        ))
+      and ` + familyFilter("d.family", familyIds, params) + `
+      and d.deletedAt > {:after}
+  `
+
+	ids := []string{}
+	err := db.NewQuery(query).Bind(params).Column(&ids)
+	return ids, err
+}
+
+func GetRecentLocations(db dbx.Builder, userId string, familyIds []string, after time.Time) ([]models.Location, error) {
+	params := dbx.Params{"after": formatTime(after), "userId": userId}
 
 	query := `
     select l.id,
       l.user,
+      l.device,
       l.coordinates,
-      max(l.createdAt) createdAt
+      l.createdAt,
+      max(l.recordedAt) recordedAt
     from familyMembers me
     join familyMembers fm
       on me.family = fm.family
@@ -95,64 +215,1042 @@ func GetRecentLocations(db dbx.Builder, userId string, after time.Time) ([]model
       on fm.user = u.id
     join locations l
       on u.id = l.user
+    left join devices d
+      on l.device = d.id
     where me.user = {:userId}
+      and ` + familyFilter("me.family", familyIds, params) + `
       and l.createdAt > {:after}
+      and ` + sharingDeviceFilter("l", "d") + `
     group by l.user
   `
 
 	var locations []models.Location
-	err := db.NewQuery(query).Bind(dbx.Params{"after": afterStr, "userId": userId}).All(&locations)
+	err := db.NewQuery(query).Bind(params).All(&locations)
 	return locations, err
 }
 
-func CreateFamily(db dbx.Builder, userId, name, code string) (models.Family, error) {
-	now := strings.ReplaceAll(time.Now().Format(time.RFC3339), "T", " ")
-
-	query := `
-  insert into families (
-    name,
-    code,
-    createdBy,
-    createdAt,
-    updatedAt
-  ) values (
-    {:name},
-    {:code},
-    {:userId},
-    {:now},
-    {:now}
-  ) returning id,
-    name,
-    code,
-    createdBy,
-    createdAt,
-    updatedAt
-  `
-
-	var f models.Family
-	err := db.NewQuery(query).Bind(dbx.Params{"name": name, "code": code, "userId": userId, "now": now}).One(&f)
-	return f, err
-}
-
-func CreateFamilyMember(db dbx.Builder, familyId, userId string) (models.FamilyMember, error) {
-	now := strings.ReplaceAll(time.Now().Format(time.RFC3339), "T", " ")
-
-	query := `
-  insert into familyMembers (
-    family,
-    user,
-    createdAt
-  ) values (
-    {:familyId},
-    {:userId},
-    {:now}
-  ) returning id,
-    family,
-    user,
-    createdAt
-  `
-
-	var fm models.FamilyMember
-	err := db.NewQuery(query).Bind(dbx.Params{"familyId": familyId, "userId": userId, "now": now}).One(&fm)
-	return fm, err
+// GetSharingPrecisions returns the sharingPrecision of every family the user
+// shares with each other member, keyed by member.
+func GetSharingPrecisions(db dbx.Builder, userId string, familyIds []string) (map[string][]string, error) {
+	params := dbx.Params{"userId": userId}
+
+	query := `
+    select distinct fm.user,
+      f.sharingPrecision
+    from familyMembers me
+    join families f
+      on me.family = f.id
+    join familyMembers fm
+      on f.id = fm.family
+    where me.user = {:userId}
+      and ` + familyFilter("me.family", familyIds, params) + `
+      and f.isDeleted = false
+  `
+
+	var rows []struct {
+		User             string `db:"user"`
+		SharingPrecision string `db:"sharingPrecision"`
+	}
+	if err := db.NewQuery(query).Bind(params).All(&rows); err != nil {
+		return nil, err
+	}
+
+	precisions := make(map[string][]string, len(rows))
+	for _, row := range rows {
+		precisions[row.User] = append(precisions[row.User], row.SharingPrecision)
+	}
+
+	return precisions, nil
+}
+
+func GetFamily(db dbx.Builder, familyId string) (models.Family, error) {
+	query := `
+    select f.id,
+      f.name,
+      f.description,
+      f.icon,
+      f.color,
+      f.sharingPrecision,
+      f.image,
+      f.createdBy,
+      f.createdAt,
+      f.updatedAt,
+      f.isDeleted,
+      f.version
+    from families f
+    where f.id = {:familyId}
+      and f.isDeleted = false
+  `
+
+	var family models.Family
+	err := db.NewQuery(query).Bind(dbx.Params{"familyId": familyId}).One(&family)
+	return family, err
+}
+
+func GetUser(db dbx.Builder, userId string) (models.User, error) {
+	query := `
+    select u.id,
+      u.email,
+      u.firstName,
+      u.lastName,
+      u.avatar,
+      u.createdAt,
+      u.updatedAt,
+      u.isDeleted,
+      u.version
+    from users u
+    where u.id = {:userId}
+      and u.isDeleted = false
+  `
+
+	var user models.User
+	err := db.NewQuery(query).Bind(dbx.Params{"userId": userId}).One(&user)
+	return user, err
+}
+
+func GetLocation(db dbx.Builder, locationId string) (models.Location, error) {
+	query := `
+    select l.id,
+      l.user,
+      l.device,
+      l.coordinates,
+      l.createdAt,
+      l.recordedAt
+    from locations l
+    where l.id = {:locationId}
+  `
+
+	var location models.Location
+	err := db.NewQuery(query).Bind(dbx.Params{"locationId": locationId}).One(&location)
+	return location, err
+}
+
+func GetFamilyMember(db dbx.Builder, familyId, userId string) (models.FamilyMember, error) {
+	query := `
+    select fm.id,
+      fm.family,
+      fm.user,
+      fm.role,
+      fm.createdAt
+    from familyMembers fm
+    where fm.family = {:familyId}
+      and fm.user = {:userId}
+  `
+
+	var familyMember models.FamilyMember
+	err := db.NewQuery(query).Bind(dbx.Params{"familyId": familyId, "userId": userId}).One(&familyMember)
+	return familyMember, err
+}
+
+// CountFamilyMembers returns the number of members of the family.
+func CountFamilyMembers(db dbx.Builder, familyId string) (int, error) {
+	var count int
+	err := db.Select("count(*)").From("familyMembers").Where(dbx.HashExp{"family": familyId}).Row(&count)
+	return count, err
+}
+
+// CountUserFamilies returns the number of families the user is a member of,
+// ignoring deleted families.
+func CountUserFamilies(db dbx.Builder, userId string) (int, error) {
+	query := `
+    select count(*)
+    from familyMembers fm
+    join families f
+      on fm.family = f.id
+    where fm.user = {:userId}
+      and f.isDeleted = false
+  `
+
+	var count int
+	err := db.NewQuery(query).Bind(dbx.Params{"userId": userId}).Row(&count)
+	return count, err
+}
+
+// CountRecentLocations returns the number of locations the user recorded
+// after the given time, along with the time of the oldest of them.
+func CountRecentLocations(db dbx.Builder, userId string, after time.Time) (int, types.DateTime, error) {
+	query := `
+    select count(*),
+      coalesce(min(l.createdAt), '')
+    from locations l
+    where l.user = {:userId}
+      and l.createdAt > {:after}
+  `
+
+	var (
+		count  int
+		oldest string
+	)
+	err := db.NewQuery(query).Bind(dbx.Params{"userId": userId, "after": formatTime(after)}).Row(&count, &oldest)
+	if err != nil {
+		return 0, types.DateTime{}, err
+	}
+
+	oldestTime, _ := types.ParseDateTime(oldest)
+	return count, oldestTime, nil
+}
+
+func GetMembers(db dbx.Builder, familyId string) ([]models.Member, error) {
+	query := `
+    select fm.id,
+      fm.user,
+      u.email,
+      u.firstName,
+      u.lastName,
+      u.avatar,
+      fm.role,
+      fm.createdAt
+    from familyMembers fm
+    join users u
+      on fm.user = u.id
+    where fm.family = {:familyId}
+      and u.isDeleted = false
+    order by fm.createdAt
+  `
+
+	var members []models.Member
+	err := db.NewQuery(query).Bind(dbx.Params{"familyId": familyId}).All(&members)
+	return members, err
+}
+
+// GetLatestLocations returns the most recently recorded location of each
+// family member, only considering locations created after the given time and
+// sent from the device sharing the member's location.
+func GetLatestLocations(db dbx.Builder, familyId string, after time.Time) ([]models.Location, error) {
+	afterStr := formatTime(after)
+
+	query := `
+    select l.id,
+      l.user,
+      l.device,
+      l.coordinates,
+      l.createdAt,
+      max(l.recordedAt) recordedAt
+    from familyMembers fm
+    join locations l
+      on fm.user = l.user
+    left join devices d
+      on l.device = d.id
+    where fm.family = {:familyId}
+      and l.createdAt > {:after}
+      and ` + sharingDeviceFilter("l", "d") + `
+    group by l.user
+  `
+
+	var locations []models.Location
+	err := db.NewQuery(query).Bind(dbx.Params{"familyId": familyId, "after": afterStr}).All(&locations)
+	return locations, err
+}
+
+func GetPlaces(db dbx.Builder, familyId string) ([]models.Place, error) {
+	query := `
+    select p.id,
+      p.family,
+      p.name,
+      p.coordinates,
+      p.radius,
+      p.createdAt,
+      p.updatedAt
+    from places p
+    where p.family = {:familyId}
+  `
+
+	var places []models.Place
+	err := db.NewQuery(query).Bind(dbx.Params{"familyId": familyId}).All(&places)
+	return places, err
+}
+
+// GetPendingInvitations returns the unexpired pending invitations to the
+// family whose recipient hasn't joined yet.
+func GetPendingInvitations(db dbx.Builder, familyId string) ([]models.Invitation, error) {
+	query := `
+    select i.id,
+      i.sender,
+      i.recipient,
+      i.email,
+      i.family,
+      i.status,
+      i.expiresAt,
+      i.sentAt,
+      i.createdAt
+    from invitations i
+    where i.family = {:familyId}
+      and i.status = {:pending}
+      and i.expiresAt > {:now}
+      and not exists (
+        select 1
+        from familyMembers fm
+        where fm.family = i.family
+          and fm.user = i.recipient
+      )
+    order by i.createdAt
+  `
+
+	var invitations []models.Invitation
+	err := db.NewQuery(query).Bind(dbx.Params{
+		"familyId": familyId,
+		"pending":  models.InvitationPending,
+		"now":      formatTime(time.Now()),
+	}).All(&invitations)
+	return invitations, err
+}
+
+// GetInvitation returns an invitation by id.
+func GetInvitation(db dbx.Builder, invitationId string) (models.Invitation, error) {
+	query := `
+    select i.id,
+      i.sender,
+      i.recipient,
+      i.email,
+      i.family,
+      i.status,
+      i.expiresAt,
+      i.sentAt,
+      i.createdAt
+    from invitations i
+    where i.id = {:invitationId}
+  `
+
+	var invitation models.Invitation
+	err := db.NewQuery(query).Bind(dbx.Params{"invitationId": invitationId}).One(&invitation)
+	return invitation, err
+}
+
+// GetVersion returns the stored version of a record in a versioned
+// collection.
+func GetVersion(db dbx.Builder, collection, id string) (int, error) {
+	var version int
+	err := db.Select("version").From(collection).Where(dbx.HashExp{"id": id}).Row(&version)
+	return version, err
+}
+
+// SyncVersion summarizes the data visible to a user through sync. It changes
+// whenever a synced record visible to the user is created, updated or
+// removed, so it can be used to cheaply detect that nothing changed.
+type SyncVersion struct {
+	UsersUpdatedAt     string `db:"usersUpdatedAt"`
+	FamiliesUpdatedAt  string `db:"familiesUpdatedAt"`
+	MembersCreatedAt   string `db:"membersCreatedAt"`
+	MembersCount       int    `db:"membersCount"`
+	MembersDeletedAt   string `db:"membersDeletedAt"`
+	LocationsCreatedAt string `db:"locationsCreatedAt"`
+	DevicesUpdatedAt   string `db:"devicesUpdatedAt"`
+}
+
+// GetSyncVersion returns the version of the user's data in the given
+// families, or in all of their families if none are given.
+func GetSyncVersion(db dbx.Builder, userId string, familyIds []string) (SyncVersion, error) {
+	params := dbx.Params{"userId": userId}
+
+	query := `
+    with shared as (
+      select fm.family,
+        fm.user
+      from familyMembers me
+      join familyMembers fm
+        on me.family = fm.family
+      where me.user = {:userId}
+        and ` + familyFilter("me.family", familyIds, params) + `
+    )
+    select coalesce((
+        select max(u.updatedAt)
+        from shared s
+        join users u
+          on s.user = u.id
+      ), '') usersUpdatedAt,
+      coalesce((
+        select max(f.updatedAt)
+        from shared s
+        join families f
+          on s.family = f.id
+      ), '') familiesUpdatedAt,
+      coalesce((
+        select max(fm.createdAt)
+        from familyMembers fm
+        where fm.family in (select s.family from shared s)
+      ), '') membersCreatedAt,
+      (select count(*) from shared) membersCount,
+      coalesce((
+        select max(d.deletedAt)
+        from deletedFamilyMembers d
+        where (d.user = {:userId}
+            or d.family in (select s.family from shared s))
+          and ` + familyFilter("d.family", familyIds, params) + `
+      ), '') membersDeletedAt,
+      coalesce((
+        select max(l.createdAt)
+        from locations l
+        where l.user in (select s.user from shared s)
+      ), '') locationsCreatedAt,
+      coalesce((
+        select max(d.updatedAt)
+        from devices d
+        where d.user in (select s.user from shared s)
+      ), '') devicesUpdatedAt
+  `
+
+	var version SyncVersion
+	err := db.NewQuery(query).Bind(params).One(&version)
+	return version, err
+}
+
+// CreateDeletedFamilyMember records that the family member was deleted, so
+// sync can tell clients to remove it.
+func CreateDeletedFamilyMember(app core.App, familyMember models.FamilyMember) error {
+	collection, err := app.FindCachedCollectionByNameOrId("deletedFamilyMembers")
+	if err != nil {
+		return err
+	}
+
+	record := core.NewRecord(collection)
+	record.Set("member", familyMember.ID)
+	record.Set("family", familyMember.Family)
+	record.Set("user", familyMember.User)
+
+	return app.Save(record)
+}
+
+// CreateFamily saves a new family record created by the given user. The
+// record goes through app.Save, so field validation, autodate fields, record
+// hooks and realtime subscriptions all observe the write.
+func CreateFamily(app core.App, userId, name, code string) (models.Family, error) {
+	collection, err := app.FindCachedCollectionByNameOrId("families")
+	if err != nil {
+		return models.Family{}, err
+	}
+
+	record := core.NewRecord(collection)
+	record.Set("name", name)
+	record.Set("code", code)
+	record.Set("createdBy", userId)
+	record.Set("sharingPrecision", models.PrecisionExact)
+
+	if err := app.Save(record); err != nil {
+		return models.Family{}, err
+	}
+
+	return newFamily(record), nil
+}
+
+// CreateFamilyMember saves a new membership of the user in the family with
+// the given role.
+func CreateFamilyMember(app core.App, familyId, userId, role string) (models.FamilyMember, error) {
+	collection, err := app.FindCachedCollectionByNameOrId("familyMembers")
+	if err != nil {
+		return models.FamilyMember{}, err
+	}
+
+	record := core.NewRecord(collection)
+	record.Set("family", familyId)
+	record.Set("user", userId)
+	record.Set("role", role)
+
+	if err := app.Save(record); err != nil {
+		return models.FamilyMember{}, err
+	}
+
+	return newFamilyMember(record), nil
+}
+
+// CreateInvitation saves a new invitation to either a user or an email
+// address.
+func CreateInvitation(app core.App, invitation models.Invitation) (models.Invitation, error) {
+	collection, err := app.FindCachedCollectionByNameOrId("invitations")
+	if err != nil {
+		return models.Invitation{}, err
+	}
+
+	record := core.NewRecord(collection)
+	record.Set("sender", invitation.Sender)
+	record.Set("recipient", invitation.Recipient)
+	record.Set("email", invitation.Email)
+	record.Set("family", invitation.Family)
+	record.Set("status", invitation.Status)
+	record.Set("expiresAt", invitation.ExpiresAt)
+	record.Set("sentAt", invitation.SentAt)
+
+	if err := app.Save(record); err != nil {
+		return models.Invitation{}, err
+	}
+
+	return newInvitation(record), nil
+}
+
+// UpdateInvitation saves the status and delivery times of an invitation.
+func UpdateInvitation(app core.App, invitation models.Invitation) (models.Invitation, error) {
+	record, err := app.FindRecordById("invitations", invitation.ID)
+	if err != nil {
+		return models.Invitation{}, err
+	}
+
+	record.Set("status", invitation.Status)
+	record.Set("expiresAt", invitation.ExpiresAt)
+	record.Set("sentAt", invitation.SentAt)
+
+	if err := app.Save(record); err != nil {
+		return models.Invitation{}, err
+	}
+
+	return newInvitation(record), nil
+}
+
+// DeleteInvitation deletes an invitation that was never delivered.
+func DeleteInvitation(app core.App, invitationId string) error {
+	record, err := app.FindRecordById("invitations", invitationId)
+	if err != nil {
+		return err
+	}
+
+	return app.Delete(record)
+}
+
+// ExpireInvitations marks the pending invitations that expired before now as
+// expired and returns how many there were.
+func ExpireInvitations(app core.App, now time.Time) (int, error) {
+	records, err := app.FindAllRecords("invitations",
+		dbx.HashExp{"status": models.InvitationPending},
+		dbx.NewExp("expiresAt <= {:now}", dbx.Params{"now": formatTime(now)}),
+	)
+	if err != nil {
+		return 0, err
+	}
+
+	for _, record := range records {
+		record.Set("status", models.InvitationExpired)
+
+		if err := app.Save(record); err != nil {
+			return 0, err
+		}
+	}
+
+	return len(records), nil
+}
+
+// DeleteLocationsBefore deletes the locations recorded before the given time,
+// returning how many were deleted.
+func DeleteLocationsBefore(db dbx.Builder, before time.Time) (int64, error) {
+	result, err := db.Delete("locations", dbx.NewExp("recordedAt < {:before}", dbx.Params{"before": formatTime(before)})).Execute()
+	if err != nil {
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
+
+// AddressEmailInvitations turns the invitations sent to an email address into
+// invitations to the user who signed up with it. Invitations to a family the
+// user already has a pending invitation to are revoked as duplicates.
+func AddressEmailInvitations(app core.App, userId, email string) error {
+	return app.RunInTransaction(func(txApp core.App) error {
+		records, err := txApp.FindAllRecords("invitations",
+			dbx.HashExp{"status": models.InvitationPending},
+			dbx.NewExp("recipient = '' and email = lower({:email})", dbx.Params{"email": email}),
+		)
+		if err != nil {
+			return err
+		}
+
+		for _, record := range records {
+			var pending int
+			err := txApp.DB().Select("count(*)").From("invitations").Where(dbx.HashExp{
+				"family":    record.GetString("family"),
+				"recipient": userId,
+				"status":    models.InvitationPending,
+			}).Row(&pending)
+			if err != nil {
+				return err
+			}
+
+			if pending > 0 {
+				record.Set("status", models.InvitationRevoked)
+			} else {
+				record.Set("recipient", userId)
+			}
+
+			if err := txApp.Save(record); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+// UpdateUser saves the profile fields of an existing user.
+func UpdateUser(app core.App, user models.User) (models.User, error) {
+	record, err := app.FindRecordById("users", user.ID)
+	if err != nil {
+		return models.User{}, err
+	}
+
+	record.Set("firstName", user.FirstName)
+	record.Set("lastName", user.LastName)
+
+	if err := app.Save(record); err != nil {
+		return models.User{}, err
+	}
+
+	return newUser(record), nil
+}
+
+// UpdateFamily saves the editable fields of an existing family.
+func UpdateFamily(app core.App, family models.Family) (models.Family, error) {
+	record, err := app.FindRecordById("families", family.ID)
+	if err != nil {
+		return models.Family{}, err
+	}
+
+	record.Set("name", family.Name)
+	record.Set("description", family.Description)
+	record.Set("icon", family.Icon)
+	record.Set("color", family.Color)
+	record.Set("sharingPrecision", family.SharingPrecision)
+
+	if err := app.Save(record); err != nil {
+		return models.Family{}, err
+	}
+
+	return newFamily(record), nil
+}
+
+// SetFamilyImage replaces the image of an existing family. A nil image
+// removes the current one.
+func SetFamilyImage(app core.App, familyId string, image *filesystem.File) (models.Family, error) {
+	record, err := app.FindRecordById("families", familyId)
+	if err != nil {
+		return models.Family{}, err
+	}
+
+	if image == nil {
+		record.Set("image", nil)
+	} else {
+		record.Set("image", image)
+	}
+
+	if err := app.Save(record); err != nil {
+		return models.Family{}, err
+	}
+
+	return newFamily(record), nil
+}
+
+func DeleteFamilyMember(app core.App, familyMemberId string) error {
+	record, err := app.FindRecordById("familyMembers", familyMemberId)
+	if err != nil {
+		return err
+	}
+
+	return app.Delete(record)
+}
+
+// CreateLocation saves a new location for location.User. A non-empty
+// location.ID is used as the record id, so clients can generate ids offline.
+func CreateLocation(app core.App, location models.Location, coordinates types.GeoPoint) (models.Location, error) {
+	collection, err := app.FindCachedCollectionByNameOrId("locations")
+	if err != nil {
+		return models.Location{}, err
+	}
+
+	record := core.NewRecord(collection)
+	if location.ID != "" {
+		record.Id = location.ID
+	}
+	record.Set("user", location.User)
+	record.Set("device", location.Device)
+	record.Set("coordinates", coordinates)
+	record.Set("recordedAt", location.RecordedAt)
+
+	if err := app.Save(record); err != nil {
+		return models.Location{}, err
+	}
+
+	return newLocation(record), nil
+}
+
+func newUser(record *core.Record) models.User {
+	return models.User{
+		ID:        record.Id,
+		Email:     record.Email(),
+		FirstName: record.GetString("firstName"),
+		LastName:  record.GetString("lastName"),
+		Avatar:    record.GetString("avatar"),
+		CreatedAt: record.GetDateTime("createdAt"),
+		UpdatedAt: record.GetDateTime("updatedAt"),
+		IsDeleted: record.GetBool("isDeleted"),
+		Version:   record.GetInt("version"),
+	}
+}
+
+func newFamily(record *core.Record) models.Family {
+	return models.Family{
+		ID:               record.Id,
+		Name:             record.GetString("name"),
+		Description:      record.GetString("description"),
+		Icon:             record.GetString("icon"),
+		Color:            record.GetString("color"),
+		SharingPrecision: record.GetString("sharingPrecision"),
+		Image:            record.GetString("image"),
+		CreatedBy:        record.GetString("createdBy"),
+		CreatedAt:        record.GetDateTime("createdAt"),
+		UpdatedAt:        record.GetDateTime("updatedAt"),
+		IsDeleted:        record.GetBool("isDeleted"),
+		Version:          record.GetInt("version"),
+	}
+}
+
+func newFamilyMember(record *core.Record) models.FamilyMember {
+	return models.FamilyMember{
+		ID:        record.Id,
+		User:      record.GetString("user"),
+		Family:    record.GetString("family"),
+		Role:      record.GetString("role"),
+		CreatedAt: record.GetDateTime("createdAt"),
+	}
+}
+
+func newInvitation(record *core.Record) models.Invitation {
+	return models.Invitation{
+		ID:        record.Id,
+		Sender:    record.GetString("sender"),
+		Recipient: record.GetString("recipient"),
+		Email:     record.GetString("email"),
+		Family:    record.GetString("family"),
+		Status:    record.GetString("status"),
+		ExpiresAt: record.GetDateTime("expiresAt"),
+		SentAt:    record.GetDateTime("sentAt"),
+		CreatedAt: record.GetDateTime("createdAt"),
+	}
+}
+
+func newLocation(record *core.Record) models.Location {
+	coordinates, _ := json.Marshal(record.Get("coordinates"))
+
+	return models.Location{
+		ID:          record.Id,
+		User:        record.GetString("user"),
+		Device:      record.GetString("device"),
+		Coordinates: string(coordinates),
+		CreatedAt:   record.GetDateTime("createdAt"),
+		RecordedAt:  record.GetDateTime("recordedAt"),
+	}
+}
+
+func GetDevices(db dbx.Builder, userId string) ([]models.Device, error) {
+	query := `
+    select d.id,
+      d.user,
+      d.name,
+      d.platform,
+      d.formFactor,
+      d.appVersion,
+      d.sharesLocation,
+      d.lastSeenAt,
+      d.revokedAt,
+      d.createdAt,
+      d.updatedAt
+    from devices d
+    where d.user = {:userId}
+      and d.revokedAt = ''
+    order by d.lastSeenAt desc
+  `
+
+	var devices []models.Device
+	err := db.NewQuery(query).Bind(dbx.Params{"userId": userId}).All(&devices)
+	return devices, err
+}
+
+func GetDevice(db dbx.Builder, userId, deviceId string) (models.Device, error) {
+	query := `
+    select d.id,
+      d.user,
+      d.name,
+      d.platform,
+      d.formFactor,
+      d.appVersion,
+      d.sharesLocation,
+      d.lastSeenAt,
+      d.revokedAt,
+      d.createdAt,
+      d.updatedAt
+    from devices d
+    where d.id = {:deviceId}
+      and d.user = {:userId}
+  `
+
+	var device models.Device
+	err := db.NewQuery(query).Bind(dbx.Params{"deviceId": deviceId, "userId": userId}).One(&device)
+	return device, err
+}
+
+// CountDevices returns the number of devices the user registered, including
+// revoked ones.
+func CountDevices(db dbx.Builder, userId string) (int, error) {
+	var count int
+	err := db.Select("count(*)").From("devices").Where(dbx.HashExp{"user": userId}).Row(&count)
+	return count, err
+}
+
+// GetSharingDevice returns the device sharing the user's location.
+func GetSharingDevice(db dbx.Builder, userId string) (models.Device, error) {
+	query := `
+    select d.id,
+      d.user,
+      d.name,
+      d.platform,
+      d.formFactor,
+      d.appVersion,
+      d.sharesLocation,
+      d.lastSeenAt,
+      d.revokedAt,
+      d.createdAt,
+      d.updatedAt
+    from devices d
+    where d.user = {:userId}
+      and d.sharesLocation = true
+      and d.revokedAt = ''
+  `
+
+	var device models.Device
+	err := db.NewQuery(query).Bind(dbx.Params{"userId": userId}).One(&device)
+	return device, err
+}
+
+// CreateDevice saves a new device for device.User.
+func CreateDevice(app core.App, device models.Device) (models.Device, error) {
+	collection, err := app.FindCachedCollectionByNameOrId("devices")
+	if err != nil {
+		return models.Device{}, err
+	}
+
+	record := core.NewRecord(collection)
+	record.Set("user", device.User)
+	setDeviceFields(record, device)
+
+	if err := app.Save(record); err != nil {
+		return models.Device{}, err
+	}
+
+	return newDevice(record), nil
+}
+
+// UpdateDevice saves the mutable fields of an existing device.
+func UpdateDevice(app core.App, device models.Device) (models.Device, error) {
+	record, err := app.FindRecordById("devices", device.ID)
+	if err != nil {
+		return models.Device{}, err
+	}
+
+	setDeviceFields(record, device)
+
+	if err := app.Save(record); err != nil {
+		return models.Device{}, err
+	}
+
+	return newDevice(record), nil
+}
+
+func setDeviceFields(record *core.Record, device models.Device) {
+	record.Set("name", device.Name)
+	record.Set("platform", device.Platform)
+	record.Set("formFactor", device.FormFactor)
+	record.Set("appVersion", device.AppVersion)
+	record.Set("sharesLocation", device.SharesLocation)
+	record.Set("lastSeenAt", device.LastSeenAt)
+	record.Set("revokedAt", device.RevokedAt)
+}
+
+func newDevice(record *core.Record) models.Device {
+	return models.Device{
+		ID:             record.Id,
+		User:           record.GetString("user"),
+		Name:           record.GetString("name"),
+		Platform:       record.GetString("platform"),
+		FormFactor:     record.GetString("formFactor"),
+		AppVersion:     record.GetString("appVersion"),
+		SharesLocation: record.GetBool("sharesLocation"),
+		LastSeenAt:     record.GetDateTime("lastSeenAt"),
+		RevokedAt:      record.GetDateTime("revokedAt"),
+		CreatedAt:      record.GetDateTime("createdAt"),
+		UpdatedAt:      record.GetDateTime("updatedAt"),
+	}
+}
+
+func GetDisplayTokens(db dbx.Builder, familyId string) ([]models.DisplayToken, error) {
+	query := `
+    select t.id,
+      t.family,
+      t.createdBy,
+      t.name,
+      t.lastUsedAt,
+      t.revokedAt,
+      t.createdAt
+    from displayTokens t
+    where t.family = {:familyId}
+      and t.revokedAt = ''
+    order by t.createdAt
+  `
+
+	var tokens []models.DisplayToken
+	err := db.NewQuery(query).Bind(dbx.Params{"familyId": familyId}).All(&tokens)
+	return tokens, err
+}
+
+func GetDisplayToken(db dbx.Builder, familyId, tokenId string) (models.DisplayToken, error) {
+	query := `
+    select t.id,
+      t.family,
+      t.createdBy,
+      t.name,
+      t.lastUsedAt,
+      t.revokedAt,
+      t.createdAt
+    from displayTokens t
+    where t.id = {:tokenId}
+      and t.family = {:familyId}
+  `
+
+	var token models.DisplayToken
+	err := db.NewQuery(query).Bind(dbx.Params{"familyId": familyId, "tokenId": tokenId}).One(&token)
+	return token, err
+}
+
+func GetDisplayTokenByHash(db dbx.Builder, tokenHash string) (models.DisplayToken, error) {
+	query := `
+    select t.id,
+      t.family,
+      t.createdBy,
+      t.name,
+      t.lastUsedAt,
+      t.revokedAt,
+      t.createdAt
+    from displayTokens t
+    where t.tokenHash = {:tokenHash}
+  `
+
+	var token models.DisplayToken
+	err := db.NewQuery(query).Bind(dbx.Params{"tokenHash": tokenHash}).One(&token)
+	return token, err
+}
+
+// CreateDisplayToken saves a new display token. Only the hash of the secret
+// token is stored.
+func CreateDisplayToken(app core.App, token models.DisplayToken, tokenHash string) (models.DisplayToken, error) {
+	collection, err := app.FindCachedCollectionByNameOrId("displayTokens")
+	if err != nil {
+		return models.DisplayToken{}, err
+	}
+
+	record := core.NewRecord(collection)
+	record.Set("family", token.Family)
+	record.Set("createdBy", token.CreatedBy)
+	record.Set("name", token.Name)
+	record.Set("tokenHash", tokenHash)
+
+	if err := app.Save(record); err != nil {
+		return models.DisplayToken{}, err
+	}
+
+	return newDisplayToken(record), nil
+}
+
+// UpdateDisplayToken saves the usage and revocation times of a display token.
+func UpdateDisplayToken(app core.App, token models.DisplayToken) (models.DisplayToken, error) {
+	record, err := app.FindRecordById("displayTokens", token.ID)
+	if err != nil {
+		return models.DisplayToken{}, err
+	}
+
+	record.Set("lastUsedAt", token.LastUsedAt)
+	record.Set("revokedAt", token.RevokedAt)
+
+	if err := app.Save(record); err != nil {
+		return models.DisplayToken{}, err
+	}
+
+	return newDisplayToken(record), nil
+}
+
+func newDisplayToken(record *core.Record) models.DisplayToken {
+	return models.DisplayToken{
+		ID:         record.Id,
+		Family:     record.GetString("family"),
+		CreatedBy:  record.GetString("createdBy"),
+		Name:       record.GetString("name"),
+		LastUsedAt: record.GetDateTime("lastUsedAt"),
+		RevokedAt:  record.GetDateTime("revokedAt"),
+		CreatedAt:  record.GetDateTime("createdAt"),
+	}
+}
+
+// GetAuditEvents returns the family's audit events, newest first, starting
+// after the event recorded at before with the id beforeId. Events recorded in
+// the same instant are ordered by id, so an empty beforeId starts with the
+// events recorded before that instant and a zero before with the latest
+// events.
+func GetAuditEvents(db dbx.Builder, familyId string, before time.Time, beforeId string, limit int) ([]models.AuditEvent, error) {
+	query := `
+    select e.id,
+      e.family,
+      e.actor,
+      e.actorName,
+      e.action,
+      e.subject,
+      e.subjectName,
+      e.data,
+      e.createdAt
+    from auditEvents e
+    where e.family = {:familyId}
+      and ({:before} = ''
+        or e.createdAt < {:before}
+        or (e.createdAt = {:before} and e.id < {:beforeId}))
+    order by e.createdAt desc, e.id desc
+    limit {:limit}
+  `
+
+	var events []models.AuditEvent
+	err := db.NewQuery(query).Bind(dbx.Params{
+		"familyId": familyId,
+		"before":   formatTime(before),
+		"beforeId": beforeId,
+		"limit":    limit,
+	}).All(&events)
+	return events, err
+}
+
+func CreateAuditEvent(app core.App, event models.AuditEvent) (models.AuditEvent, error) {
+	collection, err := app.FindCachedCollectionByNameOrId("auditEvents")
+	if err != nil {
+		return models.AuditEvent{}, err
+	}
+
+	record := core.NewRecord(collection)
+	record.Set("family", event.Family)
+	record.Set("actor", event.Actor)
+	record.Set("actorName", event.ActorName)
+	record.Set("action", event.Action)
+	record.Set("subject", event.Subject)
+	record.Set("subjectName", event.SubjectName)
+	record.Set("data", event.Data)
+
+	if err := app.Save(record); err != nil {
+		return models.AuditEvent{}, err
+	}
+
+	return newAuditEvent(record), nil
+}
+
+func newAuditEvent(record *core.Record) models.AuditEvent {
+	data, _ := record.Get("data").(types.JSONRaw)
+
+	return models.AuditEvent{
+		ID:          record.Id,
+		Family:      record.GetString("family"),
+		Actor:       record.GetString("actor"),
+		ActorName:   record.GetString("actorName"),
+		Action:      record.GetString("action"),
+		Subject:     record.GetString("subject"),
+		SubjectName: record.GetString("subjectName"),
+		Data:        data,
+		CreatedAt:   record.GetDateTime("createdAt"),
+	}
 }
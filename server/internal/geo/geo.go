@@ -0,0 +1,71 @@
+package geo
+
+import (
+	"math"
+
+	"github.com/pocketbase/pocketbase/tools/types"
+)
+
+// earthRadius is the mean radius of the earth in meters.
+const earthRadius = 6371008.8
+
+// Distance returns the great-circle distance between two points in meters.
+func Distance(a, b types.GeoPoint) float64 {
+	lat1 := radians(a.Lat)
+	lat2 := radians(b.Lat)
+	dLat := radians(b.Lat - a.Lat)
+	dLon := radians(b.Lon - a.Lon)
+
+	h := math.Sin(dLat/2)*math.Sin(dLat/2) +
+		math.Cos(lat1)*math.Cos(lat2)*math.Sin(dLon/2)*math.Sin(dLon/2)
+
+	return 2 * earthRadius * math.Asin(math.Min(1, math.Sqrt(h)))
+}
+
+// Round rounds the point's coordinates to the given number of decimals of a
+// degree.
+func Round(point types.GeoPoint, decimals int) types.GeoPoint {
+	scale := math.Pow(10, float64(decimals))
+
+	return types.GeoPoint{
+		Lon: math.Round(point.Lon*scale) / scale,
+		Lat: math.Round(point.Lat*scale) / scale,
+	}
+}
+
+func radians(degrees float64) float64 {
+	return degrees * math.Pi / 180
+}
+
+// Bounds is the smallest latitude/longitude aligned box containing a set of
+// points.
+type Bounds struct {
+	MinLat float64 `json:"minLat"`
+	MinLon float64 `json:"minLon"`
+	MaxLat float64 `json:"maxLat"`
+	MaxLon float64 `json:"maxLon"`
+}
+
+// NewBounds returns the bounds of the given points, or nil if there are
+// none.
+func NewBounds(points ...types.GeoPoint) *Bounds {
+	if len(points) == 0 {
+		return nil
+	}
+
+	bounds := &Bounds{
+		MinLat: points[0].Lat,
+		MinLon: points[0].Lon,
+		MaxLat: points[0].Lat,
+		MaxLon: points[0].Lon,
+	}
+
+	for _, point := range points[1:] {
+		bounds.MinLat = math.Min(bounds.MinLat, point.Lat)
+		bounds.MinLon = math.Min(bounds.MinLon, point.Lon)
+		bounds.MaxLat = math.Max(bounds.MaxLat, point.Lat)
+		bounds.MaxLon = math.Max(bounds.MaxLon, point.Lon)
+	}
+
+	return bounds
+}
@@ -0,0 +1,42 @@
+package geo_test
+
+import (
+	"testing"
+
+	"github.com/ian-shakespeare/tribe-tracker/server/internal/geo"
+	"github.com/pocketbase/pocketbase/tools/types"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDistance(t *testing.T) {
+	whiteHouse := types.GeoPoint{Lon: -77.036583, Lat: 38.897721}
+	capitol := types.GeoPoint{Lon: -77.009056, Lat: 38.889805}
+
+	require.Zero(t, geo.Distance(whiteHouse, whiteHouse))
+	require.InDelta(t, 2535, geo.Distance(whiteHouse, capitol), 10)
+	require.InDelta(t, geo.Distance(whiteHouse, capitol), geo.Distance(capitol, whiteHouse), 0.001)
+}
+
+func TestRound(t *testing.T) {
+	whiteHouse := types.GeoPoint{Lon: -77.036583, Lat: 38.897721}
+
+	require.Equal(t, types.GeoPoint{Lon: -77.04, Lat: 38.9}, geo.Round(whiteHouse, 2))
+	require.Equal(t, types.GeoPoint{Lon: -77, Lat: 38.9}, geo.Round(whiteHouse, 1))
+	require.Equal(t, whiteHouse, geo.Round(whiteHouse, 6))
+}
+
+func TestNewBounds(t *testing.T) {
+	require.Nil(t, geo.NewBounds())
+
+	bounds := geo.NewBounds(
+		types.GeoPoint{Lon: 8.986816, Lat: 33.468108},
+		types.GeoPoint{Lon: 9.008789, Lat: 62.000905},
+		types.GeoPoint{Lon: -77.036583, Lat: 38.897721},
+	)
+	require.Equal(t, &geo.Bounds{
+		MinLat: 33.468108,
+		MinLon: -77.036583,
+		MaxLat: 62.000905,
+		MaxLon: 9.008789,
+	}, bounds)
+}
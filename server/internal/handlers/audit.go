@@ -0,0 +1,221 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ian-shakespeare/tribe-tracker/server/internal/database"
+	"github.com/ian-shakespeare/tribe-tracker/server/pkg/models"
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/tools/types"
+)
+
+const (
+	auditEventsDefaultLimit = 50
+	auditEventsMaxLimit     = 200
+)
+
+// audit records an action in the family's audit log. It should be called
+// with the transaction making the change so one isn't kept without the other.
+func audit(app core.App, familyId, actorId, action, subjectId string, data map[string]any) error {
+	event := models.AuditEvent{
+		Family:  familyId,
+		Actor:   actorId,
+		Action:  action,
+		Subject: subjectId,
+	}
+
+	var err error
+	if event.ActorName, err = auditName(app, actorId); err != nil {
+		return err
+	}
+	if event.SubjectName, err = auditName(app, subjectId); err != nil {
+		return err
+	}
+
+	if data != nil {
+		if event.Data, err = json.Marshal(data); err != nil {
+			return err
+		}
+	}
+
+	_, err = database.CreateAuditEvent(app, event)
+	return err
+}
+
+// auditName returns the user's full name, or their email if they didn't set
+// one.
+func auditName(app core.App, userId string) (string, error) {
+	if userId == "" {
+		return "", nil
+	}
+
+	user, err := database.GetUser(app.DB(), userId)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", nil
+	} else if err != nil {
+		return "", err
+	}
+
+	name := strings.TrimSpace(user.FirstName + " " + user.LastName)
+	if name == "" {
+		name = user.Email
+	}
+
+	return name, nil
+}
+
+// auditActor returns the id of the user making the request. Superusers
+// aren't users, so changes made from the dashboard have no actor.
+func auditActor(auth *core.Record) string {
+	if auth == nil || auth.Collection().Name != "users" {
+		return ""
+	}
+
+	return auth.Id
+}
+
+// listAuditEvents returns the family's audit log, newest first. Older pages
+// are requested with the createdAt and id of the last event as before and
+// beforeId.
+func listAuditEvents(e *core.RequestEvent) error {
+	familyId := e.Request.PathValue("id")
+
+	if _, err := findMembership(e.App, familyId, e.Auth.Id, models.RoleOwner); err != nil {
+		return err
+	}
+
+	params := e.Request.URL.Query()
+	fields := map[string]string{}
+
+	var before time.Time
+	if value := params.Get("before"); value != "" {
+		dt, err := types.ParseDateTime(value)
+		if err != nil || dt.IsZero() {
+			fields["before"] = "Must be a timestamp."
+		}
+		before = dt.Time()
+	}
+
+	beforeId := params.Get("beforeId")
+	if beforeId != "" && before.IsZero() {
+		fields["beforeId"] = "Requires before."
+	}
+
+	limit := auditEventsDefaultLimit
+	if value := params.Get("limit"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 || n > auditEventsMaxLimit {
+			fields["limit"] = "Must be between 1 and " + strconv.Itoa(auditEventsMaxLimit) + "."
+		}
+		limit = n
+	}
+
+	if len(fields) > 0 {
+		return validationFailed(fields, nil)
+	}
+
+	events, err := database.GetAuditEvents(e.App.DB(), familyId, before, beforeId, limit)
+	if err != nil {
+		return internalError("Failed to get audit data.", err)
+	}
+
+	return e.JSON(http.StatusOK, events)
+}
+
+// auditRequest runs a records API request in a transaction together with the
+// audit event recorded by fn once the request succeeded.
+func auditRequest(e *core.RecordRequestEvent, fn func(txApp core.App, actorId string) error) error {
+	return e.App.RunInTransaction(func(txApp core.App) error {
+		e.App = txApp
+
+		if err := e.Next(); err != nil {
+			return err
+		}
+
+		return fn(txApp, auditActor(e.Auth))
+	})
+}
+
+// auditFamilyCreateRequest records families created through the records API.
+func auditFamilyCreateRequest(e *core.RecordRequestEvent) error {
+	return auditRequest(e, func(txApp core.App, actorId string) error {
+		return audit(txApp, e.Record.Id, actorId, models.AuditFamilyCreated, "", map[string]any{
+			"name": e.Record.GetString("name"),
+		})
+	})
+}
+
+// auditFamilyUpdateRequest records families renamed through the records API.
+func auditFamilyUpdateRequest(e *core.RecordRequestEvent) error {
+	oldName := e.Record.Original().GetString("name")
+
+	return auditRequest(e, func(txApp core.App, actorId string) error {
+		name := e.Record.GetString("name")
+		if name == oldName {
+			return nil
+		}
+
+		return audit(txApp, e.Record.Id, actorId, models.AuditFamilyRenamed, "", map[string]any{
+			"from": oldName,
+			"to":   name,
+		})
+	})
+}
+
+// auditFamilyDeleteRequest records families deleted through the records API.
+func auditFamilyDeleteRequest(e *core.RecordRequestEvent) error {
+	return auditRequest(e, func(txApp core.App, actorId string) error {
+		return audit(txApp, e.Record.Id, actorId, models.AuditFamilyDeleted, "", map[string]any{
+			"name": e.Record.GetString("name"),
+		})
+	})
+}
+
+// auditMemberCreateRequest records members added through the records API.
+func auditMemberCreateRequest(e *core.RecordRequestEvent) error {
+	return auditRequest(e, func(txApp core.App, actorId string) error {
+		return audit(txApp, e.Record.GetString("family"), actorId, models.AuditMemberJoined, e.Record.GetString("user"), map[string]any{
+			"role": e.Record.GetString("role"),
+		})
+	})
+}
+
+// auditMemberUpdateRequest records role changes made through the records API.
+func auditMemberUpdateRequest(e *core.RecordRequestEvent) error {
+	oldRole := e.Record.Original().GetString("role")
+
+	return auditRequest(e, func(txApp core.App, actorId string) error {
+		role := e.Record.GetString("role")
+		if role == oldRole {
+			return nil
+		}
+
+		return audit(txApp, e.Record.GetString("family"), actorId, models.AuditMemberRoleChanged, e.Record.GetString("user"), map[string]any{
+			"from": oldRole,
+			"to":   role,
+		})
+	})
+}
+
+// auditMemberDeleteRequest records members leaving or being removed through
+// the records API.
+func auditMemberDeleteRequest(e *core.RecordRequestEvent) error {
+	return auditRequest(e, func(txApp core.App, actorId string) error {
+		userId := e.Record.GetString("user")
+
+		action := models.AuditMemberRemoved
+		if actorId == userId {
+			action = models.AuditMemberLeft
+		}
+
+		return audit(txApp, e.Record.GetString("family"), actorId, action, userId, map[string]any{
+			"role": e.Record.GetString("role"),
+		})
+	})
+}
@@ -0,0 +1,390 @@
+package handlers_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/tests"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	lukeMembershipId = "ffmpju0blr0e9ab"
+	leiaMembershipId = "nha90gavpkjvc8j"
+)
+
+// setupAuditTestApp seeds a few audit events of the Skywalkers and one of
+// another family.
+func setupAuditTestApp(t testing.TB) *tests.TestApp {
+	app := setupTestApp(t)
+
+	now := time.Now()
+	seedRecords(t, app, "auditEvents",
+		map[string]any{"family": skywalkersId, "actor": lukeId, "actorName": "luke skywalker", "action": "family.created", "createdAt": now.Add(-4 * time.Minute)},
+		map[string]any{"family": skywalkersId, "actor": lukeId, "actorName": "luke skywalker", "action": "member.invited", "subject": leiaId, "subjectName": "leia organa", "createdAt": now.Add(-3 * time.Minute)},
+		map[string]any{"family": skywalkersId, "actor": leiaId, "actorName": "leia organa", "action": "member.joined", "subject": leiaId, "subjectName": "leia organa", "createdAt": now.Add(-2 * time.Minute)},
+		map[string]any{"family": empireId, "actor": vaderId, "actorName": "darth vader", "action": "family.created", "createdAt": now.Add(-time.Minute)},
+	)
+
+	return app
+}
+
+// latestAuditEvent returns the family's most recent audit event.
+func latestAuditEvent(t testing.TB, app core.App, familyId string) *core.Record {
+	t.Helper()
+
+	records, err := app.FindRecordsByFilter("auditEvents", "family = {:family}", "-createdAt", 1, 0, dbx.Params{"family": familyId})
+	require.NoError(t, err)
+	require.Len(t, records, 1)
+
+	return records[0]
+}
+
+func auditData(t testing.TB, record *core.Record) map[string]any {
+	t.Helper()
+
+	var data map[string]any
+	require.NoError(t, record.UnmarshalJSONField("data", &data))
+
+	return data
+}
+
+func TestListAuditEvents(t *testing.T) {
+	luke := generateToken(t, "users", "luke.skywalker@email.com")
+	leia := generateToken(t, "users", "leia.organa@email.com")
+	vader := generateToken(t, "users", "darth.vader@email.com")
+
+	// events recorded in the same instant
+	instant := time.Now().Add(-time.Minute).UTC().Truncate(time.Millisecond)
+
+	path := "/mobile/families/" + skywalkersId + "/audit-events"
+	scenarios := []tests.ApiScenario{
+		{
+			Name:            "unauthorized",
+			Method:          http.MethodGet,
+			URL:             path,
+			ExpectedStatus:  http.StatusUnauthorized,
+			ExpectedContent: []string{`"code":"unauthorized"`},
+			TestAppFactory:  setupAuditTestApp,
+		},
+		{
+			Name:   "not a member",
+			Method: http.MethodGet,
+			URL:    path,
+			Headers: map[string]string{
+				"Authorization": vader,
+			},
+			ExpectedStatus:  http.StatusNotFound,
+			ExpectedContent: []string{`"code":"not_found"`},
+			TestAppFactory:  setupAuditTestApp,
+		},
+		{
+			Name:   "not the owner",
+			Method: http.MethodGet,
+			URL:    path,
+			Headers: map[string]string{
+				"Authorization": leia,
+			},
+			ExpectedStatus:  http.StatusForbidden,
+			ExpectedContent: []string{`"code":"forbidden"`},
+			TestAppFactory:  setupAuditTestApp,
+		},
+		{
+			Name:   "invalid limit",
+			Method: http.MethodGet,
+			URL:    path + "?limit=0",
+			Headers: map[string]string{
+				"Authorization": luke,
+			},
+			ExpectedStatus:  http.StatusBadRequest,
+			ExpectedContent: []string{`"limit":"Must be between 1 and 200."`},
+			TestAppFactory:  setupAuditTestApp,
+		},
+		{
+			Name:   "invalid before",
+			Method: http.MethodGet,
+			URL:    path + "?before=yesterday",
+			Headers: map[string]string{
+				"Authorization": luke,
+			},
+			ExpectedStatus:  http.StatusBadRequest,
+			ExpectedContent: []string{`"before":"Must be a timestamp."`},
+			TestAppFactory:  setupAuditTestApp,
+		},
+		{
+			Name:   "listed",
+			Method: http.MethodGet,
+			URL:    path,
+			Headers: map[string]string{
+				"Authorization": luke,
+			},
+			ExpectedStatus: http.StatusOK,
+			ExpectedContent: []string{
+				`"action":"member.joined"`,
+				`"actor":"` + leiaId + `","actorName":"leia organa"`,
+				`"subject":"` + leiaId + `","subjectName":"leia organa"`,
+			},
+			NotExpectedContent: []string{`"family":"` + empireId + `"`},
+			TestAppFactory:     setupAuditTestApp,
+			AfterTestFunc: func(t testing.TB, app *tests.TestApp, res *http.Response) {
+				var events []struct {
+					Action string `json:"action"`
+				}
+				require.NoError(t, json.NewDecoder(res.Body).Decode(&events))
+
+				actions := make([]string, 0, len(events))
+				for _, event := range events {
+					actions = append(actions, event.Action)
+				}
+				require.Equal(t, []string{"member.joined", "member.invited", "family.created"}, actions)
+			},
+		},
+		{
+			Name:   "paged",
+			Method: http.MethodGet,
+			URL:    path + "?limit=1",
+			Headers: map[string]string{
+				"Authorization": luke,
+			},
+			ExpectedStatus:     http.StatusOK,
+			ExpectedContent:    []string{`"action":"member.joined"`},
+			NotExpectedContent: []string{`"action":"member.invited"`},
+			TestAppFactory:     setupAuditTestApp,
+		},
+		{
+			Name:   "before the latest",
+			Method: http.MethodGet,
+			URL:    path + "?before=" + time.Now().Add(-time.Hour).UTC().Format(time.RFC3339),
+			Headers: map[string]string{
+				"Authorization": luke,
+			},
+			ExpectedStatus:  http.StatusOK,
+			ExpectedContent: []string{`[]`},
+			TestAppFactory:  setupAuditTestApp,
+		},
+		{
+			Name:   "before id without before",
+			Method: http.MethodGet,
+			URL:    path + "?beforeId=auditevent00002",
+			Headers: map[string]string{
+				"Authorization": luke,
+			},
+			ExpectedStatus:  http.StatusBadRequest,
+			ExpectedContent: []string{`"beforeId":"Requires before."`},
+			TestAppFactory:  setupAuditTestApp,
+		},
+		{
+			Name:   "paged within the same instant",
+			Method: http.MethodGet,
+			URL:    path + "?limit=1&beforeId=auditevent00002&before=" + url.QueryEscape(instant.Format(time.RFC3339Nano)),
+			Headers: map[string]string{
+				"Authorization": luke,
+			},
+			ExpectedStatus:     http.StatusOK,
+			ExpectedContent:    []string{`"id":"auditevent00001"`},
+			NotExpectedContent: []string{`"id":"auditevent00002"`},
+			TestAppFactory: func(t testing.TB) *tests.TestApp {
+				app := setupAuditTestApp(t)
+
+				seedRecords(t, app, "auditEvents",
+					map[string]any{"id": "auditevent00001", "family": skywalkersId, "actor": lukeId, "actorName": "luke skywalker", "action": "family.renamed", "createdAt": instant},
+					map[string]any{"id": "auditevent00002", "family": skywalkersId, "actor": lukeId, "actorName": "luke skywalker", "action": "family.renamed", "createdAt": instant},
+				)
+
+				return app
+			},
+		},
+	}
+
+	for _, scenario := range scenarios {
+		scenario.Test(t)
+	}
+}
+
+func TestAuditTrail(t *testing.T) {
+	luke := generateToken(t, "users", "luke.skywalker@email.com")
+	leia := generateToken(t, "users", "leia.organa@email.com")
+	vader := generateToken(t, "users", "darth.vader@email.com")
+	superuser := generateToken(t, core.CollectionNameSuperusers, "test@email.com")
+
+	scenarios := []tests.ApiScenario{
+		{
+			Name:   "family created",
+			Method: http.MethodPost,
+			URL:    "/mobile/families",
+			Body:   strings.NewReader(`{"name":"Lars","code":"moisture-farm"}`),
+			Headers: map[string]string{
+				"Authorization": luke,
+			},
+			ExpectedStatus:  http.StatusCreated,
+			ExpectedContent: []string{`"name":"Lars"`},
+			TestAppFactory:  setupTestApp,
+			AfterTestFunc: func(t testing.TB, app *tests.TestApp, res *http.Response) {
+				family, err := app.FindFirstRecordByData("families", "code", "moisture-farm")
+				require.NoError(t, err)
+
+				event := latestAuditEvent(t, app, family.Id)
+				require.Equal(t, "family.created", event.GetString("action"))
+				require.Equal(t, lukeId, event.GetString("actor"))
+				require.Equal(t, "luke skywalker", event.GetString("actorName"))
+				require.Equal(t, "Lars", auditData(t, event)["name"])
+			},
+		},
+		{
+			Name:   "family renamed",
+			Method: http.MethodPatch,
+			URL:    "/mobile/families/" + skywalkersId,
+			Body:   strings.NewReader(`{"name":"Jedi Order"}`),
+			Headers: map[string]string{
+				"Authorization": luke,
+				"If-Match":      `"1"`,
+			},
+			ExpectedStatus:  http.StatusOK,
+			ExpectedContent: []string{`"name":"Jedi Order"`},
+			TestAppFactory:  setupTestApp,
+			AfterTestFunc: func(t testing.TB, app *tests.TestApp, res *http.Response) {
+				event := latestAuditEvent(t, app, skywalkersId)
+				require.Equal(t, "family.renamed", event.GetString("action"))
+				require.Equal(t, map[string]any{"from": "Skywalkers", "to": "Jedi Order"}, auditData(t, event))
+			},
+		},
+		{
+			Name:   "settings changed without renaming",
+			Method: http.MethodPatch,
+			URL:    "/mobile/families/" + skywalkersId,
+			Body:   strings.NewReader(`{"color":"#FFAA00"}`),
+			Headers: map[string]string{
+				"Authorization": luke,
+				"If-Match":      `"1"`,
+			},
+			ExpectedStatus:  http.StatusOK,
+			ExpectedContent: []string{`"color":"#FFAA00"`},
+			TestAppFactory:  setupTestApp,
+			AfterTestFunc: func(t testing.TB, app *tests.TestApp, res *http.Response) {
+				total, err := app.CountRecords("auditEvents")
+				require.NoError(t, err)
+				require.Zero(t, total)
+			},
+		},
+		{
+			Name:   "member invited",
+			Method: http.MethodPost,
+			URL:    "/mobile/families/" + skywalkersId + "/invitations",
+			Body:   strings.NewReader(`{"email":"owen.lars@email.com"}`),
+			Headers: map[string]string{
+				"Authorization": leia,
+			},
+			ExpectedStatus:  http.StatusCreated,
+			ExpectedContent: []string{`"email":"owen.lars@email.com"`},
+			TestAppFactory:  setupTestApp,
+			AfterTestFunc: func(t testing.TB, app *tests.TestApp, res *http.Response) {
+				event := latestAuditEvent(t, app, skywalkersId)
+				require.Equal(t, "member.invited", event.GetString("action"))
+				require.Equal(t, leiaId, event.GetString("actor"))
+				require.Empty(t, event.GetString("subject"))
+				require.Equal(t, "owen.lars@email.com", auditData(t, event)["email"])
+			},
+		},
+		{
+			Name:   "member joined with a link",
+			Method: http.MethodPost,
+			URL:    "/mobile/families/join",
+			Body:   strings.NewReader(`{"token":"` + joinToken(t, skywalkersId, time.Hour) + `"}`),
+			Headers: map[string]string{
+				"Authorization": vader,
+			},
+			ExpectedStatus:  http.StatusCreated,
+			ExpectedContent: []string{`"user":"` + vaderId + `"`},
+			TestAppFactory:  setupTestApp,
+			AfterTestFunc: func(t testing.TB, app *tests.TestApp, res *http.Response) {
+				event := latestAuditEvent(t, app, skywalkersId)
+				require.Equal(t, "member.joined", event.GetString("action"))
+				require.Equal(t, vaderId, event.GetString("actor"))
+				require.Equal(t, vaderId, event.GetString("subject"))
+				require.Equal(t, "darth vader", event.GetString("subjectName"))
+				require.Equal(t, true, auditData(t, event)["joinLink"])
+			},
+		},
+		{
+			Name:   "member left",
+			Method: http.MethodPost,
+			URL:    "/mobile/sync/push",
+			Body: strings.NewReader(`{"mutations":[
+				{"type":"leaveFamily","id":"` + skywalkersId + `"}
+			]}`),
+			Headers: map[string]string{
+				"Authorization": leia,
+			},
+			ExpectedStatus:  http.StatusOK,
+			ExpectedContent: []string{`"applied":true`},
+			TestAppFactory:  setupTestApp,
+			AfterTestFunc: func(t testing.TB, app *tests.TestApp, res *http.Response) {
+				event := latestAuditEvent(t, app, skywalkersId)
+				require.Equal(t, "member.left", event.GetString("action"))
+				require.Equal(t, leiaId, event.GetString("actor"))
+				require.Equal(t, leiaId, event.GetString("subject"))
+			},
+		},
+		{
+			Name:   "member removed by an administrator",
+			Method: http.MethodDelete,
+			URL:    "/api/collections/familyMembers/records/" + leiaMembershipId,
+			Headers: map[string]string{
+				"Authorization": superuser,
+			},
+			ExpectedStatus: http.StatusNoContent,
+			TestAppFactory: setupTestApp,
+			AfterTestFunc: func(t testing.TB, app *tests.TestApp, res *http.Response) {
+				event := latestAuditEvent(t, app, skywalkersId)
+				require.Equal(t, "member.removed", event.GetString("action"))
+				require.Empty(t, event.GetString("actor"))
+				require.Equal(t, "leia organa", event.GetString("subjectName"))
+				require.Equal(t, "member", auditData(t, event)["role"])
+			},
+		},
+		{
+			Name:   "role changed",
+			Method: http.MethodPatch,
+			URL:    "/api/collections/familyMembers/records/" + leiaMembershipId,
+			Body:   strings.NewReader(`{"role":"admin"}`),
+			Headers: map[string]string{
+				"Authorization": superuser,
+			},
+			ExpectedStatus:  http.StatusOK,
+			ExpectedContent: []string{`"role":"admin"`},
+			TestAppFactory:  setupTestApp,
+			AfterTestFunc: func(t testing.TB, app *tests.TestApp, res *http.Response) {
+				event := latestAuditEvent(t, app, skywalkersId)
+				require.Equal(t, "member.roleChanged", event.GetString("action"))
+				require.Equal(t, leiaId, event.GetString("subject"))
+				require.Equal(t, map[string]any{"from": "member", "to": "admin"}, auditData(t, event))
+			},
+		},
+		{
+			Name:   "family deleted",
+			Method: http.MethodDelete,
+			URL:    "/api/collections/families/records/" + skywalkersId,
+			Headers: map[string]string{
+				"Authorization": luke,
+			},
+			ExpectedStatus: http.StatusNoContent,
+			TestAppFactory: setupTestApp,
+			AfterTestFunc: func(t testing.TB, app *tests.TestApp, res *http.Response) {
+				event := latestAuditEvent(t, app, skywalkersId)
+				require.Equal(t, "family.deleted", event.GetString("action"))
+				require.Equal(t, lukeId, event.GetString("actor"))
+				require.Equal(t, "Skywalkers", auditData(t, event)["name"])
+			},
+		},
+	}
+
+	for _, scenario := range scenarios {
+		scenario.Test(t)
+	}
+}
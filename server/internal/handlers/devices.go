@@ -0,0 +1,354 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	validation "github.com/go-ozzo/ozzo-validation/v4"
+	"github.com/ian-shakespeare/tribe-tracker/server/internal/database"
+	"github.com/ian-shakespeare/tribe-tracker/server/pkg/models"
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/tools/types"
+)
+
+const (
+	// DeviceHeader identifies the registered device a request was sent from.
+	DeviceHeader = "X-Device-Id"
+	// AppVersionHeader carries the version of the client app.
+	AppVersionHeader = "X-App-Version"
+	// FileTokenHeader carries a file token for the protected file URLs of
+	// a response, which clients append as the token query parameter. File
+	// URLs are never sent with a token in them.
+	FileTokenHeader = "X-File-Token"
+
+	// trackDeviceId identifies the trackDevice middleware.
+	trackDeviceId = "trackDevice"
+
+	// deviceTouchInterval limits how often a device's lastSeenAt is written.
+	deviceTouchInterval = time.Minute
+)
+
+type registerDeviceRequest struct {
+	Name           string `json:"name"`
+	Platform       string `json:"platform"`
+	FormFactor     string `json:"formFactor"`
+	AppVersion     string `json:"appVersion"`
+	SharesLocation *bool  `json:"sharesLocation"`
+}
+
+func (r *registerDeviceRequest) normalize() {
+	r.Name = strings.TrimSpace(r.Name)
+	r.Platform = strings.ToLower(strings.TrimSpace(r.Platform))
+	r.FormFactor = strings.ToLower(strings.TrimSpace(r.FormFactor))
+	r.AppVersion = strings.TrimSpace(r.AppVersion)
+}
+
+func (r *registerDeviceRequest) validate(app core.App) error {
+	return validation.ValidateStruct(r,
+		validation.Field(&r.Name, collectionField(app, "devices", "name")),
+		validation.Field(&r.Platform, collectionField(app, "devices", "platform")),
+		validation.Field(&r.FormFactor, collectionField(app, "devices", "formFactor")),
+		validation.Field(&r.AppVersion, collectionField(app, "devices", "appVersion")),
+	)
+}
+
+type updateDeviceRequest struct {
+	Name           *string `json:"name"`
+	SharesLocation *bool   `json:"sharesLocation"`
+}
+
+func (r *updateDeviceRequest) normalize() {
+	if r.Name != nil {
+		name := strings.TrimSpace(*r.Name)
+		r.Name = &name
+	}
+}
+
+func (r *updateDeviceRequest) validate(app core.App) error {
+	return validation.ValidateStruct(r,
+		validation.Field(&r.Name, collectionField(app, "devices", "name")),
+	)
+}
+
+func listDevices(e *core.RequestEvent) error {
+	devices, err := database.GetDevices(e.App.DB(), e.Auth.Id)
+	if err != nil {
+		return internalError("Failed to get device data.", err)
+	}
+
+	return e.JSON(http.StatusOK, devices)
+}
+
+func registerDevice(e *core.RequestEvent) error {
+	var req registerDeviceRequest
+	if err := readBody(e, &req); err != nil {
+		return err
+	}
+
+	// a user's first phone reports their location unless told otherwise,
+	// everything else (tablets, browsers, the display) has to opt in
+	sharesLocation := req.FormFactor == models.FormFactorPhone
+	if req.SharesLocation != nil {
+		sharesLocation = *req.SharesLocation
+	} else if sharesLocation {
+		// another device already sharing keeps doing so
+		_, err := database.GetSharingDevice(e.App.DB(), e.Auth.Id)
+		if err == nil {
+			sharesLocation = false
+		} else if !errors.Is(err, sql.ErrNoRows) {
+			return internalError("Failed to get device data.", err)
+		}
+	}
+
+	var device models.Device
+	err := e.App.RunInTransaction(func(txApp core.App) error {
+		if sharesLocation {
+			if err := stopSharingLocation(txApp, e.Auth.Id); err != nil {
+				return err
+			}
+		}
+
+		var err error
+		device, err = database.CreateDevice(txApp, models.Device{
+			User:           e.Auth.Id,
+			Name:           req.Name,
+			Platform:       req.Platform,
+			FormFactor:     req.FormFactor,
+			AppVersion:     req.AppVersion,
+			SharesLocation: sharesLocation,
+			LastSeenAt:     types.NowDateTime(),
+		})
+		return err
+	})
+	if err != nil {
+		return fromSaveError(err, "Failed to register device.")
+	}
+
+	return e.JSON(http.StatusCreated, device)
+}
+
+func updateDevice(e *core.RequestEvent) error {
+	var req updateDeviceRequest
+	if err := readBody(e, &req); err != nil {
+		return err
+	}
+
+	device, err := findActiveDevice(e.App, e.Auth.Id, e.Request.PathValue("id"))
+	if err != nil {
+		return err
+	}
+
+	if req.Name != nil {
+		device.Name = *req.Name
+	}
+
+	startsSharing := req.SharesLocation != nil && *req.SharesLocation && !device.SharesLocation
+	if req.SharesLocation != nil {
+		device.SharesLocation = *req.SharesLocation
+	}
+
+	err = e.App.RunInTransaction(func(txApp core.App) error {
+		// the device becomes the one sharing the user's location
+		if startsSharing {
+			if err := stopSharingLocation(txApp, e.Auth.Id); err != nil {
+				return err
+			}
+		}
+
+		var err error
+		device, err = database.UpdateDevice(txApp, device)
+		return err
+	})
+	if err != nil {
+		return fromSaveError(err, "Failed to update device.")
+	}
+
+	return e.JSON(http.StatusOK, device)
+}
+
+func revokeDevice(e *core.RequestEvent) error {
+	device, err := findActiveDevice(e.App, e.Auth.Id, e.Request.PathValue("id"))
+	if err != nil {
+		return err
+	}
+
+	device.RevokedAt = types.NowDateTime()
+	if _, err := database.UpdateDevice(e.App, device); err != nil {
+		return fromSaveError(err, "Failed to revoke device.")
+	}
+
+	return e.NoContent(http.StatusNoContent)
+}
+
+// stopSharingLocation stops the user's devices from sharing their location,
+// so another one can take over.
+func stopSharingLocation(app core.App, userId string) error {
+	device, err := database.GetSharingDevice(app.DB(), userId)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	device.SharesLocation = false
+	_, err = database.UpdateDevice(app, device)
+	return err
+}
+
+func findActiveDevice(app core.App, userId, deviceId string) (models.Device, error) {
+	device, err := database.GetDevice(app.DB(), userId, deviceId)
+	if errors.Is(err, sql.ErrNoRows) {
+		return device, notFound("Device not found.", err)
+	} else if err != nil {
+		return device, internalError("Failed to get device data.", err)
+	}
+
+	if !device.RevokedAt.IsZero() {
+		return device, notFound("Device not found.", nil)
+	}
+
+	return device, nil
+}
+
+// requestDevice returns the device a user's request was sent from, rejecting
+// unknown and revoked devices. Once a user registered a device, every request
+// must name one, or a revoked device would only have to drop the header to
+// keep its access. Requests of users without devices have no device.
+func requestDevice(e *core.RequestEvent) (models.Device, error) {
+	if e.Auth == nil || e.Auth.Collection().Name != "users" {
+		return models.Device{}, nil
+	}
+
+	deviceId := e.Request.Header.Get(DeviceHeader)
+	if deviceId == "" {
+		count, err := database.CountDevices(e.App.DB(), e.Auth.Id)
+		if err != nil {
+			return models.Device{}, internalError("Failed to get device data.", err)
+		}
+
+		if count > 0 {
+			return models.Device{}, unauthorized("The "+DeviceHeader+" header is required once a device is registered.", nil)
+		}
+
+		return models.Device{}, nil
+	}
+
+	device, err := database.GetDevice(e.App.DB(), e.Auth.Id, deviceId)
+	if errors.Is(err, sql.ErrNoRows) {
+		return device, unauthorized("Unknown device.", err)
+	} else if err != nil {
+		return device, internalError("Failed to get device data.", err)
+	}
+
+	if !device.RevokedAt.IsZero() {
+		return device, unauthorized("This device has been revoked.", nil)
+	}
+
+	return device, nil
+}
+
+// trackDevice rejects requests from revoked devices and records when a
+// device was last seen.
+func trackDevice(e *core.RequestEvent) error {
+	device, err := requestDevice(e)
+	if err != nil {
+		return err
+	}
+
+	if device.ID != "" {
+		touchDevice(e.App, device, e.Request.Header.Get(AppVersionHeader))
+	}
+
+	return e.Next()
+}
+
+// trackRecordDevice applies trackDevice to the records API.
+func trackRecordDevice(e *core.RecordRequestEvent) error {
+	if err := checkRecordsDevice(e.RequestEvent); err != nil {
+		return err
+	}
+
+	return e.Next()
+}
+
+// trackRecordsListDevice applies trackDevice to record listings.
+func trackRecordsListDevice(e *core.RecordsListRequestEvent) error {
+	if err := checkRecordsDevice(e.RequestEvent); err != nil {
+		return err
+	}
+
+	return e.Next()
+}
+
+// checkRecordsDevice is trackDevice for the records API, which doesn't write
+// ErrorResponses.
+func checkRecordsDevice(e *core.RequestEvent) error {
+	device, err := requestDevice(e)
+	if err != nil {
+		apiErr := toAPIError(err)
+		return e.Error(apiErr.status, apiErr.response.Message, nil)
+	}
+
+	if device.ID != "" {
+		touchDevice(e.App, device, e.Request.Header.Get(AppVersionHeader))
+	}
+
+	return nil
+}
+
+// tagLocationDevice attaches the sending device to locations created through
+// the records API, rejecting devices that were revoked or don't share their
+// location.
+func tagLocationDevice(e *core.RecordRequestEvent) error {
+	deviceId := e.Record.GetString("device")
+	if deviceId == "" {
+		deviceId = e.Request.Header.Get(DeviceHeader)
+	}
+
+	if deviceId == "" || e.Auth == nil {
+		return e.Next()
+	}
+
+	device, err := database.GetDevice(e.App.DB(), e.Auth.Id, deviceId)
+	if errors.Is(err, sql.ErrNoRows) {
+		return e.BadRequestError("Unknown device.", err)
+	} else if err != nil {
+		return e.InternalServerError("Failed to get device data.", err)
+	}
+
+	if !device.RevokedAt.IsZero() {
+		return e.ForbiddenError("This device has been revoked.", nil)
+	}
+
+	if !device.SharesLocation {
+		return e.ForbiddenError("This device doesn't share its location.", nil)
+	}
+
+	e.Record.Set("device", device.ID)
+
+	return e.Next()
+}
+
+// touchDevice updates the device's lastSeenAt (at most once per
+// deviceTouchInterval) and app version. Failures are only logged since they
+// shouldn't fail the request.
+func touchDevice(app core.App, device models.Device, appVersion string) {
+	appVersion = strings.TrimSpace(appVersion)
+	versionChanged := appVersion != "" && appVersion != device.AppVersion
+
+	if !versionChanged && time.Since(device.LastSeenAt.Time()) < deviceTouchInterval {
+		return
+	}
+
+	device.LastSeenAt = types.NowDateTime()
+	if versionChanged {
+		device.AppVersion = appVersion
+	}
+
+	if _, err := database.UpdateDevice(app, device); err != nil {
+		app.Logger().Warn("Failed to update device", "id", device.ID, "error", err.Error())
+	}
+}
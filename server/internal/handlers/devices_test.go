@@ -0,0 +1,406 @@
+package handlers_test
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ian-shakespeare/tribe-tracker/server/internal/database"
+	"github.com/ian-shakespeare/tribe-tracker/server/internal/handlers"
+	"github.com/ian-shakespeare/tribe-tracker/server/pkg/models"
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/tests"
+	"github.com/pocketbase/pocketbase/tools/types"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	lukePhoneId   = "lukephone000001"
+	lukeDisplayId = "lukedisplay0001"
+	lukeOldPadId  = "lukeoldpad00001"
+	leiaPhoneId   = "leiaphone000001"
+)
+
+// setupDeviceTestApp seeds a few devices for Luke and Leia.
+func setupDeviceTestApp(t testing.TB) *tests.TestApp {
+	app := setupTestApp(t)
+
+	now := types.NowDateTime()
+	seedRecords(t, app, "devices",
+		map[string]any{"id": lukePhoneId, "user": lukeId, "name": "Luke's iPhone", "platform": "ios", "sharesLocation": true, "lastSeenAt": now},
+		map[string]any{"id": lukeDisplayId, "user": lukeId, "name": "Kitchen", "platform": "display", "lastSeenAt": now},
+		map[string]any{"id": lukeOldPadId, "user": lukeId, "name": "Old iPad", "platform": "ios", "sharesLocation": true, "lastSeenAt": now, "revokedAt": now},
+		map[string]any{"id": leiaPhoneId, "user": leiaId, "name": "Leia's Pixel", "platform": "android", "sharesLocation": true, "lastSeenAt": now},
+	)
+
+	return app
+}
+
+func TestDevices(t *testing.T) {
+	token := generateToken(t, "users", "luke.skywalker@email.com")
+
+	scenarios := []tests.ApiScenario{
+		{
+			Name:            "unauthorized",
+			Method:          http.MethodGet,
+			URL:             "/mobile/devices",
+			ExpectedStatus:  http.StatusUnauthorized,
+			ExpectedContent: []string{`"code":"unauthorized"`},
+			TestAppFactory:  setupDeviceTestApp,
+		},
+		{
+			Name:   "list own devices",
+			Method: http.MethodGet,
+			URL:    "/mobile/devices",
+			Headers: map[string]string{
+				"Authorization":       token,
+				handlers.DeviceHeader: lukePhoneId,
+			},
+			ExpectedStatus:     http.StatusOK,
+			ExpectedContent:    []string{lukePhoneId, lukeDisplayId},
+			NotExpectedContent: []string{leiaPhoneId, lukeOldPadId},
+			TestAppFactory:     setupDeviceTestApp,
+		},
+		{
+			Name:   "register",
+			Method: http.MethodPost,
+			URL:    "/mobile/devices",
+			Body:   strings.NewReader(`{"name":" Luke's iPad ","platform":"ios","formFactor":"Tablet","appVersion":"0.1.0"}`),
+			Headers: map[string]string{
+				"Authorization": token,
+			},
+			ExpectedStatus:  http.StatusCreated,
+			ExpectedContent: []string{`"name":"Luke's iPad"`, `"platform":"ios"`, `"formFactor":"tablet"`, `"sharesLocation":false`},
+			TestAppFactory:  setupDeviceTestApp,
+		},
+		{
+			Name:   "register first phone",
+			Method: http.MethodPost,
+			URL:    "/mobile/devices",
+			Body:   strings.NewReader(`{"name":"Vader's iPhone","platform":"ios","formFactor":"phone"}`),
+			Headers: map[string]string{
+				"Authorization": generateToken(t, "users", "darth.vader@email.com"),
+			},
+			ExpectedStatus:  http.StatusCreated,
+			ExpectedContent: []string{`"formFactor":"phone"`, `"sharesLocation":true`},
+			TestAppFactory:  setupDeviceTestApp,
+		},
+		{
+			Name:   "register second phone",
+			Method: http.MethodPost,
+			URL:    "/mobile/devices",
+			Body:   strings.NewReader(`{"name":"Luke's new iPhone","platform":"ios","formFactor":"phone"}`),
+			Headers: map[string]string{
+				"Authorization": token,
+			},
+			ExpectedStatus:  http.StatusCreated,
+			ExpectedContent: []string{`"formFactor":"phone"`, `"sharesLocation":false`},
+			TestAppFactory:  setupDeviceTestApp,
+			AfterTestFunc: func(t testing.TB, app *tests.TestApp, res *http.Response) {
+				device, err := database.GetSharingDevice(app.DB(), lukeId)
+				require.NoError(t, err)
+				require.Equal(t, lukePhoneId, device.ID)
+			},
+		},
+		{
+			Name:   "register phone taking over sharing",
+			Method: http.MethodPost,
+			URL:    "/mobile/devices",
+			Body:   strings.NewReader(`{"name":"Luke's new iPhone","platform":"ios","formFactor":"phone","sharesLocation":true}`),
+			Headers: map[string]string{
+				"Authorization": token,
+			},
+			ExpectedStatus:  http.StatusCreated,
+			ExpectedContent: []string{`"sharesLocation":true`},
+			TestAppFactory:  setupDeviceTestApp,
+			AfterTestFunc: func(t testing.TB, app *tests.TestApp, res *http.Response) {
+				device, err := database.GetSharingDevice(app.DB(), lukeId)
+				require.NoError(t, err)
+				require.NotEqual(t, lukePhoneId, device.ID)
+			},
+		},
+		{
+			Name:   "register unknown form factor",
+			Method: http.MethodPost,
+			URL:    "/mobile/devices",
+			Body:   strings.NewReader(`{"name":"Comlink","platform":"web","formFactor":"wrist"}`),
+			Headers: map[string]string{
+				"Authorization": token,
+			},
+			ExpectedStatus:  http.StatusBadRequest,
+			ExpectedContent: []string{`"code":"validation_failed"`, `"formFactor":`},
+			TestAppFactory:  setupDeviceTestApp,
+		},
+		{
+			Name:   "register unknown platform",
+			Method: http.MethodPost,
+			URL:    "/mobile/devices",
+			Body:   strings.NewReader(`{"name":"Comlink","platform":"holonet"}`),
+			Headers: map[string]string{
+				"Authorization": token,
+			},
+			ExpectedStatus:  http.StatusBadRequest,
+			ExpectedContent: []string{`"code":"validation_failed"`, `"platform":`},
+			TestAppFactory:  setupDeviceTestApp,
+		},
+		{
+			Name:   "stop sharing location",
+			Method: http.MethodPatch,
+			URL:    "/mobile/devices/" + lukePhoneId,
+			Body:   strings.NewReader(`{"sharesLocation":false}`),
+			Headers: map[string]string{
+				"Authorization":       token,
+				handlers.DeviceHeader: lukePhoneId,
+			},
+			ExpectedStatus:  http.StatusOK,
+			ExpectedContent: []string{`"name":"Luke's iPhone"`, `"sharesLocation":false`},
+			TestAppFactory:  setupDeviceTestApp,
+		},
+		{
+			Name:   "share location from another device",
+			Method: http.MethodPatch,
+			URL:    "/mobile/devices/" + lukeDisplayId,
+			Body:   strings.NewReader(`{"sharesLocation":true}`),
+			Headers: map[string]string{
+				"Authorization":       token,
+				handlers.DeviceHeader: lukePhoneId,
+			},
+			BeforeTestFunc: func(t testing.TB, app *tests.TestApp, e *core.ServeEvent) {
+				_, err := database.CreateLocation(app, models.Location{User: lukeId, Device: lukePhoneId}, types.GeoPoint{Lon: 8.99, Lat: 33.47})
+				require.NoError(t, err)
+			},
+			ExpectedStatus:  http.StatusOK,
+			ExpectedContent: []string{`"name":"Kitchen"`, `"sharesLocation":true`},
+			TestAppFactory:  setupDeviceTestApp,
+			AfterTestFunc: func(t testing.TB, app *tests.TestApp, res *http.Response) {
+				phone, err := database.GetDevice(app.DB(), lukeId, lukePhoneId)
+				require.NoError(t, err)
+				require.False(t, phone.SharesLocation)
+
+				// the phone's location isn't Luke's location anymore
+				locations, err := database.GetLatestLocations(app.DB(), skywalkersId, time.Time{})
+				require.NoError(t, err)
+				for _, location := range locations {
+					require.NotEqual(t, lukePhoneId, location.Device)
+				}
+			},
+		},
+		{
+			Name:   "revoke another user's device",
+			Method: http.MethodDelete,
+			URL:    "/mobile/devices/" + leiaPhoneId,
+			Headers: map[string]string{
+				"Authorization":       token,
+				handlers.DeviceHeader: lukePhoneId,
+			},
+			ExpectedStatus:  http.StatusNotFound,
+			ExpectedContent: []string{`"code":"not_found"`},
+			TestAppFactory:  setupDeviceTestApp,
+		},
+		{
+			Name:   "revoke",
+			Method: http.MethodDelete,
+			URL:    "/mobile/devices/" + lukePhoneId,
+			Headers: map[string]string{
+				"Authorization":       token,
+				handlers.DeviceHeader: lukePhoneId,
+			},
+			ExpectedStatus: http.StatusNoContent,
+			TestAppFactory: setupDeviceTestApp,
+			AfterTestFunc: func(t testing.TB, app *tests.TestApp, res *http.Response) {
+				device, err := app.FindRecordById("devices", lukePhoneId)
+				require.NoError(t, err)
+				require.False(t, device.GetDateTime("revokedAt").IsZero())
+			},
+		},
+		{
+			Name:   "request without device",
+			Method: http.MethodGet,
+			URL:    "/mobile/devices",
+			Headers: map[string]string{
+				"Authorization": token,
+			},
+			ExpectedStatus:  http.StatusUnauthorized,
+			ExpectedContent: []string{`"code":"unauthorized"`, handlers.DeviceHeader},
+			TestAppFactory:  setupDeviceTestApp,
+		},
+		{
+			Name:   "request without device before registering one",
+			Method: http.MethodGet,
+			URL:    "/mobile/devices",
+			Headers: map[string]string{
+				"Authorization": generateToken(t, "users", vaderEmail),
+			},
+			ExpectedStatus:  http.StatusOK,
+			ExpectedContent: []string{`[]`},
+			TestAppFactory:  setupDeviceTestApp,
+		},
+		{
+			Name:   "request from revoked device",
+			Method: http.MethodGet,
+			URL:    "/mobile/devices",
+			Headers: map[string]string{
+				"Authorization":       token,
+				handlers.DeviceHeader: lukeOldPadId,
+			},
+			ExpectedStatus:  http.StatusUnauthorized,
+			ExpectedContent: []string{`"code":"unauthorized"`, `revoked`},
+			TestAppFactory:  setupDeviceTestApp,
+		},
+		{
+			Name:   "request updates app version",
+			Method: http.MethodGet,
+			URL:    "/mobile/devices",
+			Headers: map[string]string{
+				"Authorization":           token,
+				handlers.DeviceHeader:     lukePhoneId,
+				handlers.AppVersionHeader: "0.2.0",
+			},
+			ExpectedStatus:  http.StatusOK,
+			ExpectedContent: []string{`"appVersion":"0.2.0"`},
+			TestAppFactory:  setupDeviceTestApp,
+			AfterTestFunc: func(t testing.TB, app *tests.TestApp, res *http.Response) {
+				device, err := app.FindRecordById("devices", lukePhoneId)
+				require.NoError(t, err)
+				require.Equal(t, "0.2.0", device.GetString("appVersion"))
+			},
+		},
+	}
+
+	for _, scenario := range scenarios {
+		scenario.Test(t)
+	}
+}
+
+func TestLocationDevice(t *testing.T) {
+	token := generateToken(t, "users", "luke.skywalker@email.com")
+
+	path := "/api/collections/locations/records"
+	body := `{"user":"` + lukeId + `","coordinates":{"lon":8.99,"lat":33.47}}`
+	scenarios := []tests.ApiScenario{
+		{
+			Name:   "tagged from header",
+			Method: http.MethodPost,
+			URL:    path,
+			Body:   strings.NewReader(body),
+			Headers: map[string]string{
+				"Authorization":       token,
+				"Content-Type":        "application/json",
+				handlers.DeviceHeader: lukePhoneId,
+			},
+			ExpectedStatus:  http.StatusOK,
+			ExpectedContent: []string{`"device":"` + lukePhoneId + `"`},
+			TestAppFactory:  setupDeviceTestApp,
+		},
+		{
+			Name:   "another user's device",
+			Method: http.MethodPost,
+			URL:    path,
+			Body:   strings.NewReader(body),
+			Headers: map[string]string{
+				"Authorization":       token,
+				"Content-Type":        "application/json",
+				handlers.DeviceHeader: leiaPhoneId,
+			},
+			ExpectedStatus:  http.StatusUnauthorized,
+			ExpectedContent: []string{`"message":"Unknown device."`},
+			TestAppFactory:  setupDeviceTestApp,
+		},
+		{
+			Name:   "revoked device",
+			Method: http.MethodPost,
+			URL:    path,
+			Body:   strings.NewReader(body),
+			Headers: map[string]string{
+				"Authorization":       token,
+				"Content-Type":        "application/json",
+				handlers.DeviceHeader: lukeOldPadId,
+			},
+			ExpectedStatus:  http.StatusUnauthorized,
+			ExpectedContent: []string{`revoked`},
+			TestAppFactory:  setupDeviceTestApp,
+		},
+		{
+			Name:   "without device",
+			Method: http.MethodPost,
+			URL:    path,
+			Body:   strings.NewReader(body),
+			Headers: map[string]string{
+				"Authorization": token,
+				"Content-Type":  "application/json",
+			},
+			ExpectedStatus:  http.StatusUnauthorized,
+			ExpectedContent: []string{handlers.DeviceHeader},
+			TestAppFactory:  setupDeviceTestApp,
+		},
+		{
+			Name:   "listing records from a revoked device",
+			Method: http.MethodGet,
+			URL:    "/api/collections/families/records",
+			Headers: map[string]string{
+				"Authorization":       token,
+				handlers.DeviceHeader: lukeOldPadId,
+			},
+			ExpectedStatus:  http.StatusUnauthorized,
+			ExpectedContent: []string{`revoked`},
+			TestAppFactory:  setupDeviceTestApp,
+		},
+		{
+			Name:   "device not sharing location",
+			Method: http.MethodPost,
+			URL:    path,
+			Body:   strings.NewReader(body),
+			Headers: map[string]string{
+				"Authorization":       token,
+				"Content-Type":        "application/json",
+				handlers.DeviceHeader: lukeDisplayId,
+			},
+			ExpectedStatus:  http.StatusForbidden,
+			ExpectedContent: []string{`share its location`},
+			TestAppFactory:  setupDeviceTestApp,
+		},
+	}
+
+	for _, scenario := range scenarios {
+		scenario.Test(t)
+	}
+}
+
+func TestUntaggedLocations(t *testing.T) {
+	app := setupDeviceTestApp(t)
+	defer app.Cleanup()
+
+	coordinates := types.GeoPoint{Lon: 8.99, Lat: 33.47}
+
+	phone, err := database.CreateLocation(app, models.Location{User: lukeId, Device: lukePhoneId}, coordinates)
+	require.NoError(t, err)
+
+	// sent by a client that doesn't tell its device
+	untagged, err := database.CreateLocation(app, models.Location{User: lukeId}, coordinates)
+	require.NoError(t, err)
+
+	latestOfLuke := func() string {
+		locations, err := database.GetLatestLocations(app.DB(), skywalkersId, time.Time{})
+		require.NoError(t, err)
+
+		for _, location := range locations {
+			if location.User == lukeId {
+				return location.ID
+			}
+		}
+
+		return ""
+	}
+
+	require.Equal(t, phone.ID, latestOfLuke())
+
+	// without a sharing device, untagged locations are all there is
+	device, err := app.FindRecordById("devices", lukePhoneId)
+	require.NoError(t, err)
+
+	device.Set("sharesLocation", false)
+	require.NoError(t, app.Save(device))
+
+	require.Equal(t, untagged.ID, latestOfLuke())
+}
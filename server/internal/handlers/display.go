@@ -0,0 +1,448 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	validation "github.com/go-ozzo/ozzo-validation/v4"
+	"github.com/ian-shakespeare/tribe-tracker/server/internal/database"
+	"github.com/ian-shakespeare/tribe-tracker/server/internal/geo"
+	"github.com/ian-shakespeare/tribe-tracker/server/pkg/models"
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/tools/security"
+	"github.com/pocketbase/pocketbase/tools/types"
+)
+
+const (
+	// displayTokenPrefix makes display tokens distinguishable from user auth
+	// tokens at a glance.
+	displayTokenPrefix = "dt_"
+	displayTokenLength = 48
+
+	// displayTokenKey is the request store key holding the authorized
+	// models.DisplayToken.
+	displayTokenKey = "displayToken"
+
+	// displayTouchInterval limits how often a token's lastUsedAt is written.
+	displayTouchInterval = time.Minute
+
+	displayPollInterval      = 5 * time.Second
+	displayHeartbeatInterval = 30 * time.Second
+
+	// locations younger than displayLiveAfter are shown as live, and older
+	// than displayStaleAfter as stale
+	displayLiveAfter  = 5 * time.Minute
+	displayStaleAfter = time.Hour
+)
+
+// Freshness of a member's position on the display.
+const (
+	freshnessLive    = "live"
+	freshnessRecent  = "recent"
+	freshnessStale   = "stale"
+	freshnessUnknown = "unknown"
+)
+
+type createDisplayTokenRequest struct {
+	Name string `json:"name"`
+}
+
+func (r *createDisplayTokenRequest) normalize() {
+	r.Name = strings.TrimSpace(r.Name)
+}
+
+func (r *createDisplayTokenRequest) validate(app core.App) error {
+	return validation.ValidateStruct(r,
+		validation.Field(&r.Name, collectionField(app, "displayTokens", "name")),
+	)
+}
+
+func listDisplayTokens(e *core.RequestEvent) error {
+	familyId := e.Request.PathValue("id")
+	if _, err := findMembership(e.App, familyId, e.Auth.Id, models.RoleOwner); err != nil {
+		return err
+	}
+
+	tokens, err := database.GetDisplayTokens(e.App.DB(), familyId)
+	if err != nil {
+		return internalError("Failed to get display token data.", err)
+	}
+
+	return e.JSON(http.StatusOK, tokens)
+}
+
+func createDisplayToken(e *core.RequestEvent) error {
+	userId := e.Auth.Id
+	familyId := e.Request.PathValue("id")
+
+	var req createDisplayTokenRequest
+	if err := readBody(e, &req); err != nil {
+		return err
+	}
+
+	if _, err := findMembership(e.App, familyId, userId, models.RoleOwner); err != nil {
+		return err
+	}
+
+	secret := displayTokenPrefix + security.RandomString(displayTokenLength)
+
+	token, err := database.CreateDisplayToken(e.App, models.DisplayToken{
+		Family:    familyId,
+		CreatedBy: userId,
+		Name:      req.Name,
+	}, security.SHA256(secret))
+	if err != nil {
+		return fromSaveError(err, "Failed to create display token.")
+	}
+
+	// the secret is only ever returned here, afterwards only its hash is known
+	var res struct {
+		DisplayToken models.DisplayToken `json:"displayToken"`
+		Token        string              `json:"token"`
+	}
+	res.DisplayToken = token
+	res.Token = secret
+
+	return e.JSON(http.StatusCreated, res)
+}
+
+func revokeDisplayToken(e *core.RequestEvent) error {
+	familyId := e.Request.PathValue("id")
+	if _, err := findMembership(e.App, familyId, e.Auth.Id, models.RoleOwner); err != nil {
+		return err
+	}
+
+	token, err := database.GetDisplayToken(e.App.DB(), familyId, e.Request.PathValue("tokenId"))
+	if errors.Is(err, sql.ErrNoRows) {
+		return notFound("Display token not found.", err)
+	} else if err != nil {
+		return internalError("Failed to get display token data.", err)
+	}
+
+	if token.RevokedAt.IsZero() {
+		token.RevokedAt = types.NowDateTime()
+		if _, err := database.UpdateDisplayToken(e.App, token); err != nil {
+			return fromSaveError(err, "Failed to revoke display token.")
+		}
+	}
+
+	return e.NoContent(http.StatusNoContent)
+}
+
+// requireDisplayToken authorizes /display requests with a display token,
+// only allowing reads of the family the token was minted for.
+func requireDisplayToken(e *core.RequestEvent) error {
+	if e.Request.Method != http.MethodGet && e.Request.Method != http.MethodHead {
+		return forbidden("Display tokens are read-only.", nil)
+	}
+
+	secret := strings.TrimSpace(strings.TrimPrefix(e.Request.Header.Get("Authorization"), "Bearer "))
+	if !strings.HasPrefix(secret, displayTokenPrefix) {
+		return unauthorized("The request requires a valid display token.", nil)
+	}
+
+	token, err := database.GetDisplayTokenByHash(e.App.DB(), security.SHA256(secret))
+	if errors.Is(err, sql.ErrNoRows) {
+		return unauthorized("The request requires a valid display token.", err)
+	} else if err != nil {
+		return internalError("Failed to get display token data.", err)
+	}
+
+	if familyId := e.Request.PathValue("id"); familyId != "" && familyId != token.Family {
+		return forbidden("This display token can't access that family.", nil)
+	}
+
+	if err := checkDisplayToken(e.App.DB(), token); err != nil {
+		return err
+	}
+
+	if time.Since(token.LastUsedAt.Time()) >= displayTouchInterval {
+		token.LastUsedAt = types.NowDateTime()
+		if _, err := database.UpdateDisplayToken(e.App, token); err != nil {
+			e.App.Logger().Warn("Failed to update display token", "id", token.ID, "error", err.Error())
+		}
+	}
+
+	e.Set(displayTokenKey, token)
+
+	return e.Next()
+}
+
+// checkDisplayToken reports whether the token still grants access to its
+// family. Tokens stop working once revoked, when the family is deleted, or
+// when their creator is no longer one of its owners.
+func checkDisplayToken(db dbx.Builder, token models.DisplayToken) error {
+	if !token.RevokedAt.IsZero() {
+		return unauthorized("This display token has been revoked.", nil)
+	}
+
+	if _, err := database.GetFamily(db, token.Family); errors.Is(err, sql.ErrNoRows) {
+		return unauthorized("This display token's family has been deleted.", err)
+	} else if err != nil {
+		return internalError("Failed to get family data.", err)
+	}
+
+	creator, err := database.GetFamilyMember(db, token.Family, token.CreatedBy)
+	if errors.Is(err, sql.ErrNoRows) || (err == nil && creator.Role != models.RoleOwner) {
+		return unauthorized("The creator of this display token can no longer share the family.", err)
+	} else if err != nil {
+		return internalError("Failed to get family member data.", err)
+	}
+
+	return nil
+}
+
+type snapshotPlace struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+type snapshotMember struct {
+	User           string          `json:"user"`
+	FirstName      string          `json:"firstName"`
+	LastName       string          `json:"lastName"`
+	AvatarThumbURL string          `json:"avatarThumbUrl"`
+	Coordinates    *types.GeoPoint `json:"coordinates"`
+	SeenAt         types.DateTime  `json:"seenAt"`
+	Freshness      string          `json:"freshness"`
+	Place          *snapshotPlace  `json:"place"`
+}
+
+// getFamilySnapshot returns everything the display needs to render the
+// family's map in a single response.
+func getFamilySnapshot(e *core.RequestEvent) error {
+	token, _ := e.Get(displayTokenKey).(models.DisplayToken)
+
+	family, err := database.GetFamily(e.App.DB(), token.Family)
+	if errors.Is(err, sql.ErrNoRows) {
+		return notFound("Family not found.", err)
+	} else if err != nil {
+		return internalError("Failed to get family data.", err)
+	}
+
+	members, err := database.GetMembers(e.App.DB(), family.ID)
+	if err != nil {
+		return internalError("Failed to get member data.", err)
+	}
+
+	locations, err := database.GetLatestLocations(e.App.DB(), family.ID, time.Time{})
+	if err != nil {
+		return internalError("Failed to get location data.", err)
+	}
+
+	if err := applyPrecision(locations, familyPrecision(family)); err != nil {
+		return internalError("Failed to read location data.", err)
+	}
+
+	places, err := database.GetPlaces(e.App.DB(), family.ID)
+	if err != nil {
+		return internalError("Failed to get place data.", err)
+	}
+
+	latest := make(map[string]models.Location, len(locations))
+	for _, location := range locations {
+		latest[location.User] = location
+	}
+
+	points := make([]types.GeoPoint, 0, len(members))
+	snapshotMembers := make([]snapshotMember, 0, len(members))
+	for _, member := range members {
+		snapshot := snapshotMember{
+			User:           member.User,
+			FirstName:      member.FirstName,
+			LastName:       member.LastName,
+			AvatarThumbURL: displayAvatarURL(e.App, family.ID, member),
+			Freshness:      freshnessUnknown,
+		}
+
+		if location, ok := latest[member.User]; ok {
+			var point types.GeoPoint
+			if err := point.Scan(location.Coordinates); err != nil {
+				return internalError("Failed to read location data.", err)
+			}
+
+			snapshot.Coordinates = &point
+			snapshot.SeenAt = location.RecordedAt
+			snapshot.Freshness = freshness(location.RecordedAt.Time())
+			snapshot.Place = findPlace(places, point)
+			points = append(points, point)
+		}
+
+		snapshotMembers = append(snapshotMembers, snapshot)
+	}
+
+	var res struct {
+		Family struct {
+			ID   string `json:"id"`
+			Name string `json:"name"`
+		} `json:"family"`
+		Members []snapshotMember `json:"members"`
+		Bounds  *geo.Bounds      `json:"bounds"`
+	}
+	res.Family.ID = family.ID
+	res.Family.Name = family.Name
+	res.Members = snapshotMembers
+	res.Bounds = geo.NewBounds(points...)
+
+	return jsonWithETag(e, http.StatusOK, res)
+}
+
+func freshness(seenAt time.Time) string {
+	switch age := time.Since(seenAt); {
+	case age < displayLiveAfter:
+		return freshnessLive
+	case age < displayStaleAfter:
+		return freshnessRecent
+	default:
+		return freshnessStale
+	}
+}
+
+// findPlace returns the closest place whose geofence contains the point.
+func findPlace(places []models.Place, point types.GeoPoint) *snapshotPlace {
+	var (
+		closest  *snapshotPlace
+		distance float64
+	)
+
+	for _, place := range places {
+		var center types.GeoPoint
+		if err := center.Scan(place.Coordinates); err != nil {
+			continue
+		}
+
+		d := geo.Distance(center, point)
+		if d <= place.Radius && (closest == nil || d < distance) {
+			closest = &snapshotPlace{ID: place.ID, Name: place.Name}
+			distance = d
+		}
+	}
+
+	return closest
+}
+
+// streamFamily sends the latest location of every family member as a
+// server-sent event, followed by an event for every new location.
+func streamFamily(e *core.RequestEvent) error {
+	token, _ := e.Get(displayTokenKey).(models.DisplayToken)
+
+	family, err := database.GetFamily(e.App.DB(), token.Family)
+	if errors.Is(err, sql.ErrNoRows) {
+		return notFound("Family not found.", err)
+	} else if err != nil {
+		return internalError("Failed to get family data.", err)
+	}
+
+	readAt := time.Now()
+	locations, err := database.GetLatestLocations(e.App.DB(), token.Family, time.Time{})
+	if err != nil {
+		return internalError("Failed to get location data.", err)
+	}
+
+	header := e.Response.Header()
+	header.Set("Content-Type", "text/event-stream")
+	header.Set("Cache-Control", "no-store")
+	header.Set("X-Accel-Buffering", "no")
+	e.Response.WriteHeader(http.StatusOK)
+
+	// errors can't be reported to the client once the stream has started,
+	// so from here on they are only logged
+	var cursor time.Time
+	// polls overlap by syncCursorMargin, like syncs, so locations committed
+	// late aren't missed. Those already sent, or older than what was, are
+	// skipped.
+	sent := map[string]models.Location{}
+	send := func(locations []models.Location, readAt time.Time) error {
+		fresh := make([]models.Location, 0, len(locations))
+		for _, location := range locations {
+			cursor = nextCursor(cursor, location.CreatedAt.String(), readAt)
+
+			last, ok := sent[location.User]
+			if ok && (last.ID == location.ID || location.RecordedAt.Time().Before(last.RecordedAt.Time())) {
+				continue
+			}
+
+			sent[location.User] = location
+			fresh = append(fresh, location)
+		}
+
+		if len(fresh) == 0 {
+			return nil
+		}
+		locations = fresh
+
+		if err := applyPrecision(locations, familyPrecision(family)); err != nil {
+			return err
+		}
+
+		data, err := json.Marshal(locations)
+		if err != nil {
+			return err
+		}
+
+		if _, err := fmt.Fprintf(e.Response, "event: locations\ndata: %s\n\n", data); err != nil {
+			return err
+		}
+
+		return e.Flush()
+	}
+
+	if err := send(locations, readAt); err != nil {
+		e.App.Logger().Debug("Failed to write display stream", "error", err.Error())
+		return nil
+	}
+
+	poll := time.NewTicker(displayPollInterval)
+	defer poll.Stop()
+
+	heartbeat := time.NewTicker(displayHeartbeatInterval)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-e.Request.Context().Done():
+			return nil
+		case <-heartbeat.C:
+			if _, err := fmt.Fprint(e.Response, ": heartbeat\n\n"); err != nil {
+				return nil
+			}
+
+			if err := e.Flush(); err != nil {
+				return nil
+			}
+		case <-poll.C:
+			current, err := database.GetDisplayToken(e.App.DB(), token.Family, token.ID)
+			if err == nil {
+				err = checkDisplayToken(e.App.DB(), current)
+			}
+			if err != nil {
+				_, _ = fmt.Fprint(e.Response, "event: revoked\ndata: {}\n\n")
+				_ = e.Flush()
+				return nil
+			}
+
+			// the family's precision may have changed since the last poll
+			if current, err := database.GetFamily(e.App.DB(), token.Family); err == nil {
+				family = current
+			}
+
+			readAt := time.Now()
+			locations, err := database.GetLatestLocations(e.App.DB(), token.Family, cursor)
+			if err != nil {
+				e.App.Logger().Warn("Failed to poll display stream locations", "family", token.Family, "error", err.Error())
+				continue
+			}
+
+			if err := send(locations, readAt); err != nil {
+				e.App.Logger().Debug("Failed to write display stream", "error", err.Error())
+				return nil
+			}
+		}
+	}
+}
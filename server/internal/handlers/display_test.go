@@ -0,0 +1,472 @@
+package handlers_test
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/tests"
+	"github.com/pocketbase/pocketbase/tools/security"
+	"github.com/pocketbase/pocketbase/tools/types"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	skywalkersId = "3re9axqzawl3esv"
+	empireId     = "empire000000001"
+
+	kitchenTokenId = "kitchendisplay1"
+	kitchenToken   = "dt_kitchen-display-secret"
+	hallwayToken   = "dt_hallway-display-secret"
+	empireToken    = "dt_empire-display-secret"
+)
+
+// setupDisplayTestApp seeds a second family and display tokens for both
+// families, one of which is revoked.
+func setupDisplayTestApp(t testing.TB) *tests.TestApp {
+	app := setupTestApp(t)
+
+	seedRecords(t, app, "families",
+		map[string]any{"id": empireId, "name": "Empire", "code": "death-star", "createdBy": vaderId},
+	)
+
+	seedRecords(t, app, "displayTokens",
+		map[string]any{"id": kitchenTokenId, "family": skywalkersId, "createdBy": lukeId, "name": "Kitchen", "tokenHash": security.SHA256(kitchenToken)},
+		map[string]any{"family": skywalkersId, "createdBy": lukeId, "name": "Hallway", "tokenHash": security.SHA256(hallwayToken), "revokedAt": types.NowDateTime()},
+		map[string]any{"family": empireId, "createdBy": vaderId, "name": "Bridge", "tokenHash": security.SHA256(empireToken)},
+	)
+
+	seedRecords(t, app, "places",
+		map[string]any{"id": "larshomestead01", "family": skywalkersId, "name": "Lars Homestead", "coordinates": types.GeoPoint{Lon: 8.9870, Lat: 33.4682}, "radius": 100},
+	)
+
+	return app
+}
+
+func TestDisplayTokens(t *testing.T) {
+	owner := generateToken(t, "users", "luke.skywalker@email.com")
+	member := generateToken(t, "users", "leia.organa@email.com")
+	stranger := generateToken(t, "users", "darth.vader@email.com")
+
+	path := "/mobile/families/" + skywalkersId + "/display-tokens"
+	scenarios := []tests.ApiScenario{
+		{
+			Name:   "list as owner",
+			Method: http.MethodGet,
+			URL:    path,
+			Headers: map[string]string{
+				"Authorization": owner,
+			},
+			ExpectedStatus:     http.StatusOK,
+			ExpectedContent:    []string{`"name":"Kitchen"`},
+			NotExpectedContent: []string{`"name":"Hallway"`, `"name":"Bridge"`, `tokenHash`},
+			TestAppFactory:     setupDisplayTestApp,
+		},
+		{
+			Name:   "create as owner",
+			Method: http.MethodPost,
+			URL:    path,
+			Body:   strings.NewReader(`{"name":"Living Room"}`),
+			Headers: map[string]string{
+				"Authorization": owner,
+			},
+			ExpectedStatus:  http.StatusCreated,
+			ExpectedContent: []string{`"name":"Living Room"`, `"token":"dt_`},
+			TestAppFactory:  setupDisplayTestApp,
+		},
+		{
+			Name:   "create as member",
+			Method: http.MethodPost,
+			URL:    path,
+			Body:   strings.NewReader(`{"name":"Living Room"}`),
+			Headers: map[string]string{
+				"Authorization": member,
+			},
+			ExpectedStatus:  http.StatusForbidden,
+			ExpectedContent: []string{`"code":"forbidden"`},
+			TestAppFactory:  setupDisplayTestApp,
+		},
+		{
+			Name:   "create as non-member",
+			Method: http.MethodPost,
+			URL:    path,
+			Body:   strings.NewReader(`{"name":"Living Room"}`),
+			Headers: map[string]string{
+				"Authorization": stranger,
+			},
+			ExpectedStatus:  http.StatusNotFound,
+			ExpectedContent: []string{`"code":"not_found"`},
+			TestAppFactory:  setupDisplayTestApp,
+		},
+		{
+			Name:   "revoke",
+			Method: http.MethodDelete,
+			URL:    path + "/" + kitchenTokenId,
+			Headers: map[string]string{
+				"Authorization": owner,
+			},
+			ExpectedStatus: http.StatusNoContent,
+			TestAppFactory: setupDisplayTestApp,
+			AfterTestFunc: func(t testing.TB, app *tests.TestApp, res *http.Response) {
+				token, err := app.FindRecordById("displayTokens", kitchenTokenId)
+				require.NoError(t, err)
+				require.False(t, token.GetDateTime("revokedAt").IsZero())
+			},
+		},
+	}
+
+	for _, scenario := range scenarios {
+		scenario.Test(t)
+	}
+}
+
+func TestDisplayStream(t *testing.T) {
+	user := generateToken(t, "users", "luke.skywalker@email.com")
+
+	path := "/display/families/" + skywalkersId + "/stream"
+	scenarios := []tests.ApiScenario{
+		{
+			Name:            "no token",
+			Method:          http.MethodGet,
+			URL:             path,
+			ExpectedStatus:  http.StatusUnauthorized,
+			ExpectedContent: []string{`"code":"unauthorized"`},
+			TestAppFactory:  setupDisplayTestApp,
+		},
+		{
+			Name:   "user token",
+			Method: http.MethodGet,
+			URL:    path,
+			Headers: map[string]string{
+				"Authorization": user,
+			},
+			ExpectedStatus:  http.StatusUnauthorized,
+			ExpectedContent: []string{`"code":"unauthorized"`},
+			TestAppFactory:  setupDisplayTestApp,
+		},
+		{
+			Name:   "revoked token",
+			Method: http.MethodGet,
+			URL:    path,
+			Headers: map[string]string{
+				"Authorization": "Bearer " + hallwayToken,
+			},
+			ExpectedStatus:  http.StatusUnauthorized,
+			ExpectedContent: []string{`"code":"unauthorized"`, `revoked`},
+			TestAppFactory:  setupDisplayTestApp,
+		},
+		{
+			Name:   "other family's token",
+			Method: http.MethodGet,
+			URL:    path,
+			Headers: map[string]string{
+				"Authorization": "Bearer " + empireToken,
+			},
+			ExpectedStatus:  http.StatusForbidden,
+			ExpectedContent: []string{`"code":"forbidden"`},
+			TestAppFactory:  setupDisplayTestApp,
+		},
+		{
+			Name:   "write",
+			Method: http.MethodPost,
+			URL:    path,
+			Headers: map[string]string{
+				"Authorization": "Bearer " + kitchenToken,
+			},
+			NotExpectedContent: []string{`event: locations`},
+			ExpectedStatus:     http.StatusNotFound,
+			TestAppFactory:     setupDisplayTestApp,
+		},
+		{
+			Name:   "initial locations",
+			Method: http.MethodGet,
+			URL:    path,
+			Headers: map[string]string{
+				"Authorization": "Bearer " + kitchenToken,
+			},
+			Timeout:         100 * time.Millisecond,
+			ExpectedStatus:  http.StatusOK,
+			ExpectedContent: []string{"event: locations\ndata: [", `"user":"` + lukeId + `"`, `"user":"` + leiaId + `"`},
+			// not a member of the family
+			NotExpectedContent: []string{`"user":"edhmc5ydeq7xb4h"`},
+			TestAppFactory:     setupDisplayTestApp,
+			AfterTestFunc: func(t testing.TB, app *tests.TestApp, res *http.Response) {
+				token, err := app.FindRecordById("displayTokens", kitchenTokenId)
+				require.NoError(t, err)
+				require.False(t, token.GetDateTime("lastUsedAt").IsZero())
+			},
+		},
+		{
+			Name:   "late commit",
+			Method: http.MethodGet,
+			URL:    path,
+			Headers: map[string]string{
+				"Authorization": "Bearer " + kitchenToken,
+			},
+			// long enough for a poll
+			Timeout:         6 * time.Second,
+			ExpectedStatus:  http.StatusOK,
+			ExpectedContent: []string{`"id":"leianewlocation"`, `"id":"lukelatelocatio"`},
+			TestAppFactory:  setupDisplayTestApp,
+			BeforeTestFunc: func(t testing.TB, app *tests.TestApp, e *core.ServeEvent) {
+				now := time.Now()
+				seedRecords(t, app, "locations",
+					map[string]any{"id": "leianewlocation", "user": leiaId, "coordinates": types.GeoPoint{Lon: 8.99, Lat: 33.47}, "createdAt": now},
+				)
+
+				// stamped before Leia's location but only committed once the
+				// stream sent it
+				locations, err := app.FindCollectionByNameOrId("locations")
+				require.NoError(t, err)
+
+				luke := core.NewRecord(locations)
+				luke.Set("id", "lukelatelocatio")
+				luke.Set("user", lukeId)
+				luke.Set("coordinates", types.GeoPoint{Lon: 8.98, Lat: 33.46})
+				luke.SetRaw("createdAt", types.NowDateTime().Add(-time.Second))
+
+				go func() {
+					time.Sleep(time.Second)
+					if err := app.Save(luke); err != nil {
+						app.Logger().Error("Failed to save late location", "error", err)
+					}
+				}()
+			},
+		},
+		{
+			Name:   "approximate precision",
+			Method: http.MethodGet,
+			URL:    path,
+			Headers: map[string]string{
+				"Authorization": "Bearer " + kitchenToken,
+			},
+			Timeout:            100 * time.Millisecond,
+			ExpectedStatus:     http.StatusOK,
+			ExpectedContent:    []string{`{\"lon\":8.99,\"lat\":33.47}`},
+			NotExpectedContent: []string{`8.986816`},
+			TestAppFactory: func(t testing.TB) *tests.TestApp {
+				app := setupDisplayTestApp(t)
+
+				family, err := app.FindRecordById("families", skywalkersId)
+				require.NoError(t, err)
+				family.Set("sharingPrecision", "approximate")
+				require.NoError(t, app.Save(family))
+
+				return app
+			},
+		},
+	}
+
+	for _, scenario := range scenarios {
+		scenario.Test(t)
+	}
+}
+
+func TestDisplaySnapshot(t *testing.T) {
+	path := "/display/families/" + skywalkersId + "/snapshot"
+
+	conditional := map[string]string{
+		"Authorization": "Bearer " + kitchenToken,
+	}
+
+	scenarios := []tests.ApiScenario{
+		{
+			Name:            "no token",
+			Method:          http.MethodGet,
+			URL:             path,
+			ExpectedStatus:  http.StatusUnauthorized,
+			ExpectedContent: []string{`"code":"unauthorized"`},
+			TestAppFactory:  setupDisplayTestApp,
+		},
+		{
+			Name:   "other family's token",
+			Method: http.MethodGet,
+			URL:    path,
+			Headers: map[string]string{
+				"Authorization": "Bearer " + empireToken,
+			},
+			ExpectedStatus:  http.StatusForbidden,
+			ExpectedContent: []string{`"code":"forbidden"`},
+			TestAppFactory:  setupDisplayTestApp,
+		},
+		{
+			Name:   "deleted family",
+			Method: http.MethodGet,
+			URL:    path,
+			Headers: map[string]string{
+				"Authorization": "Bearer " + kitchenToken,
+			},
+			BeforeTestFunc: func(t testing.TB, app *tests.TestApp, e *core.ServeEvent) {
+				family, err := app.FindRecordById("families", skywalkersId)
+				require.NoError(t, err)
+				family.Set("isDeleted", true)
+				require.NoError(t, app.Save(family))
+			},
+			ExpectedStatus:  http.StatusUnauthorized,
+			ExpectedContent: []string{`"code":"unauthorized"`, `deleted`},
+			TestAppFactory:  setupDisplayTestApp,
+		},
+		{
+			Name:   "creator demoted",
+			Method: http.MethodGet,
+			URL:    path,
+			Headers: map[string]string{
+				"Authorization": "Bearer " + kitchenToken,
+			},
+			BeforeTestFunc: func(t testing.TB, app *tests.TestApp, e *core.ServeEvent) {
+				membership, err := app.FindRecordById("familyMembers", lukeMembershipId)
+				require.NoError(t, err)
+				membership.Set("role", "member")
+				require.NoError(t, app.Save(membership))
+			},
+			ExpectedStatus:  http.StatusUnauthorized,
+			ExpectedContent: []string{`"code":"unauthorized"`, `creator`},
+			TestAppFactory:  setupDisplayTestApp,
+		},
+		{
+			Name:   "creator left",
+			Method: http.MethodGet,
+			URL:    path,
+			Headers: map[string]string{
+				"Authorization": "Bearer " + kitchenToken,
+			},
+			BeforeTestFunc: func(t testing.TB, app *tests.TestApp, e *core.ServeEvent) {
+				membership, err := app.FindRecordById("familyMembers", lukeMembershipId)
+				require.NoError(t, err)
+				require.NoError(t, app.Delete(membership))
+			},
+			ExpectedStatus:  http.StatusUnauthorized,
+			ExpectedContent: []string{`"code":"unauthorized"`, `creator`},
+			TestAppFactory:  setupDisplayTestApp,
+		},
+		{
+			Name:   "snapshot",
+			Method: http.MethodGet,
+			URL:    path,
+			Headers: map[string]string{
+				"Authorization": "Bearer " + kitchenToken,
+			},
+			ExpectedStatus: http.StatusOK,
+			ExpectedContent: []string{
+				`"family":{"id":"` + skywalkersId + `","name":"Skywalkers"}`,
+				`"user":"` + lukeId + `","firstName":"luke","lastName":"skywalker","avatarThumbUrl":"http://localhost:8090/display/families/` + skywalkersId + `/members/` + lukeId + `/avatar"`,
+				`"coordinates":{"lon":8.986816,"lat":33.468108}`,
+				`"freshness":"stale","place":{"id":"larshomestead01","name":"Lars Homestead"}`,
+				`"user":"bcruhrwalqnwncy"`,
+				`"bounds":{"minLat":33.468108,"minLon":8.986816,"maxLat":62.000905,"maxLon":9.008789}`,
+			},
+			NotExpectedContent: []string{`"user":"edhmc5ydeq7xb4h"`, `"email"`},
+			TestAppFactory:     setupDisplayTestApp,
+			AfterTestFunc: func(t testing.TB, app *tests.TestApp, res *http.Response) {
+				etag := res.Header.Get("ETag")
+				require.NotEmpty(t, etag)
+
+				conditional["If-None-Match"] = etag
+			},
+		},
+		{
+			Name:   "approximate precision",
+			Method: http.MethodGet,
+			URL:    path,
+			Headers: map[string]string{
+				"Authorization": "Bearer " + kitchenToken,
+			},
+			ExpectedStatus:     http.StatusOK,
+			ExpectedContent:    []string{`"coordinates":{"lon":8.99,"lat":33.47}`, `"coordinates":{"lon":9.01,"lat":62}`},
+			NotExpectedContent: []string{`8.986816`, `9.008789`},
+			TestAppFactory: func(t testing.TB) *tests.TestApp {
+				app := setupDisplayTestApp(t)
+
+				family, err := app.FindRecordById("families", skywalkersId)
+				require.NoError(t, err)
+				family.Set("sharingPrecision", "approximate")
+				require.NoError(t, app.Save(family))
+
+				return app
+			},
+		},
+		{
+			Name:           "not modified",
+			Method:         http.MethodGet,
+			URL:            path,
+			Headers:        conditional,
+			ExpectedStatus: http.StatusNotModified,
+			TestAppFactory: setupDisplayTestApp,
+		},
+		{
+			Name:   "modified",
+			Method: http.MethodGet,
+			URL:    path,
+			Headers: map[string]string{
+				"Authorization": "Bearer " + kitchenToken,
+				"If-None-Match": `"stale"`,
+			},
+			ExpectedStatus:  http.StatusOK,
+			ExpectedContent: []string{`"members":[`},
+			TestAppFactory:  setupDisplayTestApp,
+		},
+	}
+
+	for _, scenario := range scenarios {
+		scenario.Test(t)
+	}
+}
+
+func TestDisplayMemberAvatar(t *testing.T) {
+	path := func(familyId, userId string) string {
+		return "/display/families/" + familyId + "/members/" + userId + "/avatar"
+	}
+
+	scenarios := []tests.ApiScenario{
+		{
+			Name:            "unauthorized",
+			Method:          http.MethodGet,
+			URL:             path(skywalkersId, lukeId),
+			ExpectedStatus:  http.StatusUnauthorized,
+			ExpectedContent: []string{`"code":"unauthorized"`},
+			TestAppFactory:  setupDisplayTestApp,
+		},
+		{
+			Name:   "other family",
+			Method: http.MethodGet,
+			URL:    path(skywalkersId, lukeId),
+			Headers: map[string]string{
+				"Authorization": "Bearer " + empireToken,
+			},
+			ExpectedStatus:  http.StatusForbidden,
+			ExpectedContent: []string{`"code":"forbidden"`},
+			TestAppFactory:  setupDisplayTestApp,
+		},
+		{
+			Name:   "not a member",
+			Method: http.MethodGet,
+			URL:    path(skywalkersId, "edhmc5ydeq7xb4h"),
+			Headers: map[string]string{
+				"Authorization": "Bearer " + kitchenToken,
+			},
+			ExpectedStatus:  http.StatusNotFound,
+			ExpectedContent: []string{`"code":"not_found"`},
+			TestAppFactory:  setupDisplayTestApp,
+		},
+		{
+			Name:   "member",
+			Method: http.MethodGet,
+			URL:    path(skywalkersId, lukeId),
+			Headers: map[string]string{
+				"Authorization": "Bearer " + kitchenToken,
+			},
+			ExpectedStatus:     http.StatusOK,
+			NotExpectedContent: []string{`"code":`},
+			TestAppFactory:     setupDisplayTestApp,
+			AfterTestFunc: func(t testing.TB, app *tests.TestApp, res *http.Response) {
+				require.Equal(t, "image/jpeg", res.Header.Get("Content-Type"))
+			},
+		},
+	}
+
+	for _, scenario := range scenarios {
+		scenario.Test(t)
+	}
+}
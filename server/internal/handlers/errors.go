@@ -0,0 +1,177 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/tools/router"
+)
+
+// ErrorCode is a stable, machine readable identifier for a failed request.
+type ErrorCode string
+
+const (
+	CodeInvalidRequest  ErrorCode = "invalid_request"
+	CodeValidation      ErrorCode = "validation_failed"
+	CodeUnauthorized    ErrorCode = "unauthorized"
+	CodeForbidden       ErrorCode = "forbidden"
+	CodeNotFound        ErrorCode = "not_found"
+	CodeConflict        ErrorCode = "conflict"
+	CodeTooManyRequests ErrorCode = "too_many_requests"
+	CodeQuotaExceeded   ErrorCode = "quota_exceeded"
+	CodeInternal        ErrorCode = "internal_error"
+)
+
+// ErrorResponse is the JSON body returned by every failed custom route.
+type ErrorResponse struct {
+	Code    ErrorCode         `json:"code"`
+	Message string            `json:"message"`
+	Fields  map[string]string `json:"fields,omitempty"`
+	// Current is the server's copy of a record an update conflicted with.
+	Current any `json:"current,omitempty"`
+}
+
+type apiError struct {
+	status   int
+	response ErrorResponse
+	cause    error
+}
+
+func (e *apiError) Error() string {
+	if e.cause != nil {
+		return e.response.Message + ": " + e.cause.Error()
+	}
+
+	return e.response.Message
+}
+
+func (e *apiError) Unwrap() error {
+	return e.cause
+}
+
+func newAPIError(status int, code ErrorCode, message string, cause error) *apiError {
+	return &apiError{
+		status: status,
+		response: ErrorResponse{
+			Code:    code,
+			Message: message,
+		},
+		cause: cause,
+	}
+}
+
+func invalidRequest(message string, cause error) *apiError {
+	return newAPIError(http.StatusBadRequest, CodeInvalidRequest, message, cause)
+}
+
+func validationFailed(fields map[string]string, cause error) *apiError {
+	err := newAPIError(http.StatusBadRequest, CodeValidation, "One or more fields are invalid.", cause)
+	err.response.Fields = fields
+
+	return err
+}
+
+func unauthorized(message string, cause error) *apiError {
+	return newAPIError(http.StatusUnauthorized, CodeUnauthorized, message, cause)
+}
+
+func forbidden(message string, cause error) *apiError {
+	return newAPIError(http.StatusForbidden, CodeForbidden, message, cause)
+}
+
+func notFound(message string, cause error) *apiError {
+	return newAPIError(http.StatusNotFound, CodeNotFound, message, cause)
+}
+
+func conflict(message string, cause error) *apiError {
+	return newAPIError(http.StatusConflict, CodeConflict, message, cause)
+}
+
+// quotaExceeded reports an action that would exceed one of the configured
+// Quotas.
+func quotaExceeded(message string) *apiError {
+	return newAPIError(http.StatusForbidden, CodeQuotaExceeded, message, nil)
+}
+
+// tooManyRequests reports a request made too soon after a previous one. The
+// caller sets the Retry-After header.
+func tooManyRequests(message string, cause error) *apiError {
+	return newAPIError(http.StatusTooManyRequests, CodeTooManyRequests, message, cause)
+}
+
+// versionConflict reports an update based on an outdated version of a
+// record, returning the record's current state so the client can rebase.
+func versionConflict(message string, current any) *apiError {
+	err := conflict(message, nil)
+	err.response.Current = current
+
+	return err
+}
+
+func internalError(message string, cause error) *apiError {
+	return newAPIError(http.StatusInternalServerError, CodeInternal, message, cause)
+}
+
+// toAPIError converts any error returned from a handler or middleware into
+// an apiError, preserving the original error as its cause.
+func toAPIError(err error) *apiError {
+	var apiErr *apiError
+	if errors.As(err, &apiErr) {
+		return apiErr
+	}
+
+	if errors.Is(err, sql.ErrNoRows) {
+		return notFound("The requested resource wasn't found.", err)
+	}
+
+	var routerErr *router.ApiError
+	if errors.As(err, &routerErr) {
+		switch routerErr.Status {
+		case http.StatusBadRequest:
+			return invalidRequest(routerErr.Message, err)
+		case http.StatusUnauthorized:
+			return unauthorized(routerErr.Message, err)
+		case http.StatusForbidden:
+			return forbidden(routerErr.Message, err)
+		case http.StatusNotFound:
+			return notFound(routerErr.Message, err)
+		case http.StatusConflict:
+			return conflict(routerErr.Message, err)
+		case http.StatusTooManyRequests:
+			return tooManyRequests(routerErr.Message, err)
+		}
+	}
+
+	return internalError("Something went wrong while processing your request.", err)
+}
+
+// handleErrors writes any error returned further down the chain as an
+// ErrorResponse and logs its cause.
+func handleErrors(e *core.RequestEvent) error {
+	err := e.Next()
+	if err == nil {
+		return nil
+	}
+
+	apiErr := toAPIError(err)
+
+	attrs := []any{
+		"method", e.Request.Method,
+		"path", e.Request.URL.Path,
+		"status", apiErr.status,
+		"code", apiErr.response.Code,
+	}
+	if apiErr.cause != nil {
+		attrs = append(attrs, "error", apiErr.cause.Error())
+	}
+
+	if apiErr.status >= http.StatusInternalServerError {
+		e.App.Logger().Error(apiErr.response.Message, attrs...)
+	} else {
+		e.App.Logger().Debug(apiErr.response.Message, attrs...)
+	}
+
+	return e.JSON(apiErr.status, apiErr.response)
+}
@@ -0,0 +1,51 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/tools/security"
+)
+
+// jsonWithETag responds with the JSON encoding of data tagged with an ETag
+// of its content, or with 304 Not Modified if the request's If-None-Match
+// header already matches it.
+func jsonWithETag(e *core.RequestEvent, status int, data any) error {
+	body, err := json.Marshal(data)
+	if err != nil {
+		return internalError("Failed to encode response.", err)
+	}
+
+	return blobWithETag(e, status, body, `"`+security.SHA256(string(body))[:32]+`"`)
+}
+
+// blobWithETag responds with a JSON body and the given ETag, or with 304 Not
+// Modified if the request's If-None-Match header matches it.
+func blobWithETag(e *core.RequestEvent, status int, body []byte, etag string) error {
+	header := e.Response.Header()
+	header.Set("ETag", etag)
+	header.Set("Cache-Control", "no-cache")
+
+	if etagMatches(e.Request.Header.Get("If-None-Match"), etag) {
+		return e.NoContent(http.StatusNotModified)
+	}
+
+	return e.Blob(status, "application/json", body)
+}
+
+// etagMatches reports whether an If-None-Match header matches the etag,
+// using the weak comparison required for GET requests.
+func etagMatches(ifNoneMatch, etag string) bool {
+	etag = strings.TrimPrefix(etag, "W/")
+
+	for candidate := range strings.SplitSeq(ifNoneMatch, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == etag {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,375 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	validation "github.com/go-ozzo/ozzo-validation/v4"
+	"github.com/ian-shakespeare/tribe-tracker/server/internal/database"
+	"github.com/ian-shakespeare/tribe-tracker/server/pkg/models"
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/tools/filesystem"
+)
+
+type createFamilyRequest struct {
+	Name string `json:"name"`
+	Code string `json:"code"`
+}
+
+func (r *createFamilyRequest) normalize() {
+	r.Name = strings.TrimSpace(r.Name)
+	r.Code = strings.TrimSpace(r.Code)
+}
+
+func (r *createFamilyRequest) validate(app core.App) error {
+	return validation.ValidateStruct(r,
+		validation.Field(&r.Name, collectionField(app, "families", "name")),
+		validation.Field(&r.Code, collectionField(app, "families", "code")),
+	)
+}
+
+func createFamily(e *core.RequestEvent) error {
+	userId := e.Auth.Id
+
+	var req createFamilyRequest
+	if err := readBody(e, &req); err != nil {
+		return err
+	}
+
+	var (
+		family       models.Family
+		familyMember models.FamilyMember
+	)
+	err := e.App.RunInTransaction(func(txApp core.App) error {
+		// counted in the transaction, so concurrent requests can't both
+		// take the last slot
+		if err := checkFamiliesQuota(txApp, userId); err != nil {
+			return err
+		}
+
+		var err error
+
+		family, err = database.CreateFamily(txApp, userId, req.Name, req.Code)
+		if database.IsUniqueViolation(err) {
+			return conflict("A family with that code already exists.", err)
+		} else if err != nil {
+			return err
+		}
+
+		familyMember, err = database.CreateFamilyMember(txApp, family.ID, userId, models.RoleOwner)
+		if err != nil {
+			return internalError("Failed to join family.", err)
+		}
+
+		return audit(txApp, family.ID, userId, models.AuditFamilyCreated, "", map[string]any{
+			"name": family.Name,
+		})
+	})
+	if err != nil {
+		return fromSaveError(err, "Failed to create family.")
+	}
+
+	var res struct {
+		Family       models.Family       `json:"family"`
+		FamilyMember models.FamilyMember `json:"familyMember"`
+	}
+	res.Family = family
+	res.FamilyMember = familyMember
+
+	return e.JSON(http.StatusCreated, res)
+}
+
+type updateFamilyRequest struct {
+	Name             *string `json:"name"`
+	Description      *string `json:"description"`
+	Icon             *string `json:"icon"`
+	Color            *string `json:"color"`
+	SharingPrecision *string `json:"sharingPrecision"`
+	// Version is used when the If-Match header isn't sent.
+	Version *int `json:"version"`
+}
+
+func (r *updateFamilyRequest) normalize() {
+	for _, value := range []*string{r.Name, r.Description, r.Icon, r.Color, r.SharingPrecision} {
+		if value != nil {
+			*value = strings.TrimSpace(*value)
+		}
+	}
+}
+
+func (r *updateFamilyRequest) validate(app core.App) error {
+	return validation.ValidateStruct(r,
+		validation.Field(&r.Name, validation.NilOrNotEmpty, collectionField(app, "families", "name")),
+		validation.Field(&r.Description, collectionField(app, "families", "description")),
+		validation.Field(&r.Icon, collectionField(app, "families", "icon")),
+		validation.Field(&r.Color, collectionField(app, "families", "color")),
+		validation.Field(&r.SharingPrecision, validation.NilOrNotEmpty, collectionField(app, "families", "sharingPrecision")),
+	)
+}
+
+// updateFamily edits a family's settings. Only owners and admins may do so,
+// and the update must be based on the family's current version.
+func updateFamily(e *core.RequestEvent) error {
+	var req updateFamilyRequest
+	if err := readBody(e, &req); err != nil {
+		return err
+	}
+
+	version, err := baseVersion(e, req.Version)
+	if err != nil {
+		return err
+	}
+
+	var family models.Family
+	err = e.App.RunInTransaction(func(txApp core.App) error {
+		var err error
+		family, err = editFamily(txApp, e.Auth.Id, e.Request.PathValue("id"), version, func(family *models.Family) {
+			if req.Name != nil {
+				family.Name = *req.Name
+			}
+			if req.Description != nil {
+				family.Description = *req.Description
+			}
+			if req.Icon != nil {
+				family.Icon = *req.Icon
+			}
+			if req.Color != nil {
+				family.Color = *req.Color
+			}
+			if req.SharingPrecision != nil {
+				family.SharingPrecision = *req.SharingPrecision
+			}
+		})
+		return err
+	})
+	if err != nil {
+		return fromSaveError(err, "Failed to update family.")
+	}
+
+	e.Response.Header().Set("ETag", versionETag(family.Version))
+
+	return e.JSON(http.StatusOK, family)
+}
+
+// editFamily applies edit to the family on behalf of one of its owners or
+// admins, failing with a version conflict unless the edit is based on the
+// family's current version. Renames are audited.
+func editFamily(app core.App, userId, familyId string, version int, edit func(*models.Family)) (models.Family, error) {
+	if _, err := findMembership(app, familyId, userId, models.RoleOwner, models.RoleAdmin); err != nil {
+		return models.Family{}, err
+	}
+
+	family, err := database.GetFamily(app.DB(), familyId)
+	if errors.Is(err, sql.ErrNoRows) {
+		return family, notFound("Family not found.", err)
+	} else if err != nil {
+		return family, internalError("Failed to get family data.", err)
+	}
+
+	if family.Version != version {
+		return family, versionConflict("The family was changed by someone else.", family)
+	}
+
+	oldName := family.Name
+	edit(&family)
+
+	family, err = database.UpdateFamily(app, family)
+	if err != nil || family.Name == oldName {
+		return family, err
+	}
+
+	return family, audit(app, familyId, userId, models.AuditFamilyRenamed, "", map[string]any{
+		"from": oldName,
+		"to":   family.Name,
+	})
+}
+
+// putFamilyImage replaces the family's image with the multipart file in the
+// image field. The version is taken from If-Match or the version field.
+func putFamilyImage(e *core.RequestEvent) error {
+	familyId := e.Request.PathValue("id")
+
+	if _, err := findMembership(e.App, familyId, e.Auth.Id, models.RoleOwner, models.RoleAdmin); err != nil {
+		return err
+	}
+
+	files, err := e.FindUploadedFiles("image")
+	if errors.Is(err, http.ErrMissingFile) {
+		return validationFailed(map[string]string{"image": "Cannot be blank."}, err)
+	} else if err != nil {
+		return invalidRequest("Invalid multipart form.", err)
+	}
+
+	if len(files) > 1 {
+		return validationFailed(map[string]string{"image": "Only one image can be uploaded."}, nil)
+	}
+
+	var bodyVersion *int
+	if value := e.Request.FormValue("version"); value != "" {
+		version, err := strconv.Atoi(value)
+		if err != nil {
+			return validationFailed(map[string]string{"version": "Must be an integer."}, err)
+		}
+		bodyVersion = &version
+	}
+
+	version, err := baseVersion(e, bodyVersion)
+	if err != nil {
+		return err
+	}
+
+	return saveFamilyImage(e, familyId, version, files[0])
+}
+
+// deleteFamilyImage removes the family's image. The version is taken from
+// If-Match.
+func deleteFamilyImage(e *core.RequestEvent) error {
+	familyId := e.Request.PathValue("id")
+
+	if _, err := findMembership(e.App, familyId, e.Auth.Id, models.RoleOwner, models.RoleAdmin); err != nil {
+		return err
+	}
+
+	version, err := baseVersion(e, nil)
+	if err != nil {
+		return err
+	}
+
+	return saveFamilyImage(e, familyId, version, nil)
+}
+
+func saveFamilyImage(e *core.RequestEvent, familyId string, version int, image *filesystem.File) error {
+	var family models.Family
+	err := e.App.RunInTransaction(func(txApp core.App) error {
+		var err error
+
+		family, err = database.GetFamily(txApp.DB(), familyId)
+		if errors.Is(err, sql.ErrNoRows) {
+			return notFound("Family not found.", err)
+		} else if err != nil {
+			return internalError("Failed to get family data.", err)
+		}
+
+		if family.Version != version {
+			return versionConflict("The family was changed by someone else.", family)
+		}
+
+		family, err = database.SetFamilyImage(txApp, familyId, image)
+		return err
+	})
+	if err != nil {
+		return fromSaveError(err, "Failed to save family image.")
+	}
+
+	e.Response.Header().Set("ETag", versionETag(family.Version))
+
+	return e.JSON(http.StatusOK, family)
+}
+
+type memberDetail struct {
+	models.Member
+	LastLocation *models.Location `json:"lastLocation"`
+	// LastLocationAge is the number of seconds since LastLocation was recorded.
+	LastLocationAge *int64 `json:"lastLocationAge"`
+}
+
+func getFamily(e *core.RequestEvent) error {
+	userId := e.Auth.Id
+	familyId := e.Request.PathValue("id")
+
+	if _, err := findMembership(e.App, familyId, userId); err != nil {
+		return err
+	}
+
+	family, err := database.GetFamily(e.App.DB(), familyId)
+	if errors.Is(err, sql.ErrNoRows) {
+		return notFound("Family not found.", err)
+	} else if err != nil {
+		return internalError("Failed to get family data.", err)
+	}
+
+	members, err := database.GetMembers(e.App.DB(), familyId)
+	if err != nil {
+		return internalError("Failed to get member data.", err)
+	}
+
+	if err := setFileToken(e); err != nil {
+		return err
+	}
+
+	for i := range members {
+		resolveMemberAvatar(e.App, &members[i])
+	}
+
+	locations, err := database.GetLatestLocations(e.App.DB(), familyId, time.Time{})
+	if err != nil {
+		return internalError("Failed to get location data.", err)
+	}
+
+	precisions, err := database.GetSharingPrecisions(e.App.DB(), userId, []string{familyId})
+	if err != nil {
+		return internalError("Failed to get family data.", err)
+	}
+
+	if err := applyPrecision(locations, memberPrecision(userId, precisions)); err != nil {
+		return internalError("Failed to read location data.", err)
+	}
+
+	invitations, err := database.GetPendingInvitations(e.App.DB(), familyId)
+	if err != nil {
+		return internalError("Failed to get invitation data.", err)
+	}
+
+	latest := make(map[string]models.Location, len(locations))
+	for _, location := range locations {
+		latest[location.User] = location
+	}
+
+	details := make([]memberDetail, 0, len(members))
+	for _, member := range members {
+		detail := memberDetail{Member: member}
+		if location, ok := latest[member.User]; ok {
+			age := int64(time.Since(location.RecordedAt.Time()).Seconds())
+			detail.LastLocation = &location
+			detail.LastLocationAge = &age
+		}
+
+		details = append(details, detail)
+	}
+
+	var res struct {
+		Family             models.Family       `json:"family"`
+		Members            []memberDetail      `json:"members"`
+		PendingInvitations []models.Invitation `json:"pendingInvitations"`
+	}
+	res.Family = family
+	res.Members = details
+	res.PendingInvitations = invitations
+
+	// no ETag, the family version doesn't cover the members, locations and
+	// invitations in the body; updates take family.version instead
+	return e.JSON(http.StatusOK, res)
+}
+
+// findMembership returns the user's membership in the family. Families the
+// user doesn't belong to are reported as not found so their existence isn't
+// leaked. If roles are given, the membership must hold one of them.
+func findMembership(app core.App, familyId, userId string, roles ...string) (models.FamilyMember, error) {
+	familyMember, err := database.GetFamilyMember(app.DB(), familyId, userId)
+	if errors.Is(err, sql.ErrNoRows) {
+		return familyMember, notFound("Family not found.", err)
+	} else if err != nil {
+		return familyMember, internalError("Failed to get family member data.", err)
+	}
+
+	if len(roles) > 0 && !slices.Contains(roles, familyMember.Role) {
+		return familyMember, forbidden("Your role in this family doesn't allow this action.", nil)
+	}
+
+	return familyMember, nil
+}
@@ -0,0 +1,546 @@
+package handlers_test
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/png"
+	"mime/multipart"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ian-shakespeare/tribe-tracker/server/internal/database"
+	"github.com/ian-shakespeare/tribe-tracker/server/internal/handlers"
+	"github.com/pocketbase/pocketbase/tests"
+	"github.com/pocketbase/pocketbase/tools/filesystem"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCreateFamily(t *testing.T) {
+	token := generateToken(t, "users", "luke.skywalker@email.com")
+
+	path := "/mobile/families"
+	scenarios := []tests.ApiScenario{
+		{
+			Name:            "unauthorized",
+			Method:          http.MethodPost,
+			URL:             path,
+			Body:            strings.NewReader(`{"name":"Lars","code":"moisture-farm"}`),
+			ExpectedStatus:  http.StatusUnauthorized,
+			ExpectedContent: []string{`"code":"unauthorized"`},
+			TestAppFactory:  setupTestApp,
+		},
+		{
+			Name:   "malformed body",
+			Method: http.MethodPost,
+			URL:    path,
+			Body:   strings.NewReader(`{"name":`),
+			Headers: map[string]string{
+				"Authorization": token,
+			},
+			ExpectedStatus:  http.StatusBadRequest,
+			ExpectedContent: []string{`"code":"invalid_request"`},
+			TestAppFactory:  setupTestApp,
+		},
+		{
+			Name:   "unknown field",
+			Method: http.MethodPost,
+			URL:    path,
+			Body:   strings.NewReader(`{"name":"Lars","code":"moisture-farm","members":["pjrriu6noxafz76"]}`),
+			Headers: map[string]string{
+				"Authorization": token,
+			},
+			ExpectedStatus:  http.StatusBadRequest,
+			ExpectedContent: []string{`"code":"validation_failed"`, `"members":"Unknown field."`},
+			TestAppFactory:  setupTestApp,
+		},
+		{
+			Name:   "invalid fields",
+			Method: http.MethodPost,
+			URL:    path,
+			Body:   strings.NewReader(`{"name":"  L  ","code":"short"}`),
+			Headers: map[string]string{
+				"Authorization": token,
+			},
+			ExpectedStatus:  http.StatusBadRequest,
+			ExpectedContent: []string{`"code":"validation_failed"`, `"name":`, `"code":"Must be at least 8 character(s)"`},
+			TestAppFactory:  setupTestApp,
+		},
+		{
+			Name:   "duplicate code",
+			Method: http.MethodPost,
+			URL:    path,
+			Body:   strings.NewReader(`{"name":"Skywalkers","code":"some-code"}`),
+			Headers: map[string]string{
+				"Authorization": token,
+			},
+			ExpectedStatus:  http.StatusConflict,
+			ExpectedContent: []string{`"code":"conflict"`},
+			TestAppFactory:  setupTestApp,
+		},
+		{
+			Name:   "created",
+			Method: http.MethodPost,
+			URL:    path,
+			Body:   strings.NewReader(`{"name":"  Lars ","code":"moisture-farm"}`),
+			Headers: map[string]string{
+				"Authorization": token,
+			},
+			ExpectedStatus:  http.StatusCreated,
+			ExpectedContent: []string{`"family":{`, `"name":"Lars"`, `"familyMember":{`},
+			ExpectedEvents: map[string]int{
+				"OnRecordCreate":             3,
+				"OnRecordAfterCreateSuccess": 3,
+			},
+			TestAppFactory: setupTestApp,
+			AfterTestFunc: func(t testing.TB, app *tests.TestApp, res *http.Response) {
+				family, err := app.FindFirstRecordByData("families", "code", "moisture-farm")
+				require.NoError(t, err)
+				require.False(t, family.GetDateTime("createdAt").IsZero())
+				require.False(t, family.GetDateTime("updatedAt").IsZero())
+
+				member, err := app.FindFirstRecordByData("familyMembers", "family", family.Id)
+				require.NoError(t, err)
+				require.Equal(t, "owner", member.GetString("role"))
+			},
+		},
+	}
+
+	for _, scenario := range scenarios {
+		scenario.Test(t)
+	}
+}
+
+func TestGetFamily(t *testing.T) {
+	member := generateToken(t, "users", "leia.organa@email.com")
+	stranger := generateToken(t, "users", "darth.vader@email.com")
+
+	path := "/mobile/families/3re9axqzawl3esv"
+	scenarios := []tests.ApiScenario{
+		{
+			Name:            "unauthorized",
+			Method:          http.MethodGet,
+			URL:             path,
+			ExpectedStatus:  http.StatusUnauthorized,
+			ExpectedContent: []string{`"code":"unauthorized"`},
+			TestAppFactory:  setupTestApp,
+		},
+		{
+			Name:   "not a member",
+			Method: http.MethodGet,
+			URL:    path,
+			Headers: map[string]string{
+				"Authorization": stranger,
+			},
+			ExpectedStatus:     http.StatusNotFound,
+			ExpectedContent:    []string{`"code":"not_found"`},
+			NotExpectedContent: []string{`Skywalkers`},
+			TestAppFactory:     setupTestApp,
+		},
+		{
+			Name:   "missing family",
+			Method: http.MethodGet,
+			URL:    "/mobile/families/missing",
+			Headers: map[string]string{
+				"Authorization": member,
+			},
+			ExpectedStatus:  http.StatusNotFound,
+			ExpectedContent: []string{`"code":"not_found"`},
+			TestAppFactory:  setupTestApp,
+		},
+		{
+			Name:   "member",
+			Method: http.MethodGet,
+			URL:    path,
+			Headers: map[string]string{
+				"Authorization": member,
+			},
+			ExpectedStatus: http.StatusOK,
+			ExpectedContent: []string{
+				`"family":{"id":"3re9axqzawl3esv"`,
+				`"user":"pjrriu6noxafz76"`,
+				`"role":"owner"`,
+				`"user":"bcruhrwalqnwncy"`,
+				`"role":"member"`,
+				// avatars resolve against each member, not the caller
+				`"avatarUrl":"http://localhost:8090/api/files/users/pjrriu6noxafz76/luke_lii4ry6x0q.jpeg"`,
+				`"avatarThumbUrl":"http://localhost:8090/api/files/users/bcruhrwalqnwncy/leia_lj1hqkubfd.jpg?thumb=100x100"`,
+				`"lastLocation":{"id":"si098aybzuh2ko5"`,
+				`"lastLocationAge":`,
+				`"pendingInvitations":[{"id":"hnz94s5zj8essss"`,
+			},
+			NotExpectedContent: []string{
+				// soft deleted user
+				`"user":"zp17d7nbbm6dwrk"`,
+				// already accepted invitation
+				`"3x9bndtq78b4jgd"`,
+				`token=`,
+			},
+			TestAppFactory: setupTestApp,
+			AfterTestFunc: func(t testing.TB, app *tests.TestApp, res *http.Response) {
+				// the family version doesn't cover the members in the body
+				require.Empty(t, res.Header.Get("ETag"))
+				require.NotEmpty(t, res.Header.Get(handlers.FileTokenHeader))
+			},
+		},
+		{
+			Name:   "approximate precision",
+			Method: http.MethodGet,
+			URL:    path,
+			Headers: map[string]string{
+				"Authorization": member,
+			},
+			ExpectedStatus: http.StatusOK,
+			ExpectedContent: []string{
+				`{\"lon\":8.99,\"lat\":33.47}`,
+				// the caller's own location stays exact
+				`{\"lon\":9.008789,\"lat\":62.000905}`,
+			},
+			NotExpectedContent: []string{`8.986816`},
+			TestAppFactory: func(t testing.TB) *tests.TestApp {
+				app := setupTestApp(t)
+
+				family, err := app.FindRecordById("families", skywalkersId)
+				require.NoError(t, err)
+				family.Set("sharingPrecision", "approximate")
+				require.NoError(t, app.Save(family))
+
+				return app
+			},
+		},
+	}
+
+	for _, scenario := range scenarios {
+		scenario.Test(t)
+	}
+}
+
+func TestUpdateFamily(t *testing.T) {
+	luke := generateToken(t, "users", "luke.skywalker@email.com")
+	leia := generateToken(t, "users", "leia.organa@email.com")
+	vader := generateToken(t, "users", "darth.vader@email.com")
+
+	path := "/mobile/families/" + skywalkersId
+	scenarios := []tests.ApiScenario{
+		{
+			Name:            "unauthorized",
+			Method:          http.MethodPatch,
+			URL:             path,
+			Body:            strings.NewReader(`{"name":"Skywalker Clan","version":1}`),
+			ExpectedStatus:  http.StatusUnauthorized,
+			ExpectedContent: []string{`"code":"unauthorized"`},
+			TestAppFactory:  setupTestApp,
+		},
+		{
+			Name:   "non-member",
+			Method: http.MethodPatch,
+			URL:    path,
+			Body:   strings.NewReader(`{"name":"Galactic Empire","version":1}`),
+			Headers: map[string]string{
+				"Authorization": vader,
+			},
+			ExpectedStatus:  http.StatusNotFound,
+			ExpectedContent: []string{`"code":"not_found"`},
+			TestAppFactory:  setupTestApp,
+		},
+		{
+			Name:   "member without role",
+			Method: http.MethodPatch,
+			URL:    path,
+			Body:   strings.NewReader(`{"name":"Organas","version":1}`),
+			Headers: map[string]string{
+				"Authorization": leia,
+			},
+			ExpectedStatus:  http.StatusForbidden,
+			ExpectedContent: []string{`"code":"forbidden"`},
+			TestAppFactory:  setupTestApp,
+		},
+		{
+			Name:   "missing version",
+			Method: http.MethodPatch,
+			URL:    path,
+			Body:   strings.NewReader(`{"name":"Skywalker Clan"}`),
+			Headers: map[string]string{
+				"Authorization": luke,
+			},
+			ExpectedStatus:  http.StatusBadRequest,
+			ExpectedContent: []string{`"code":"validation_failed"`, `"version":`},
+			TestAppFactory:  setupTestApp,
+		},
+		{
+			Name:   "invalid fields",
+			Method: http.MethodPatch,
+			URL:    path,
+			Body:   strings.NewReader(`{"name":"","color":"blue","sharingPrecision":"street","version":1}`),
+			Headers: map[string]string{
+				"Authorization": luke,
+			},
+			ExpectedStatus:  http.StatusBadRequest,
+			ExpectedContent: []string{`"code":"validation_failed"`, `"name":`, `"color":`, `"sharingPrecision":`},
+			TestAppFactory:  setupTestApp,
+		},
+		{
+			Name:   "stale version",
+			Method: http.MethodPatch,
+			URL:    path,
+			Body:   strings.NewReader(`{"name":"Skywalker Clan"}`),
+			Headers: map[string]string{
+				"Authorization": luke,
+				"If-Match":      `"1"`,
+			},
+			ExpectedStatus:  http.StatusConflict,
+			ExpectedContent: []string{`"code":"conflict"`, `"current":{`, `"name":"Jedi Order"`, `"version":2`},
+			TestAppFactory: func(t testing.TB) *tests.TestApp {
+				app := setupTestApp(t)
+
+				family, err := app.FindRecordById("families", skywalkersId)
+				require.NoError(t, err)
+
+				family.Set("name", "Jedi Order")
+				require.NoError(t, app.Save(family))
+
+				return app
+			},
+		},
+		{
+			Name:   "updated with If-Match",
+			Method: http.MethodPatch,
+			URL:    path,
+			Body:   strings.NewReader(`{"name":" Skywalker Clan ","description":"Tatooine and beyond","icon":"🌅","color":"#FFAA00","sharingPrecision":"city"}`),
+			Headers: map[string]string{
+				"Authorization": luke,
+				"If-Match":      `"1"`,
+			},
+			ExpectedStatus: http.StatusOK,
+			ExpectedContent: []string{
+				`"name":"Skywalker Clan"`,
+				`"description":"Tatooine and beyond"`,
+				`"color":"#FFAA00"`,
+				`"sharingPrecision":"city"`,
+				`"version":2`,
+			},
+			TestAppFactory: setupTestApp,
+			AfterTestFunc: func(t testing.TB, app *tests.TestApp, res *http.Response) {
+				require.Equal(t, `"2"`, res.Header.Get("ETag"))
+
+				families, err := database.GetRecentFamilies(app.DB(), lukeId, nil, time.Now().Add(-time.Minute))
+				require.NoError(t, err)
+				require.Len(t, families, 1)
+				require.Equal(t, "Skywalker Clan", families[0].Name)
+			},
+		},
+		{
+			Name:   "updated with body version",
+			Method: http.MethodPatch,
+			URL:    path,
+			Body:   strings.NewReader(`{"description":"","version":1}`),
+			Headers: map[string]string{
+				"Authorization": luke,
+			},
+			ExpectedStatus:  http.StatusOK,
+			ExpectedContent: []string{`"description":""`, `"version":2`},
+			TestAppFactory:  setupTestApp,
+		},
+		{
+			Name:   "records API update",
+			Method: http.MethodPatch,
+			URL:    "/api/collections/families/records/" + skywalkersId,
+			Body:   strings.NewReader(`{"name":"Skywalker Clan"}`),
+			Headers: map[string]string{
+				"Authorization": luke,
+			},
+			ExpectedStatus:  http.StatusForbidden,
+			ExpectedContent: []string{`"status":403`},
+			TestAppFactory:  setupTestApp,
+		},
+	}
+
+	for _, scenario := range scenarios {
+		scenario.Test(t)
+	}
+}
+
+// testPNG returns a small PNG image.
+func testPNG(t testing.TB) []byte {
+	t.Helper()
+
+	img := image.NewRGBA(image.Rect(0, 0, 8, 8))
+	for x := range 8 {
+		for y := range 8 {
+			img.Set(x, y, color.RGBA{R: 255, G: uint8(x * 32), B: uint8(y * 32), A: 255})
+		}
+	}
+
+	var buf bytes.Buffer
+	require.NoError(t, png.Encode(&buf, img))
+
+	return buf.Bytes()
+}
+
+// multipartImage builds a multipart body with a small PNG in the given file
+// field plus any extra form fields.
+func multipartImage(t testing.TB, field, filename string, fields map[string]string) (*bytes.Buffer, string) {
+	t.Helper()
+
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+
+	for name, value := range fields {
+		require.NoError(t, writer.WriteField(name, value))
+	}
+
+	part, err := writer.CreateFormFile(field, filename)
+	require.NoError(t, err)
+	_, err = part.Write(testPNG(t))
+	require.NoError(t, err)
+	require.NoError(t, writer.Close())
+
+	return body, writer.FormDataContentType()
+}
+
+func TestFamilyImage(t *testing.T) {
+	luke := generateToken(t, "users", "luke.skywalker@email.com")
+	leia := generateToken(t, "users", "leia.organa@email.com")
+
+	// the family image starts at version 1 and is bumped by the upload
+	withImage := func(t testing.TB) *tests.TestApp {
+		app := setupTestApp(t)
+
+		family, err := app.FindRecordById("families", skywalkersId)
+		require.NoError(t, err)
+
+		file, err := filesystem.NewFileFromBytes(testPNG(t), "tatooine.png")
+		require.NoError(t, err)
+
+		family.Set("image", file)
+		require.NoError(t, app.Save(family))
+
+		return app
+	}
+
+	path := "/mobile/families/" + skywalkersId + "/image"
+
+	leiaBody, leiaType := multipartImage(t, "image", "organa.png", map[string]string{"version": "1"})
+	missingBody, missingType := multipartImage(t, "photo", "tatooine.png", map[string]string{"version": "1"})
+	staleBody, staleType := multipartImage(t, "image", "tatooine.png", nil)
+	uploadBody, uploadType := multipartImage(t, "image", "tatooine.png", map[string]string{"version": "1"})
+	heicBody, heicType := multipartFile(t, "image", "tatooine.heic", []byte("\x00\x00\x00\x18ftypheic\x00\x00\x00\x00mif1heic"))
+
+	textBody := &bytes.Buffer{}
+	textWriter := multipart.NewWriter(textBody)
+	textPart, err := textWriter.CreateFormFile("image", "notes.txt")
+	require.NoError(t, err)
+	_, err = textPart.Write([]byte("not an image"))
+	require.NoError(t, err)
+	require.NoError(t, textWriter.WriteField("version", "1"))
+	require.NoError(t, textWriter.Close())
+
+	scenarios := []tests.ApiScenario{
+		{
+			Name:   "member without role",
+			Method: http.MethodPut,
+			URL:    path,
+			Body:   leiaBody,
+			Headers: map[string]string{
+				"Authorization": leia,
+				"Content-Type":  leiaType,
+			},
+			ExpectedStatus:  http.StatusForbidden,
+			ExpectedContent: []string{`"code":"forbidden"`},
+			TestAppFactory:  setupTestApp,
+		},
+		{
+			Name:   "missing image",
+			Method: http.MethodPut,
+			URL:    path,
+			Body:   missingBody,
+			Headers: map[string]string{
+				"Authorization": luke,
+				"Content-Type":  missingType,
+			},
+			ExpectedStatus:  http.StatusBadRequest,
+			ExpectedContent: []string{`"code":"validation_failed"`, `"image":`},
+			TestAppFactory:  setupTestApp,
+		},
+		{
+			Name:   "not an image",
+			Method: http.MethodPut,
+			URL:    path,
+			Body:   textBody,
+			Headers: map[string]string{
+				"Authorization": luke,
+				"Content-Type":  textWriter.FormDataContentType(),
+			},
+			ExpectedStatus:  http.StatusBadRequest,
+			ExpectedContent: []string{`"code":"validation_failed"`, `"image":`},
+			TestAppFactory:  setupTestApp,
+		},
+		{
+			Name:   "unsupported format",
+			Method: http.MethodPut,
+			URL:    path,
+			Body:   heicBody,
+			Headers: map[string]string{
+				"Authorization": luke,
+				"Content-Type":  heicType,
+				"If-Match":      `"1"`,
+			},
+			ExpectedStatus:  http.StatusBadRequest,
+			ExpectedContent: []string{`"code":"validation_failed"`, `"image":`},
+			TestAppFactory:  setupTestApp,
+		},
+		{
+			Name:   "stale version",
+			Method: http.MethodPut,
+			URL:    path,
+			Body:   staleBody,
+			Headers: map[string]string{
+				"Authorization": luke,
+				"Content-Type":  staleType,
+				"If-Match":      `"1"`,
+			},
+			ExpectedStatus:  http.StatusConflict,
+			ExpectedContent: []string{`"code":"conflict"`, `"version":2`},
+			TestAppFactory:  withImage,
+		},
+		{
+			Name:   "uploaded",
+			Method: http.MethodPut,
+			URL:    path,
+			Body:   uploadBody,
+			Headers: map[string]string{
+				"Authorization": luke,
+				"Content-Type":  uploadType,
+			},
+			ExpectedStatus:  http.StatusOK,
+			ExpectedContent: []string{`"image":"tatooine_`, `"version":2`},
+			TestAppFactory:  setupTestApp,
+			AfterTestFunc: func(t testing.TB, app *tests.TestApp, res *http.Response) {
+				families, err := database.GetRecentFamilies(app.DB(), lukeId, nil, time.Now().Add(-time.Minute))
+				require.NoError(t, err)
+				require.Len(t, families, 1)
+				require.True(t, strings.HasPrefix(families[0].Image, "tatooine_"))
+				// converted to JPEG, which drops the photo's metadata
+				require.True(t, strings.HasSuffix(families[0].Image, ".jpg"), families[0].Image)
+			},
+		},
+		{
+			Name:   "deleted",
+			Method: http.MethodDelete,
+			URL:    path,
+			Headers: map[string]string{
+				"Authorization": luke,
+				"If-Match":      `"2"`,
+			},
+			ExpectedStatus:  http.StatusOK,
+			ExpectedContent: []string{`"image":""`, `"version":3`},
+			TestAppFactory:  withImage,
+		},
+	}
+
+	for _, scenario := range scenarios {
+		scenario.Test(t)
+	}
+}
@@ -0,0 +1,193 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"io"
+	"net/url"
+	"strings"
+
+	validation "github.com/go-ozzo/ozzo-validation/v4"
+	"github.com/ian-shakespeare/tribe-tracker/server/internal/database"
+	"github.com/ian-shakespeare/tribe-tracker/server/internal/images"
+	"github.com/ian-shakespeare/tribe-tracker/server/pkg/models"
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/tools/filesystem"
+)
+
+// avatarThumb is the thumbnail size served for avatars in lists and on maps.
+const avatarThumb = "100x100"
+
+// avatarMaxDimension caps the width and height of stored avatars.
+const avatarMaxDimension = 1024
+
+// familyImageMaxDimension caps the width and height of stored family images.
+const familyImageMaxDimension = 1024
+
+// fileURL returns the absolute URL of a record file, or of one of its
+// thumbnails if thumb is set. An empty filename results in an empty URL.
+func fileURL(app core.App, collection, recordId, filename, thumb string) string {
+	if filename == "" {
+		return ""
+	}
+
+	u := strings.TrimRight(app.Settings().Meta.AppURL, "/") +
+		"/api/files/" + url.PathEscape(collection) +
+		"/" + url.PathEscape(recordId) +
+		"/" + url.PathEscape(filename)
+
+	if thumb != "" {
+		u += "?" + url.Values{"thumb": {thumb}}.Encode()
+	}
+
+	return u
+}
+
+// setFileToken sends the caller a file token for the protected file URLs of
+// the response. Tokens go in a header rather than in the URLs so that
+// cached responses and the records clients store never carry an expired
+// one; clients append the latest token they received to the URLs.
+func setFileToken(e *core.RequestEvent) error {
+	token, err := e.Auth.NewFileToken()
+	if err != nil {
+		return internalError("Failed to create file token.", err)
+	}
+
+	e.Response.Header().Set(FileTokenHeader, token)
+
+	return nil
+}
+
+// resolveUserAvatar fills in the avatar URLs of a user returned to a client.
+// Avatars are protected, so the URLs need the token sent by setFileToken.
+func resolveUserAvatar(app core.App, user *models.User) {
+	user.AvatarURL = fileURL(app, "users", user.ID, user.Avatar, "")
+	user.AvatarThumbURL = fileURL(app, "users", user.ID, user.Avatar, avatarThumb)
+}
+
+// resolveMemberAvatar fills in the avatar URLs of a family member returned
+// to a client.
+func resolveMemberAvatar(app core.App, member *models.Member) {
+	member.AvatarURL = fileURL(app, "users", member.User, member.Avatar, "")
+	member.AvatarThumbURL = fileURL(app, "users", member.User, member.Avatar, avatarThumb)
+}
+
+// displayAvatarURL returns the URL a display fetches a member's avatar
+// thumbnail from. Displays have no user to issue file tokens for, so they
+// authenticate with their display token instead.
+func displayAvatarURL(app core.App, familyId string, member models.Member) string {
+	if member.Avatar == "" {
+		return ""
+	}
+
+	return strings.TrimRight(app.Settings().Meta.AppURL, "/") +
+		"/display/families/" + url.PathEscape(familyId) +
+		"/members/" + url.PathEscape(member.User) + "/avatar"
+}
+
+// getMemberAvatar serves the avatar thumbnail of a family member to a
+// display.
+func getMemberAvatar(e *core.RequestEvent) error {
+	token, _ := e.Get(displayTokenKey).(models.DisplayToken)
+	userId := e.Request.PathValue("userId")
+
+	if _, err := database.GetFamilyMember(e.App.DB(), token.Family, userId); errors.Is(err, sql.ErrNoRows) {
+		return notFound("Member not found.", err)
+	} else if err != nil {
+		return internalError("Failed to get family member data.", err)
+	}
+
+	user, err := e.App.FindRecordById("users", userId)
+	if err != nil {
+		return notFound("Member not found.", err)
+	}
+
+	avatar := user.GetString("avatar")
+	if avatar == "" {
+		return notFound("The member has no avatar.", nil)
+	}
+
+	fsys, err := e.App.NewFilesystem()
+	if err != nil {
+		return internalError("Failed to open file storage.", err)
+	}
+	defer fsys.Close()
+
+	// same layout as the thumbnails created by the files API
+	original := user.BaseFilesPath() + "/" + avatar
+	thumb := user.BaseFilesPath() + "/thumbs_" + avatar + "/" + avatarThumb + "_" + avatar
+
+	servedPath := thumb
+	if exists, _ := fsys.Exists(thumb); !exists {
+		if err := fsys.CreateThumb(original, thumb, avatarThumb); err != nil {
+			// formats that can't be resized (e.g. svg) are served as is
+			servedPath = original
+		}
+	}
+
+	e.Response.Header().Set("Cache-Control", "private, max-age=3600")
+
+	if err := fsys.Serve(e.Response, e.Request, servedPath, avatar); err != nil {
+		return notFound("The member has no avatar.", err)
+	}
+
+	return nil
+}
+
+// normalizeAvatar converts uploaded avatars to JPEG before they are stored,
+// so every client can render them and no camera metadata, such as the GPS
+// position of a photo, is ever served to other members.
+func normalizeAvatar(e *core.RecordEvent) error {
+	for _, file := range e.Record.GetUnsavedFiles("avatar") {
+		if err := normalizeImage(file, avatarMaxDimension); err != nil {
+			return validation.Errors{"avatar": err}
+		}
+	}
+
+	return e.Next()
+}
+
+// normalizeFamilyImage converts uploaded family images to JPEG, for the same
+// reasons as normalizeAvatar.
+func normalizeFamilyImage(e *core.RecordEvent) error {
+	for _, file := range e.Record.GetUnsavedFiles("image") {
+		if err := normalizeImage(file, familyImageMaxDimension); err != nil {
+			return validation.Errors{"image": err}
+		}
+	}
+
+	return e.Next()
+}
+
+// normalizeImage replaces the content of an uploaded raster image with its
+// normalized JPEG encoding. SVG images are left untouched.
+func normalizeImage(file *filesystem.File, maxDimension int) error {
+	r, err := file.Reader.Open()
+	if err != nil {
+		return validation.NewError("validation_invalid_image", "Failed to read the image.")
+	}
+	defer r.Close()
+
+	if vector, err := images.IsVector(r); err != nil || vector {
+		return nil
+	}
+
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return validation.NewError("validation_invalid_image", "Failed to read the image.")
+	}
+
+	normalized, err := images.Normalize(r, maxDimension)
+	if errors.Is(err, images.ErrUnsupported) {
+		return validation.NewError("validation_unsupported_image", "Unsupported image format. Upload a JPEG, PNG, GIF or WebP image.")
+	} else if errors.Is(err, images.ErrTooLarge) {
+		return validation.NewError("validation_image_too_large", "The image is too large.")
+	} else if err != nil {
+		return validation.NewError("validation_invalid_image", "The image is invalid or corrupted.")
+	}
+
+	file.Reader = &filesystem.BytesReader{Bytes: normalized}
+	file.Size = int64(len(normalized))
+	file.Name = images.JPEGName(file.Name)
+
+	return nil
+}
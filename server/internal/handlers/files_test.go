@@ -0,0 +1,93 @@
+package handlers_test
+
+import (
+	"bytes"
+	"image"
+	"mime/multipart"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/pocketbase/pocketbase/tests"
+	"github.com/stretchr/testify/require"
+)
+
+// multipartFile builds a multipart body with the given content in a file
+// field.
+func multipartFile(t testing.TB, field, filename string, content []byte) (*bytes.Buffer, string) {
+	t.Helper()
+
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+
+	part, err := writer.CreateFormFile(field, filename)
+	require.NoError(t, err)
+	_, err = part.Write(content)
+	require.NoError(t, err)
+	require.NoError(t, writer.Close())
+
+	return body, writer.FormDataContentType()
+}
+
+func TestNormalizeAvatar(t *testing.T) {
+	luke := generateToken(t, "users", "luke.skywalker@email.com")
+
+	path := "/api/collections/users/records/" + lukeId
+
+	png, pngType := multipartFile(t, "avatar", "tatooine.png", testPNG(t))
+	heic, heicType := multipartFile(t, "avatar", "tatooine.heic", []byte("\x00\x00\x00\x18ftypheic\x00\x00\x00\x00mif1heic"))
+
+	scenarios := []tests.ApiScenario{
+		{
+			Name:   "converted to jpeg",
+			Method: http.MethodPatch,
+			URL:    path,
+			Body:   png,
+			Headers: map[string]string{
+				"Authorization": luke,
+				"Content-Type":  pngType,
+			},
+			ExpectedStatus:  http.StatusOK,
+			ExpectedContent: []string{`"avatar":"tatooine_`},
+			ExpectedEvents:  map[string]int{"OnRecordUpdate": 1},
+			TestAppFactory:  setupTestApp,
+			AfterTestFunc: func(t testing.TB, app *tests.TestApp, res *http.Response) {
+				user, err := app.FindRecordById("users", lukeId)
+				require.NoError(t, err)
+
+				avatar := user.GetString("avatar")
+				require.True(t, strings.HasSuffix(avatar, ".jpg"), avatar)
+
+				fsys, err := app.NewFilesystem()
+				require.NoError(t, err)
+				defer fsys.Close()
+
+				r, err := fsys.GetReader(user.BaseFilesPath() + "/" + avatar)
+				require.NoError(t, err)
+				defer r.Close()
+
+				_, format, err := image.DecodeConfig(r)
+				require.NoError(t, err)
+				require.Equal(t, "jpeg", format)
+			},
+		},
+		{
+			Name:   "unsupported format",
+			Method: http.MethodPatch,
+			URL:    path,
+			Body:   heic,
+			Headers: map[string]string{
+				"Authorization": luke,
+				"Content-Type":  heicType,
+			},
+			ExpectedStatus:  http.StatusBadRequest,
+			ExpectedContent: []string{`"avatar":{"code":"validation_unsupported_image"`},
+			ExpectedEvents:  map[string]int{"OnRecordUpdate": 1},
+			TestAppFactory:  setupTestApp,
+		},
+	}
+
+	for _, scenario := range scenarios {
+		scenario.Test(t)
+	}
+}
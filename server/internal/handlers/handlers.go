@@ -1,108 +1,108 @@
 package handlers
 
 import (
-	"encoding/json"
-	"net/http"
-	"time"
-
-	"github.com/ian-shakespeare/tribe-tracker/server/internal/database"
-	"github.com/ian-shakespeare/tribe-tracker/server/pkg/models"
+	"github.com/ian-shakespeare/tribe-tracker/server/internal/config"
 	"github.com/pocketbase/pocketbase/apis"
 	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/tools/hook"
 )
 
-func Bind(app core.App) {
-	app.OnServe().BindFunc(func(se *core.ServeEvent) error {
-		mobile := se.Router.Group("/mobile")
-
-		mobile.Bind(apis.RequireAuth())
-		mobile.GET("/sync", getSyncData)
-		mobile.POST("/families", createFamily)
-
-		return se.Next()
-	})
-}
-
-func getSyncData(e *core.RequestEvent) error {
-	userId := e.Auth.Id
-
-	params := e.Request.URL.Query()
-	afterStr := params.Get("after")
-	after, err := time.Parse(time.RFC3339, afterStr)
-	if err != nil {
-		return e.String(http.StatusBadRequest, "Invalid time. Expected RFC3339 format.")
-	}
-
-	users, err := database.GetRecentUsers(e.App.DB(), userId, after)
-	if err != nil {
-		message := "Failed to get user data."
-		return e.String(http.StatusInternalServerError, message)
-	}
-
-	families, err := database.GetRecentFamilies(e.App.DB(), userId, after)
-	if err != nil {
-		message := "Failed to get family data."
-		return e.String(http.StatusInternalServerError, message)
-	}
-
-	familyMembers, err := database.GetRecentFamilyMembers(e.App.DB(), userId, after)
-	if err != nil {
-		message := "Failed to get family member data."
-		return e.String(http.StatusInternalServerError, message)
-	}
+// configStoreKey is the app store key holding the config passed to Bind.
+const configStoreKey = "tribeTrackerConfig"
 
-	locations, err := database.GetRecentLocations(e.App.DB(), userId, after)
-	if err != nil {
-		message := "Failed to get location data."
-		return e.String(http.StatusInternalServerError, message)
+// appConfig returns the config the app was bound with.
+func appConfig(app core.App) config.Config {
+	cfg, ok := app.Store().Get(configStoreKey).(config.Config)
+	if !ok {
+		return config.Default()
 	}
 
-	var res struct {
-		Users         []models.User         `json:"users"`
-		Families      []models.Family       `json:"families"`
-		FamilyMembers []models.FamilyMember `json:"familyMembers"`
-		Locations     []models.Location     `json:"locations"`
-	}
-	res.Users = users
-	res.Families = families
-	res.FamilyMembers = familyMembers
-	res.Locations = locations
-
-	return e.JSON(http.StatusOK, res)
+	return cfg
 }
 
-func createFamily(e *core.RequestEvent) error {
-	userId := e.Auth.Id
+func Bind(app core.App, cfg config.Config) {
+	app.Store().Set(configStoreKey, cfg)
 
-	body := e.Request.Body
-	defer body.Close()
+	limiter := limitRequests(cfg.RateLimits)
 
-	var req struct {
-		Name string `json:"name"`
-		Code string `json:"code"`
-	}
-	if err := json.NewDecoder(body).Decode(&req); err != nil {
-		return e.String(http.StatusBadRequest, "Invalid request body.")
-	}
+	app.OnServe().BindFunc(func(se *core.ServeEvent) error {
+		mobile := se.Router.Group("/mobile")
 
-	family, err := database.CreateFamily(e.App.DB(), userId, req.Name, req.Code)
-	if err != nil {
-		message := "Failed to create family."
-		return e.String(http.StatusInternalServerError, message)
-	}
+		mobile.BindFunc(handleErrors)
+		mobile.Bind(apis.RequireAuth())
+		mobile.BindFunc(limiter)
+		mobile.Bind(&hook.Handler[*core.RequestEvent]{Id: trackDeviceId, Func: trackDevice})
+		mobile.GET("/sync", getSyncData).Bind(apis.GzipWithConfig(apis.GzipConfig{MinLength: syncGzipMinLength}))
+		mobile.POST("/sync/push", pushSyncData)
+		mobile.POST("/families", createFamily)
+		mobile.GET("/families/{id}", getFamily)
+		mobile.PATCH("/families/{id}", updateFamily)
+		mobile.PUT("/families/{id}/image", putFamilyImage)
+		mobile.DELETE("/families/{id}/image", deleteFamilyImage)
+		mobile.GET("/families/{id}/audit-events", listAuditEvents)
+		mobile.POST("/families/{id}/invitations", createInvitation)
+		mobile.DELETE("/families/{id}/invitations/{invitationId}", revokeInvitation)
+		mobile.POST("/families/{id}/invitations/{invitationId}/resend", resendInvitation)
+		mobile.POST("/families/{id}/join-links", createJoinLink)
+		mobile.POST("/families/join", joinFamily)
+		mobile.POST("/invitations/redeem", redeemInvitation)
+		mobile.POST("/invitations/{id}/accept", acceptInvitation)
+		mobile.POST("/invitations/{id}/decline", declineInvitation)
+		mobile.GET("/families/{id}/display-tokens", listDisplayTokens)
+		mobile.POST("/families/{id}/display-tokens", createDisplayToken)
+		mobile.DELETE("/families/{id}/display-tokens/{tokenId}", revokeDisplayToken)
+		mobile.GET("/devices", listDevices)
+		// new devices register before they have an id to send
+		mobile.POST("/devices", registerDevice).Unbind(trackDeviceId)
+		mobile.PATCH("/devices/{id}", updateDevice)
+		mobile.DELETE("/devices/{id}", revokeDevice)
+
+		se.Router.GET("/join/{token}", getJoinPage)
+		se.Router.GET("/invitations/{token}", getInvitationPage)
+
+		display := se.Router.Group("/display")
+
+		display.BindFunc(handleErrors)
+		// displays aren't rate limited: they poll on a fixed schedule and
+		// are already scoped to a single family
+		display.BindFunc(requireDisplayToken)
+		display.GET("/families/{id}/snapshot", getFamilySnapshot)
+		display.GET("/families/{id}/stream", streamFamily)
+		display.GET("/families/{id}/members/{userId}/avatar", getMemberAvatar)
 
-	familyMember, err := database.CreateFamilyMember(e.App.DB(), family.ID, userId)
-	if err != nil {
-		message := "Failed to join family."
-		return e.String(http.StatusInternalServerError, message)
-	}
+		return se.Next()
+	})
 
-	var res struct {
-		Family       models.Family       `json:"family"`
-		FamilyMember models.FamilyMember `json:"familyMember"`
-	}
-	res.Family = family
-	res.FamilyMember = familyMember
+	app.OnRecordsListRequest().BindFunc(trackRecordsListDevice)
+	app.OnRecordViewRequest().BindFunc(trackRecordDevice)
+	app.OnRecordCreateRequest().BindFunc(trackRecordDevice)
+	app.OnRecordUpdateRequest().BindFunc(trackRecordDevice)
+	app.OnRecordDeleteRequest().BindFunc(trackRecordDevice)
+	app.OnRecordCreateRequest("locations").BindFunc(tagLocationDevice)
+	app.OnRecordCreateRequest("locations").BindFunc(limitLocationRate)
+	app.OnRecordCreateRequest("locations").BindFunc(checkLocationRecordedAt)
+	app.Cron().MustAdd("expireInvitations", invitationExpirySchedule, func() {
+		expireInvitations(app)
+	})
+	app.Cron().MustAdd("pruneLocations", locationPruneSchedule, func() {
+		pruneLocations(app)
+	})
 
-	return e.JSON(http.StatusCreated, res)
+	app.OnRecordCreateRequest("families").BindFunc(auditFamilyCreateRequest)
+	app.OnRecordUpdateRequest("families").BindFunc(auditFamilyUpdateRequest)
+	app.OnRecordDeleteRequest("families").BindFunc(auditFamilyDeleteRequest)
+	app.OnRecordCreateRequest("familyMembers").BindFunc(auditMemberCreateRequest)
+	app.OnRecordUpdateRequest("familyMembers").BindFunc(auditMemberUpdateRequest)
+	app.OnRecordDeleteRequest("familyMembers").BindFunc(auditMemberDeleteRequest)
+
+	app.OnRecordAfterCreateSuccess("users").BindFunc(addressEmailInvitations)
+	app.OnRecordAfterUpdateSuccess("users").BindFunc(addressEmailInvitations)
+	app.OnRecordCreate("users").BindFunc(normalizeAvatar)
+	app.OnRecordUpdate("users").BindFunc(normalizeAvatar)
+	app.OnRecordCreate("families").BindFunc(normalizeFamilyImage)
+	app.OnRecordUpdate("families").BindFunc(normalizeFamilyImage)
+	app.OnRecordCreate("locations").BindFunc(defaultRecordedAt)
+	app.OnRecordDelete("familyMembers").BindFunc(recordDeletedFamilyMember)
+	app.OnRecordCreate(versionedCollections...).BindFunc(initVersion)
+	app.OnRecordUpdate(versionedCollections...).BindFunc(bumpVersion)
 }
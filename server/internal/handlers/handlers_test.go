@@ -1,14 +1,16 @@
 package handlers_test
 
 import (
-	"io"
-	"net/http"
-	"net/url"
 	"testing"
 
+	"github.com/ian-shakespeare/tribe-tracker/server/internal/config"
 	"github.com/ian-shakespeare/tribe-tracker/server/internal/handlers"
+	"github.com/pocketbase/pocketbase/core"
 	"github.com/pocketbase/pocketbase/tests"
+	"github.com/pocketbase/pocketbase/tools/types"
 	"github.com/stretchr/testify/require"
+
+	_ "github.com/ian-shakespeare/tribe-tracker/server/migrations"
 )
 
 const testDataDir = "../../testdata"
@@ -29,69 +31,39 @@ func generateToken(t *testing.T, collection, email string) string {
 	return token
 }
 
-func TestGetSyncData(t *testing.T) {
-	token := generateToken(t, "users", "luke.skywalker@email.com")
+func setupTestApp(t testing.TB) *tests.TestApp {
+	testApp, err := tests.NewTestApp(testDataDir)
+	require.NoError(t, err)
 
-	setupTestApp := func(t testing.TB) *tests.TestApp {
-		testApp, err := tests.NewTestApp(testDataDir)
-		require.NoError(t, err)
+	handlers.Bind(testApp, config.Default())
 
-		handlers.Bind(testApp)
+	return testApp
+}
 
-		return testApp
-	}
+// seedRecords saves a record of the collection for each set of fields.
+// Autodate fields such as createdAt can be set too, to seed records at fixed
+// times.
+func seedRecords(t testing.TB, app core.App, collection string, seed ...map[string]any) {
+	t.Helper()
 
-	path := "/mobile/sync"
-	scenarios := []tests.ApiScenario{
-		{
-			Name:               "unauthorzed",
-			Method:             http.MethodGet,
-			URL:                path,
-			ExpectedStatus:     http.StatusUnauthorized,
-			NotExpectedContent: []string{`users`, `families`, `locations`},
-			TestAppFactory:     setupTestApp,
-		},
-		{
-			Name:   "invalid http method",
-			Method: http.MethodPost,
-			URL:    path,
-			Headers: map[string]string{
-				"Authorization": token,
-			},
-			ExpectedStatus:     http.StatusNotFound,
-			NotExpectedContent: []string{"users", "families", "locations"},
-			TestAppFactory:     setupTestApp,
-		},
-		{
-			Name:   "first sync",
-			Method: http.MethodGet,
-			URL:    path + "?after=" + url.QueryEscape("1970-01-01T00:00:00.000Z"),
-			Headers: map[string]string{
-				"Authorization": token,
-			},
-			ExpectedStatus:     http.StatusOK,
-			ExpectedContent:    []string{"users", "families", "locations"},
-			NotExpectedContent: []string{`users":[]`, `families":[]`, `locations":[]`},
-			TestAppFactory:     setupTestApp,
-			AfterTestFunc: func(t testing.TB, app *tests.TestApp, res *http.Response) {
-				b, _ := io.ReadAll(res.Body)
-				t.Logf("response: %s", string(b))
-			},
-		},
-		{
-			Name:   "up to date sync",
-			Method: http.MethodGet,
-			URL:    path + "?after=" + url.QueryEscape("2026-02-01T17:51:44.784Z"),
-			Headers: map[string]string{
-				"Authorization": token,
-			},
-			ExpectedStatus:  http.StatusOK,
-			ExpectedContent: []string{`users":[]`, `families":[]`, `locations":[]`},
-			TestAppFactory:  setupTestApp,
-		},
-	}
+	c, err := app.FindCollectionByNameOrId(collection)
+	require.NoError(t, err)
+
+	for _, fields := range seed {
+		record := core.NewRecord(c)
+		for key, value := range fields {
+			// autodate fields ignore Set
+			if _, ok := c.Fields.GetByName(key).(*core.AutodateField); ok {
+				date, err := types.ParseDateTime(value)
+				require.NoError(t, err)
+
+				record.SetRaw(key, date)
+				continue
+			}
+
+			record.Set(key, value)
+		}
 
-	for _, scenario := range scenarios {
-		scenario.Test(t)
+		require.NoError(t, app.Save(record))
 	}
 }
@@ -0,0 +1,583 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"html"
+	"net/http"
+	"net/mail"
+	"net/url"
+	"strings"
+	"time"
+
+	validation "github.com/go-ozzo/ozzo-validation/v4"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/ian-shakespeare/tribe-tracker/server/internal/database"
+	"github.com/ian-shakespeare/tribe-tracker/server/pkg/models"
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/tools/mailer"
+	"github.com/pocketbase/pocketbase/tools/security"
+	"github.com/pocketbase/pocketbase/tools/types"
+)
+
+// invitationTokenType tells invitation tokens apart from the other tokens
+// signed with the same key.
+const invitationTokenType = "invitation"
+
+// invitationTTL is how long an invitation, and the link mailed with an email
+// invitation, stays valid after being sent.
+const invitationTTL = 14 * 24 * time.Hour
+
+// invitationResendCooldown is the minimum time between two sends of the same
+// invitation.
+const invitationResendCooldown = time.Hour
+
+// invitationExpirySchedule is the cron schedule of the job expiring stale
+// invitations.
+const invitationExpirySchedule = "*/15 * * * *"
+
+// invitationExpiredHint is shown on the landing page of mailed invitations
+// that can no longer be redeemed.
+const invitationExpiredHint = "Ask the person who invited you to send it again."
+
+type createInvitationRequest struct {
+	// Recipient is the id of the invited user. Email is used instead to
+	// invite someone by address, whether they have an account or not.
+	Recipient string `json:"recipient"`
+	Email     string `json:"email"`
+}
+
+func (r *createInvitationRequest) normalize() {
+	r.Recipient = strings.TrimSpace(r.Recipient)
+	r.Email = strings.ToLower(strings.TrimSpace(r.Email))
+}
+
+func (r *createInvitationRequest) validate(app core.App) error {
+	return validation.ValidateStruct(r,
+		validation.Field(&r.Recipient, validation.When(r.Email == "", validation.Required.Error("A recipient or an email is required."))),
+		validation.Field(&r.Email, validation.When(r.Recipient != "", validation.Empty.Error("Only one of recipient and email can be set.")), collectionField(app, "invitations", "email")),
+	)
+}
+
+type redeemInvitationRequest struct {
+	Token string `json:"token"`
+}
+
+func (r *redeemInvitationRequest) normalize() {
+	r.Token = strings.TrimSpace(r.Token)
+}
+
+func (r *redeemInvitationRequest) validate(app core.App) error {
+	return validation.ValidateStruct(r,
+		validation.Field(&r.Token, validation.Required),
+	)
+}
+
+// createInvitation invites a user to the family. Invitations to an email
+// address without an account are mailed with a signed link, and are turned
+// into regular invitations when someone signs up with that address.
+func createInvitation(e *core.RequestEvent) error {
+	userId := e.Auth.Id
+	familyId := e.Request.PathValue("id")
+
+	var req createInvitationRequest
+	if err := readBody(e, &req); err != nil {
+		return err
+	}
+
+	if _, err := findMembership(e.App, familyId, userId); err != nil {
+		return err
+	}
+
+	now := time.Now()
+	invitation := models.Invitation{
+		Sender:    userId,
+		Recipient: req.Recipient,
+		Family:    familyId,
+		Status:    models.InvitationPending,
+		ExpiresAt: toDateTime(now.Add(invitationTTL)),
+		SentAt:    toDateTime(now),
+	}
+
+	if req.Email != "" {
+		recipient, err := e.App.FindAuthRecordByEmail("users", req.Email)
+		if err == nil {
+			invitation.Recipient = recipient.Id
+		} else if errors.Is(err, sql.ErrNoRows) {
+			invitation.Email = req.Email
+		} else {
+			return internalError("Failed to get user data.", err)
+		}
+	}
+
+	if invitation.Recipient != "" {
+		_, err := database.GetFamilyMember(e.App.DB(), familyId, invitation.Recipient)
+		if err == nil {
+			return conflict("The user is already a member of this family.", nil)
+		} else if !errors.Is(err, sql.ErrNoRows) {
+			return internalError("Failed to get family member data.", err)
+		}
+	}
+
+	err := e.App.RunInTransaction(func(txApp core.App) error {
+		if err := checkMembersQuota(txApp, familyId); err != nil {
+			return err
+		}
+
+		var err error
+
+		invitation, err = database.CreateInvitation(txApp, invitation)
+		if database.IsUniqueViolation(err) {
+			return conflict("A pending invitation to this family was already sent to them.", err)
+		} else if err != nil {
+			return err
+		}
+
+		if invitation.Email != "" {
+			return nil
+		}
+
+		return audit(txApp, familyId, userId, models.AuditMemberInvited, invitation.Recipient, map[string]any{
+			"invitation": invitation.ID,
+		})
+	})
+	if err != nil {
+		return fromSaveError(err, "Failed to create invitation.")
+	}
+
+	if invitation.Email == "" {
+		return e.JSON(http.StatusCreated, invitation)
+	}
+
+	// the mail is only sent once the invitation is saved, and the invitation
+	// is deleted again if it can't be delivered so it can be sent anew. It is
+	// only audited once delivered, so no audit event ever has to be removed.
+	if err := sendInvitationMail(e.App, invitation, e.Auth); err != nil {
+		if discardErr := database.DeleteInvitation(e.App, invitation.ID); discardErr != nil {
+			e.App.Logger().Error("Failed to delete undelivered invitation", "id", invitation.ID, "error", discardErr)
+		}
+
+		return internalError("Failed to send the invitation email.", err)
+	}
+
+	err = audit(e.App, familyId, userId, models.AuditMemberInvited, "", map[string]any{
+		"invitation": invitation.ID,
+		"email":      invitation.Email,
+	})
+	if err != nil {
+		e.App.Logger().Error("Failed to audit delivered invitation", "id", invitation.ID, "error", err)
+	}
+
+	return e.JSON(http.StatusCreated, invitation)
+}
+
+// redeemInvitation adds the user to the family of a mailed invitation. The
+// link proves the user can read mail sent to the invited address, so no
+// further acceptance is needed. The address is only checked against the
+// user's own, which proves nothing until the user verified it, so unverified
+// users can't redeem invitations.
+func redeemInvitation(e *core.RequestEvent) error {
+	userId := e.Auth.Id
+
+	var req redeemInvitationRequest
+	if err := readBody(e, &req); err != nil {
+		return err
+	}
+
+	if err := requireVerified(e.Auth); err != nil {
+		return err
+	}
+
+	invitationId, email, err := parseInvitationToken(e.App, req.Token)
+	if err != nil {
+		return validationFailed(map[string]string{"token": "The invitation link is invalid or expired."}, err)
+	}
+
+	if !strings.EqualFold(email, e.Auth.Email()) {
+		return forbidden("The invitation was sent to another email address.", nil)
+	}
+
+	invitation, err := findInvitation(e.App, invitationId)
+	if err != nil {
+		return err
+	}
+
+	familyMember, err := joinInvitedFamily(e.App, invitation, userId)
+	if err != nil {
+		return err
+	}
+
+	return e.JSON(http.StatusCreated, familyMember)
+}
+
+// acceptInvitation adds the recipient of an invitation to its family.
+func acceptInvitation(e *core.RequestEvent) error {
+	userId := e.Auth.Id
+
+	if err := requireVerified(e.Auth); err != nil {
+		return err
+	}
+
+	invitation, err := findReceivedInvitation(e.App, e.Request.PathValue("id"), userId)
+	if err != nil {
+		return err
+	}
+
+	familyMember, err := joinInvitedFamily(e.App, invitation, userId)
+	if err != nil {
+		return err
+	}
+
+	return e.JSON(http.StatusCreated, familyMember)
+}
+
+func declineInvitation(e *core.RequestEvent) error {
+	invitation, err := findReceivedInvitation(e.App, e.Request.PathValue("id"), e.Auth.Id)
+	if err != nil {
+		return err
+	}
+
+	if err := requirePending(invitation); err != nil {
+		return err
+	}
+
+	invitation.Status = models.InvitationDeclined
+
+	invitation, err = database.UpdateInvitation(e.App, invitation)
+	if err != nil {
+		return fromSaveError(err, "Failed to decline invitation.")
+	}
+
+	return e.JSON(http.StatusOK, invitation)
+}
+
+// revokeInvitation withdraws a pending invitation. Besides its sender, the
+// family's owner and admins can revoke any invitation to the family.
+func revokeInvitation(e *core.RequestEvent) error {
+	invitation, err := findSentInvitation(e)
+	if err != nil {
+		return err
+	}
+
+	if invitation.Status != models.InvitationPending {
+		return conflict("Only pending invitations can be revoked.", nil)
+	}
+
+	invitation.Status = models.InvitationRevoked
+
+	invitation, err = database.UpdateInvitation(e.App, invitation)
+	if err != nil {
+		return fromSaveError(err, "Failed to revoke invitation.")
+	}
+
+	return e.JSON(http.StatusOK, invitation)
+}
+
+// resendInvitation sends a pending or expired invitation again, giving it a
+// new lifetime. Email invitations are mailed with a new link.
+func resendInvitation(e *core.RequestEvent) error {
+	invitation, err := findSentInvitation(e)
+	if err != nil {
+		return err
+	}
+
+	if invitation.Status != models.InvitationPending && invitation.Status != models.InvitationExpired {
+		return conflict("Only pending or expired invitations can be resent.", nil)
+	}
+
+	now := time.Now()
+	if wait := invitation.SentAt.Time().Add(invitationResendCooldown).Sub(now); wait > 0 {
+		e.Response.Header().Set("Retry-After", retryAfterSeconds(wait))
+		return tooManyRequests("The invitation was sent recently. Try again later.", nil)
+	}
+
+	previous := invitation
+	invitation.Status = models.InvitationPending
+	invitation.ExpiresAt = toDateTime(now.Add(invitationTTL))
+	invitation.SentAt = toDateTime(now)
+
+	invitation, err = database.UpdateInvitation(e.App, invitation)
+	if database.IsUniqueViolation(err) {
+		return conflict("A newer invitation to this family is already pending.", err)
+	} else if err != nil {
+		return fromSaveError(err, "Failed to resend invitation.")
+	}
+
+	if invitation.Recipient != "" {
+		return e.JSON(http.StatusOK, invitation)
+	}
+
+	// the invitation is restored if the mail can't be delivered, so the
+	// cooldown doesn't hold back another attempt
+	if err := sendInvitationMail(e.App, invitation, e.Auth); err != nil {
+		if _, restoreErr := database.UpdateInvitation(e.App, previous); restoreErr != nil {
+			e.App.Logger().Error("Failed to restore undelivered invitation", "id", invitation.ID, "error", restoreErr)
+		}
+
+		return internalError("Failed to send the invitation email.", err)
+	}
+
+	return e.JSON(http.StatusOK, invitation)
+}
+
+// expireInvitations marks the pending invitations past their expiry as
+// expired. It runs as a cron job.
+func expireInvitations(app core.App) {
+	count, err := database.ExpireInvitations(app, time.Now())
+	if err != nil {
+		app.Logger().Error("Failed to expire invitations", "error", err)
+	} else if count > 0 {
+		app.Logger().Info("Expired invitations", "count", count)
+	}
+}
+
+func findInvitation(app core.App, invitationId string) (models.Invitation, error) {
+	invitation, err := database.GetInvitation(app.DB(), invitationId)
+	if errors.Is(err, sql.ErrNoRows) {
+		return invitation, notFound("Invitation not found.", err)
+	} else if err != nil {
+		return invitation, internalError("Failed to get invitation data.", err)
+	}
+
+	return invitation, nil
+}
+
+// findReceivedInvitation returns an invitation addressed to the user.
+// Invitations to others are reported as not found.
+func findReceivedInvitation(app core.App, invitationId, userId string) (models.Invitation, error) {
+	invitation, err := findInvitation(app, invitationId)
+	if err != nil {
+		return invitation, err
+	}
+
+	if invitation.Recipient != userId {
+		return invitation, notFound("Invitation not found.", nil)
+	}
+
+	return invitation, nil
+}
+
+// findSentInvitation returns the invitation of the request path if the user
+// sent it or manages its family.
+func findSentInvitation(e *core.RequestEvent) (models.Invitation, error) {
+	userId := e.Auth.Id
+	familyId := e.Request.PathValue("id")
+
+	familyMember, err := findMembership(e.App, familyId, userId)
+	if err != nil {
+		return models.Invitation{}, err
+	}
+
+	invitation, err := findInvitation(e.App, e.Request.PathValue("invitationId"))
+	if err != nil {
+		return invitation, err
+	}
+
+	if invitation.Family != familyId {
+		return invitation, notFound("Invitation not found.", nil)
+	}
+
+	if invitation.Sender != userId && familyMember.Role != models.RoleOwner && familyMember.Role != models.RoleAdmin {
+		return invitation, forbidden("Only the sender or an admin can manage this invitation.", nil)
+	}
+
+	return invitation, nil
+}
+
+// requireVerified rejects users who haven't verified their email address.
+// Anyone can sign up with an address, so only verified users may join the
+// families it was invited to.
+func requireVerified(auth *core.Record) error {
+	if !auth.Verified() {
+		return forbidden("Verify your email address before joining a family.", nil)
+	}
+
+	return nil
+}
+
+// requirePending fails for invitations that can no longer be answered.
+func requirePending(invitation models.Invitation) error {
+	if invitation.Status != models.InvitationPending {
+		return conflict("The invitation is no longer pending.", nil)
+	}
+
+	// the expiry job may not have run yet
+	if !invitation.ExpiresAt.Time().After(time.Now()) {
+		return conflict("The invitation has expired.", nil)
+	}
+
+	return nil
+}
+
+// joinInvitedFamily accepts an invitation on behalf of the user and adds
+// them to its family.
+func joinInvitedFamily(app core.App, invitation models.Invitation, userId string) (models.FamilyMember, error) {
+	if err := requirePending(invitation); err != nil {
+		return models.FamilyMember{}, err
+	}
+
+	_, err := database.GetFamilyMember(app.DB(), invitation.Family, userId)
+	if err == nil {
+		return models.FamilyMember{}, conflict("You're already a member of this family.", nil)
+	} else if !errors.Is(err, sql.ErrNoRows) {
+		return models.FamilyMember{}, internalError("Failed to get family member data.", err)
+	}
+
+	var familyMember models.FamilyMember
+	err = app.RunInTransaction(func(txApp core.App) error {
+		if err := checkJoinQuotas(txApp, invitation.Family, userId); err != nil {
+			return err
+		}
+
+		var err error
+
+		familyMember, err = database.CreateFamilyMember(txApp, invitation.Family, userId, models.RoleMember)
+		if err != nil {
+			return err
+		}
+
+		invitation.Status = models.InvitationAccepted
+		if _, err := database.UpdateInvitation(txApp, invitation); err != nil {
+			return err
+		}
+
+		return audit(txApp, invitation.Family, userId, models.AuditMemberJoined, userId, map[string]any{
+			"role":       familyMember.Role,
+			"invitation": invitation.ID,
+			"invitedBy":  invitation.Sender,
+		})
+	})
+	if err != nil {
+		return familyMember, fromSaveError(err, "Failed to join family.")
+	}
+
+	return familyMember, nil
+}
+
+// addressEmailInvitations hands the invitations sent to a user's email
+// address over to the user once they verified it. Until then, anyone could
+// have signed up with the address.
+func addressEmailInvitations(e *core.RecordEvent) error {
+	if !e.Record.Verified() || e.Record.Original().Verified() {
+		return e.Next()
+	}
+
+	if err := database.AddressEmailInvitations(e.App, e.Record.Id, e.Record.Email()); err != nil {
+		e.App.Logger().Error("Failed to address email invitations", "user", e.Record.Id, "error", err)
+	}
+
+	return e.Next()
+}
+
+// getInvitationPage is the public landing page of the links mailed with
+// email invitations. It opens the app, which redeems the invitation.
+func getInvitationPage(e *core.RequestEvent) error {
+	token := e.Request.PathValue("token")
+
+	invitationId, _, err := parseInvitationToken(e.App, token)
+	if err != nil {
+		return renderInvitePage(e, "", "", invitationExpiredHint)
+	}
+
+	invitation, err := database.GetInvitation(e.App.DB(), invitationId)
+	if errors.Is(err, sql.ErrNoRows) || (err == nil && requirePending(invitation) != nil) {
+		return renderInvitePage(e, "", "", invitationExpiredHint)
+	} else if err != nil {
+		return e.InternalServerError("Failed to get invitation data.", err)
+	}
+
+	family, err := database.GetFamily(e.App.DB(), invitation.Family)
+	if errors.Is(err, sql.ErrNoRows) {
+		return renderInvitePage(e, "", "", invitationExpiredHint)
+	} else if err != nil {
+		return e.InternalServerError("Failed to get family data.", err)
+	}
+
+	return renderInvitePage(e, family.Name, appScheme+"://invitations/"+url.PathEscape(token), "")
+}
+
+// invitationSigningKey is the key invitation and invite link tokens are
+// signed with. They share the lifecycle of the users' verification links, so
+// rotating that secret invalidates all of them.
+func invitationSigningKey(app core.App) (string, error) {
+	users, err := app.FindCachedCollectionByNameOrId("users")
+	if err != nil {
+		return "", err
+	}
+
+	return users.VerificationToken.Secret, nil
+}
+
+func newInvitationToken(app core.App, invitation models.Invitation) (string, error) {
+	key, err := invitationSigningKey(app)
+	if err != nil {
+		return "", err
+	}
+
+	return security.NewJWT(jwt.MapClaims{
+		"type":       invitationTokenType,
+		"invitation": invitation.ID,
+		"email":      invitation.Email,
+	}, key, invitationTTL)
+}
+
+// parseInvitationToken verifies an invitation token and returns the
+// invitation id and email address it was issued for.
+func parseInvitationToken(app core.App, token string) (string, string, error) {
+	key, err := invitationSigningKey(app)
+	if err != nil {
+		return "", "", err
+	}
+
+	claims, err := security.ParseJWT(token, key)
+	if err != nil {
+		return "", "", err
+	}
+
+	invitationId, _ := claims["invitation"].(string)
+	email, _ := claims["email"].(string)
+	if claims["type"] != invitationTokenType || invitationId == "" || email == "" {
+		return "", "", errors.New("not an invitation token")
+	}
+
+	return invitationId, email, nil
+}
+
+func sendInvitationMail(app core.App, invitation models.Invitation, sender *core.Record) error {
+	family, err := database.GetFamily(app.DB(), invitation.Family)
+	if err != nil {
+		return err
+	}
+
+	token, err := newInvitationToken(app, invitation)
+	if err != nil {
+		return err
+	}
+
+	meta := app.Settings().Meta
+	link := strings.TrimRight(meta.AppURL, "/") + "/invitations/" + url.PathEscape(token)
+	senderName := strings.TrimSpace(sender.GetString("firstName") + " " + sender.GetString("lastName"))
+
+	return app.NewMailClient().Send(&mailer.Message{
+		From: mail.Address{
+			Address: meta.SenderAddress,
+			Name:    meta.SenderName,
+		},
+		To:      []mail.Address{{Address: invitation.Email}},
+		Subject: fmt.Sprintf("%s invited you to %s", senderName, family.Name),
+		HTML: fmt.Sprintf(
+			`<p>%s invited you to join <strong>%s</strong> on %s.</p><p><a href="%s">Accept the invitation</a></p><p>The link expires in %d days.</p>`,
+			html.EscapeString(senderName),
+			html.EscapeString(family.Name),
+			html.EscapeString(meta.AppName),
+			html.EscapeString(link),
+			int(invitationTTL.Hours()/24),
+		),
+	})
+}
+
+func toDateTime(t time.Time) types.DateTime {
+	dt, _ := types.ParseDateTime(t)
+	return dt
+}
@@ -0,0 +1,824 @@
+package handlers_test
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/tests"
+	"github.com/pocketbase/pocketbase/tools/security"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	vaderId          = "edhmc5ydeq7xb4h"
+	emailInvitation  = "emailinvite0001"
+	vaderInvitation  = "hnz94s5zj8essss"
+	grandmaEmail     = "shmi.skywalker@email.com"
+	vaderEmail       = "darth.vader@email.com"
+	invitationExpiry = time.Hour
+)
+
+// setupInvitationTestApp seeds an email invitation to the Skywalkers for an
+// address without an account.
+func setupInvitationTestApp(t testing.TB) *tests.TestApp {
+	app := setupTestApp(t)
+
+	seedRecords(t, app, "invitations", map[string]any{
+		"id":        emailInvitation,
+		"sender":    lukeId,
+		"email":     grandmaEmail,
+		"family":    skywalkersId,
+		"status":    "pending",
+		"sentAt":    time.Now().Add(-2 * time.Hour),
+		"expiresAt": time.Now().Add(invitationExpiry),
+	})
+
+	return app
+}
+
+// signToken signs claims the way the server signs invitation and invite
+// link tokens.
+func signToken(t testing.TB, claims jwt.MapClaims, ttl time.Duration) string {
+	t.Helper()
+
+	app, err := tests.NewTestApp(testDataDir)
+	require.NoError(t, err)
+	defer app.Cleanup()
+
+	users, err := app.FindCollectionByNameOrId("users")
+	require.NoError(t, err)
+
+	token, err := security.NewJWT(claims, users.VerificationToken.Secret, ttl)
+	require.NoError(t, err)
+
+	return token
+}
+
+// unverify marks the email address of a user as unverified.
+func unverify(t testing.TB, app *tests.TestApp, userId string) {
+	user, err := app.FindRecordById("users", userId)
+	require.NoError(t, err)
+
+	user.SetVerified(false)
+	require.NoError(t, app.Save(user))
+}
+
+// failMailer makes every mail sent by the app fail to be delivered.
+func failMailer(app *tests.TestApp) {
+	app.OnMailerSend().BindFunc(func(e *core.MailerEvent) error {
+		return errors.New("smtp unavailable")
+	})
+}
+
+// invitationToken signs the token mailed with an email invitation.
+func invitationToken(t testing.TB, invitationId, email string) string {
+	return signToken(t, jwt.MapClaims{
+		"type":       "invitation",
+		"invitation": invitationId,
+		"email":      email,
+	}, invitationExpiry)
+}
+
+func TestCreateInvitation(t *testing.T) {
+	luke := generateToken(t, "users", "luke.skywalker@email.com")
+	vader := generateToken(t, "users", vaderEmail)
+
+	path := "/mobile/families/" + skywalkersId + "/invitations"
+	scenarios := []tests.ApiScenario{
+		{
+			Name:            "unauthorized",
+			Method:          http.MethodPost,
+			URL:             path,
+			Body:            strings.NewReader(`{"email":"` + grandmaEmail + `"}`),
+			ExpectedStatus:  http.StatusUnauthorized,
+			ExpectedContent: []string{`"code":"unauthorized"`},
+			TestAppFactory:  setupTestApp,
+		},
+		{
+			Name:   "not a member",
+			Method: http.MethodPost,
+			URL:    path,
+			Body:   strings.NewReader(`{"email":"` + grandmaEmail + `"}`),
+			Headers: map[string]string{
+				"Authorization": vader,
+			},
+			ExpectedStatus:  http.StatusNotFound,
+			ExpectedContent: []string{`"code":"not_found"`},
+			TestAppFactory:  setupTestApp,
+		},
+		{
+			Name:   "recipient and email",
+			Method: http.MethodPost,
+			URL:    path,
+			Body:   strings.NewReader(`{"recipient":"` + vaderId + `","email":"` + grandmaEmail + `"}`),
+			Headers: map[string]string{
+				"Authorization": luke,
+			},
+			ExpectedStatus:  http.StatusBadRequest,
+			ExpectedContent: []string{`"code":"validation_failed"`, `"email":`},
+			TestAppFactory:  setupTestApp,
+		},
+		{
+			Name:   "invalid email",
+			Method: http.MethodPost,
+			URL:    path,
+			Body:   strings.NewReader(`{"email":"grandma"}`),
+			Headers: map[string]string{
+				"Authorization": luke,
+			},
+			ExpectedStatus:  http.StatusBadRequest,
+			ExpectedContent: []string{`"code":"validation_failed"`, `"email":`},
+			TestAppFactory:  setupTestApp,
+		},
+		{
+			Name:   "already a member",
+			Method: http.MethodPost,
+			URL:    path,
+			Body:   strings.NewReader(`{"email":"leia.organa@email.com"}`),
+			Headers: map[string]string{
+				"Authorization": luke,
+			},
+			ExpectedStatus:  http.StatusConflict,
+			ExpectedContent: []string{`"code":"conflict"`},
+			TestAppFactory:  setupTestApp,
+		},
+		{
+			Name:   "already invited",
+			Method: http.MethodPost,
+			URL:    path,
+			Body:   strings.NewReader(`{"recipient":"` + vaderId + `"}`),
+			Headers: map[string]string{
+				"Authorization": luke,
+			},
+			ExpectedStatus:  http.StatusConflict,
+			ExpectedContent: []string{`"code":"conflict"`},
+			TestAppFactory:  setupTestApp,
+		},
+		{
+			Name:   "email of an existing user",
+			Method: http.MethodPost,
+			URL:    path,
+			Body:   strings.NewReader(`{"email":" Darth.Vader@email.com "}`),
+			Headers: map[string]string{
+				"Authorization": luke,
+			},
+			ExpectedStatus:  http.StatusCreated,
+			ExpectedContent: []string{`"recipient":"` + vaderId + `"`, `"email":""`, `"status":"pending"`},
+			TestAppFactory: func(t testing.TB) *tests.TestApp {
+				app := setupTestApp(t)
+
+				invitation, err := app.FindRecordById("invitations", vaderInvitation)
+				require.NoError(t, err)
+
+				invitation.Set("status", "declined")
+				require.NoError(t, app.Save(invitation))
+
+				return app
+			},
+			AfterTestFunc: func(t testing.TB, app *tests.TestApp, res *http.Response) {
+				require.Zero(t, app.TestMailer.TotalSend())
+			},
+		},
+		{
+			Name:   "email without an account",
+			Method: http.MethodPost,
+			URL:    path,
+			Body:   strings.NewReader(`{"email":"` + grandmaEmail + `"}`),
+			Headers: map[string]string{
+				"Authorization": luke,
+			},
+			ExpectedStatus:  http.StatusCreated,
+			ExpectedContent: []string{`"recipient":""`, `"email":"` + grandmaEmail + `"`},
+			TestAppFactory:  setupTestApp,
+			AfterTestFunc: func(t testing.TB, app *tests.TestApp, res *http.Response) {
+				require.Equal(t, 1, app.TestMailer.TotalSend())
+
+				message := app.TestMailer.LastMessage()
+				require.Equal(t, grandmaEmail, message.To[0].Address)
+				require.Contains(t, message.Subject, "Skywalkers")
+				require.Contains(t, message.HTML, "/invitations/")
+
+				events, err := app.FindAllRecords("auditEvents", dbx.HashExp{"action": "member.invited"})
+				require.NoError(t, err)
+				require.Len(t, events, 1)
+				require.Contains(t, events[0].GetString("data"), grandmaEmail)
+			},
+		},
+		{
+			Name:   "undelivered email",
+			Method: http.MethodPost,
+			URL:    path,
+			Body:   strings.NewReader(`{"email":"` + grandmaEmail + `"}`),
+			Headers: map[string]string{
+				"Authorization": luke,
+			},
+			ExpectedStatus:  http.StatusInternalServerError,
+			ExpectedContent: []string{`"code":"internal_error"`},
+			TestAppFactory: func(t testing.TB) *tests.TestApp {
+				app := setupTestApp(t)
+				failMailer(app)
+
+				return app
+			},
+			AfterTestFunc: func(t testing.TB, app *tests.TestApp, res *http.Response) {
+				_, err := app.FindFirstRecordByData("invitations", "email", grandmaEmail)
+				require.Error(t, err)
+
+				events, err := app.FindAllRecords("auditEvents", dbx.HashExp{"action": "member.invited"})
+				require.NoError(t, err)
+				require.Empty(t, events)
+			},
+		},
+	}
+
+	for _, scenario := range scenarios {
+		scenario.Test(t)
+	}
+}
+
+func TestEmailInvitationOnSignUp(t *testing.T) {
+	app := setupInvitationTestApp(t)
+	defer app.Cleanup()
+
+	users, err := app.FindCollectionByNameOrId("users")
+	require.NoError(t, err)
+
+	grandma := core.NewRecord(users)
+	grandma.SetEmail("Shmi.Skywalker@email.com")
+	grandma.SetPassword("password123")
+	grandma.Set("firstName", "shmi")
+	grandma.Set("lastName", "skywalker")
+	require.NoError(t, app.Save(grandma))
+
+	// anyone could have signed up with the address
+	invitation, err := app.FindRecordById("invitations", emailInvitation)
+	require.NoError(t, err)
+	require.Empty(t, invitation.GetString("recipient"))
+
+	grandma.SetVerified(true)
+	require.NoError(t, app.Save(grandma))
+
+	invitation, err = app.FindRecordById("invitations", emailInvitation)
+	require.NoError(t, err)
+	require.Equal(t, grandma.Id, invitation.GetString("recipient"))
+}
+
+func TestEmailInvitationDuplicates(t *testing.T) {
+	app := setupInvitationTestApp(t)
+	defer app.Cleanup()
+
+	seedRecords(t, app, "families", map[string]any{"id": empireId, "name": "Empire", "code": "death-star", "createdBy": vaderId})
+	seedRecords(t, app, "invitations", map[string]any{
+		"id":        "emailinvite0002",
+		"sender":    vaderId,
+		"email":     grandmaEmail,
+		"family":    empireId,
+		"status":    "pending",
+		"sentAt":    time.Now(),
+		"expiresAt": time.Now().Add(invitationExpiry),
+	})
+
+	users, err := app.FindCollectionByNameOrId("users")
+	require.NoError(t, err)
+
+	grandma := core.NewRecord(users)
+	grandma.SetEmail(grandmaEmail)
+	grandma.SetPassword("password123")
+	grandma.Set("firstName", "shmi")
+	grandma.Set("lastName", "skywalker")
+	require.NoError(t, app.Save(grandma))
+
+	// invited to the Skywalkers again before verifying her address
+	seedRecords(t, app, "invitations", map[string]any{
+		"sender":    leiaId,
+		"recipient": grandma.Id,
+		"family":    skywalkersId,
+		"status":    "pending",
+		"sentAt":    time.Now(),
+		"expiresAt": time.Now().Add(invitationExpiry),
+	})
+
+	grandma.SetVerified(true)
+	require.NoError(t, app.Save(grandma))
+
+	duplicate, err := app.FindRecordById("invitations", emailInvitation)
+	require.NoError(t, err)
+	require.Equal(t, "revoked", duplicate.GetString("status"))
+
+	empire, err := app.FindRecordById("invitations", "emailinvite0002")
+	require.NoError(t, err)
+	require.Equal(t, "pending", empire.GetString("status"))
+	require.Equal(t, grandma.Id, empire.GetString("recipient"))
+}
+
+func TestInvitationLink(t *testing.T) {
+	luke := generateToken(t, "users", "luke.skywalker@email.com")
+
+	app := setupTestApp(t)
+	defer app.Cleanup()
+
+	factory := func(t testing.TB) *tests.TestApp {
+		return app
+	}
+
+	(&tests.ApiScenario{
+		Name:   "invite by email",
+		Method: http.MethodPost,
+		URL:    "/mobile/families/" + skywalkersId + "/invitations",
+		Body:   strings.NewReader(`{"email":"` + grandmaEmail + `"}`),
+		Headers: map[string]string{
+			"Authorization": luke,
+		},
+		ExpectedStatus:        http.StatusCreated,
+		ExpectedContent:       []string{`"email":"` + grandmaEmail + `"`},
+		TestAppFactory:        factory,
+		DisableTestAppCleanup: true,
+	}).Test(t)
+
+	match := regexp.MustCompile(`href="([^"]+)"`).FindStringSubmatch(app.TestMailer.LastMessage().HTML)
+	require.Len(t, match, 2)
+
+	link, err := url.Parse(match[1])
+	require.NoError(t, err)
+
+	token := strings.TrimPrefix(link.Path, "/invitations/")
+	require.NotEqual(t, link.Path, token)
+
+	(&tests.ApiScenario{
+		Name:                  "open the link",
+		Method:                http.MethodGet,
+		URL:                   link.Path,
+		ExpectedStatus:        http.StatusOK,
+		ExpectedContent:       []string{`<h1>Skywalkers</h1>`, `href="tribetracker://invitations/` + token + `"`},
+		TestAppFactory:        factory,
+		DisableTestAppCleanup: true,
+	}).Test(t)
+
+	users, err := app.FindCollectionByNameOrId("users")
+	require.NoError(t, err)
+
+	grandma := core.NewRecord(users)
+	grandma.SetEmail(grandmaEmail)
+	grandma.SetPassword("password123")
+	grandma.SetVerified(true)
+	grandma.Set("firstName", "shmi")
+	grandma.Set("lastName", "skywalker")
+	require.NoError(t, app.Save(grandma))
+
+	grandmaToken, err := grandma.NewAuthToken()
+	require.NoError(t, err)
+
+	(&tests.ApiScenario{
+		Name:   "redeem from the app",
+		Method: http.MethodPost,
+		URL:    "/mobile/invitations/redeem",
+		Body:   strings.NewReader(`{"token":"` + token + `"}`),
+		Headers: map[string]string{
+			"Authorization": grandmaToken,
+		},
+		ExpectedStatus:        http.StatusCreated,
+		ExpectedContent:       []string{`"family":"` + skywalkersId + `"`, `"user":"` + grandma.Id + `"`},
+		TestAppFactory:        factory,
+		DisableTestAppCleanup: true,
+	}).Test(t)
+}
+
+func TestInvitationPage(t *testing.T) {
+	scenarios := []tests.ApiScenario{
+		{
+			Name:               "invalid token",
+			Method:             http.MethodGet,
+			URL:                "/invitations/not-a-token",
+			ExpectedStatus:     http.StatusNotFound,
+			ExpectedContent:    []string{`Invite link expired`, `send it again`},
+			NotExpectedContent: []string{`tribetracker://`},
+			TestAppFactory:     setupInvitationTestApp,
+		},
+		{
+			Name:               "revoked invitation",
+			Method:             http.MethodGet,
+			URL:                "/invitations/" + invitationToken(t, emailInvitation, grandmaEmail),
+			ExpectedStatus:     http.StatusNotFound,
+			ExpectedContent:    []string{`Invite link expired`},
+			NotExpectedContent: []string{`Skywalkers`, `tribetracker://`},
+			TestAppFactory: func(t testing.TB) *tests.TestApp {
+				app := setupInvitationTestApp(t)
+
+				invitation, err := app.FindRecordById("invitations", emailInvitation)
+				require.NoError(t, err)
+
+				invitation.Set("status", "revoked")
+				require.NoError(t, app.Save(invitation))
+
+				return app
+			},
+		},
+	}
+
+	for _, scenario := range scenarios {
+		scenario.Test(t)
+	}
+}
+
+func TestRedeemInvitation(t *testing.T) {
+	luke := generateToken(t, "users", "luke.skywalker@email.com")
+	leia := generateToken(t, "users", "leia.organa@email.com")
+	vader := generateToken(t, "users", vaderEmail)
+
+	// Vader signed up after being invited by email
+	setupApp := func(t testing.TB) *tests.TestApp {
+		app := setupTestApp(t)
+
+		invitation, err := app.FindRecordById("invitations", vaderInvitation)
+		require.NoError(t, err)
+
+		invitation.Set("email", vaderEmail)
+		require.NoError(t, app.Save(invitation))
+
+		return app
+	}
+
+	path := "/mobile/invitations/redeem"
+	scenarios := []tests.ApiScenario{
+		{
+			Name:   "invalid token",
+			Method: http.MethodPost,
+			URL:    path,
+			Body:   strings.NewReader(`{"token":"not-a-token"}`),
+			Headers: map[string]string{
+				"Authorization": vader,
+			},
+			ExpectedStatus:  http.StatusBadRequest,
+			ExpectedContent: []string{`"code":"validation_failed"`, `"token":`},
+			TestAppFactory:  setupApp,
+		},
+		{
+			Name:   "unverified",
+			Method: http.MethodPost,
+			URL:    path,
+			Body:   strings.NewReader(`{"token":"` + invitationToken(t, vaderInvitation, vaderEmail) + `"}`),
+			Headers: map[string]string{
+				"Authorization": vader,
+			},
+			ExpectedStatus:  http.StatusForbidden,
+			ExpectedContent: []string{`"code":"forbidden"`},
+			TestAppFactory: func(t testing.TB) *tests.TestApp {
+				app := setupApp(t)
+				unverify(t, app, vaderId)
+
+				return app
+			},
+		},
+		{
+			Name:   "another address",
+			Method: http.MethodPost,
+			URL:    path,
+			Body:   strings.NewReader(`{"token":"` + invitationToken(t, vaderInvitation, vaderEmail) + `"}`),
+			Headers: map[string]string{
+				"Authorization": leia,
+			},
+			ExpectedStatus:  http.StatusForbidden,
+			ExpectedContent: []string{`"code":"forbidden"`},
+			TestAppFactory:  setupApp,
+		},
+		{
+			Name:   "already a member",
+			Method: http.MethodPost,
+			URL:    path,
+			Body:   strings.NewReader(`{"token":"` + invitationToken(t, vaderInvitation, "luke.skywalker@email.com") + `"}`),
+			Headers: map[string]string{
+				"Authorization": luke,
+			},
+			ExpectedStatus:  http.StatusConflict,
+			ExpectedContent: []string{`"code":"conflict"`},
+			TestAppFactory:  setupApp,
+		},
+		{
+			Name:   "joined",
+			Method: http.MethodPost,
+			URL:    path,
+			Body:   strings.NewReader(`{"token":"` + invitationToken(t, vaderInvitation, vaderEmail) + `"}`),
+			Headers: map[string]string{
+				"Authorization": vader,
+			},
+			ExpectedStatus:  http.StatusCreated,
+			ExpectedContent: []string{`"family":"` + skywalkersId + `"`, `"user":"` + vaderId + `"`, `"role":"member"`},
+			TestAppFactory:  setupApp,
+			AfterTestFunc: func(t testing.TB, app *tests.TestApp, res *http.Response) {
+				invitation, err := app.FindRecordById("invitations", vaderInvitation)
+				require.NoError(t, err)
+				require.Equal(t, "accepted", invitation.GetString("status"))
+			},
+		},
+		{
+			Name:   "revoked",
+			Method: http.MethodPost,
+			URL:    path,
+			Body:   strings.NewReader(`{"token":"` + invitationToken(t, vaderInvitation, vaderEmail) + `"}`),
+			Headers: map[string]string{
+				"Authorization": vader,
+			},
+			ExpectedStatus:  http.StatusConflict,
+			ExpectedContent: []string{`"code":"conflict"`},
+			TestAppFactory: func(t testing.TB) *tests.TestApp {
+				app := setupApp(t)
+
+				invitation, err := app.FindRecordById("invitations", vaderInvitation)
+				require.NoError(t, err)
+
+				invitation.Set("status", "revoked")
+				require.NoError(t, app.Save(invitation))
+
+				return app
+			},
+		},
+	}
+
+	for _, scenario := range scenarios {
+		scenario.Test(t)
+	}
+}
+
+func TestRevokeInvitation(t *testing.T) {
+	luke := generateToken(t, "users", "luke.skywalker@email.com")
+	leia := generateToken(t, "users", "leia.organa@email.com")
+
+	path := "/mobile/families/" + skywalkersId + "/invitations/" + vaderInvitation
+	scenarios := []tests.ApiScenario{
+		{
+			Name:   "not the sender",
+			Method: http.MethodDelete,
+			URL:    path,
+			Headers: map[string]string{
+				"Authorization": leia,
+			},
+			ExpectedStatus:  http.StatusForbidden,
+			ExpectedContent: []string{`"code":"forbidden"`},
+			TestAppFactory:  setupTestApp,
+		},
+		{
+			Name:   "another family",
+			Method: http.MethodDelete,
+			URL:    "/mobile/families/" + empireId + "/invitations/" + vaderInvitation,
+			Headers: map[string]string{
+				"Authorization": luke,
+			},
+			ExpectedStatus:  http.StatusNotFound,
+			ExpectedContent: []string{`"code":"not_found"`},
+			TestAppFactory:  setupTestApp,
+		},
+		{
+			Name:   "revoked",
+			Method: http.MethodDelete,
+			URL:    path,
+			Headers: map[string]string{
+				"Authorization": luke,
+			},
+			ExpectedStatus:  http.StatusOK,
+			ExpectedContent: []string{`"status":"revoked"`},
+			TestAppFactory:  setupTestApp,
+		},
+		{
+			Name:   "already answered",
+			Method: http.MethodDelete,
+			URL:    "/mobile/families/" + skywalkersId + "/invitations/3x9bndtq78b4jgd",
+			Headers: map[string]string{
+				"Authorization": luke,
+			},
+			ExpectedStatus:  http.StatusConflict,
+			ExpectedContent: []string{`"code":"conflict"`},
+			TestAppFactory:  setupTestApp,
+		},
+	}
+
+	for _, scenario := range scenarios {
+		scenario.Test(t)
+	}
+}
+
+func TestResendInvitation(t *testing.T) {
+	luke := generateToken(t, "users", "luke.skywalker@email.com")
+
+	path := "/mobile/families/" + skywalkersId + "/invitations/" + emailInvitation + "/resend"
+	scenarios := []tests.ApiScenario{
+		{
+			Name:   "resent",
+			Method: http.MethodPost,
+			URL:    path,
+			Headers: map[string]string{
+				"Authorization": luke,
+			},
+			ExpectedStatus:  http.StatusOK,
+			ExpectedContent: []string{`"status":"pending"`},
+			TestAppFactory:  setupInvitationTestApp,
+			AfterTestFunc: func(t testing.TB, app *tests.TestApp, res *http.Response) {
+				require.Equal(t, 1, app.TestMailer.TotalSend())
+				require.Equal(t, grandmaEmail, app.TestMailer.LastMessage().To[0].Address)
+
+				invitation, err := app.FindRecordById("invitations", emailInvitation)
+				require.NoError(t, err)
+				require.WithinDuration(t, time.Now(), invitation.GetDateTime("sentAt").Time(), time.Minute)
+				require.True(t, invitation.GetDateTime("expiresAt").Time().After(time.Now().Add(13*24*time.Hour)))
+			},
+		},
+		{
+			Name:   "expired",
+			Method: http.MethodPost,
+			URL:    path,
+			Headers: map[string]string{
+				"Authorization": luke,
+			},
+			ExpectedStatus:  http.StatusOK,
+			ExpectedContent: []string{`"status":"pending"`},
+			TestAppFactory: func(t testing.TB) *tests.TestApp {
+				app := setupInvitationTestApp(t)
+
+				invitation, err := app.FindRecordById("invitations", emailInvitation)
+				require.NoError(t, err)
+
+				invitation.Set("status", "expired")
+				require.NoError(t, app.Save(invitation))
+
+				return app
+			},
+		},
+		{
+			Name:   "cooldown",
+			Method: http.MethodPost,
+			URL:    path,
+			Headers: map[string]string{
+				"Authorization": luke,
+			},
+			ExpectedStatus:  http.StatusTooManyRequests,
+			ExpectedContent: []string{`"code":"too_many_requests"`},
+			TestAppFactory: func(t testing.TB) *tests.TestApp {
+				app := setupInvitationTestApp(t)
+
+				invitation, err := app.FindRecordById("invitations", emailInvitation)
+				require.NoError(t, err)
+
+				invitation.Set("sentAt", time.Now().Add(-time.Minute))
+				require.NoError(t, app.Save(invitation))
+
+				return app
+			},
+			AfterTestFunc: func(t testing.TB, app *tests.TestApp, res *http.Response) {
+				require.NotEmpty(t, res.Header.Get("Retry-After"))
+				require.Zero(t, app.TestMailer.TotalSend())
+			},
+		},
+		{
+			Name:   "undelivered",
+			Method: http.MethodPost,
+			URL:    path,
+			Headers: map[string]string{
+				"Authorization": luke,
+			},
+			ExpectedStatus:  http.StatusInternalServerError,
+			ExpectedContent: []string{`"code":"internal_error"`},
+			TestAppFactory: func(t testing.TB) *tests.TestApp {
+				app := setupInvitationTestApp(t)
+				failMailer(app)
+
+				return app
+			},
+			AfterTestFunc: func(t testing.TB, app *tests.TestApp, res *http.Response) {
+				// restored, so the sender can try again right away
+				invitation, err := app.FindRecordById("invitations", emailInvitation)
+				require.NoError(t, err)
+				require.True(t, invitation.GetDateTime("sentAt").Time().Before(time.Now().Add(-time.Hour)))
+			},
+		},
+	}
+
+	for _, scenario := range scenarios {
+		scenario.Test(t)
+	}
+}
+
+func TestAnswerInvitation(t *testing.T) {
+	leia := generateToken(t, "users", "leia.organa@email.com")
+	vader := generateToken(t, "users", vaderEmail)
+
+	expired := func(t testing.TB) *tests.TestApp {
+		app := setupTestApp(t)
+
+		invitation, err := app.FindRecordById("invitations", vaderInvitation)
+		require.NoError(t, err)
+
+		invitation.Set("expiresAt", time.Now().Add(-time.Minute))
+		require.NoError(t, app.Save(invitation))
+
+		return app
+	}
+
+	path := "/mobile/invitations/" + vaderInvitation
+	scenarios := []tests.ApiScenario{
+		{
+			Name:   "not the recipient",
+			Method: http.MethodPost,
+			URL:    path + "/accept",
+			Headers: map[string]string{
+				"Authorization": leia,
+			},
+			ExpectedStatus:  http.StatusNotFound,
+			ExpectedContent: []string{`"code":"not_found"`},
+			TestAppFactory:  setupTestApp,
+		},
+		{
+			Name:   "accepted",
+			Method: http.MethodPost,
+			URL:    path + "/accept",
+			Headers: map[string]string{
+				"Authorization": vader,
+			},
+			ExpectedStatus:  http.StatusCreated,
+			ExpectedContent: []string{`"user":"` + vaderId + `"`, `"role":"member"`},
+			TestAppFactory:  setupTestApp,
+			AfterTestFunc: func(t testing.TB, app *tests.TestApp, res *http.Response) {
+				invitation, err := app.FindRecordById("invitations", vaderInvitation)
+				require.NoError(t, err)
+				require.Equal(t, "accepted", invitation.GetString("status"))
+			},
+		},
+		{
+			Name:   "unverified",
+			Method: http.MethodPost,
+			URL:    path + "/accept",
+			Headers: map[string]string{
+				"Authorization": vader,
+			},
+			ExpectedStatus:  http.StatusForbidden,
+			ExpectedContent: []string{`"code":"forbidden"`},
+			TestAppFactory: func(t testing.TB) *tests.TestApp {
+				app := setupTestApp(t)
+				unverify(t, app, vaderId)
+
+				return app
+			},
+		},
+		{
+			Name:   "declined",
+			Method: http.MethodPost,
+			URL:    path + "/decline",
+			Headers: map[string]string{
+				"Authorization": vader,
+			},
+			ExpectedStatus:  http.StatusOK,
+			ExpectedContent: []string{`"status":"declined"`},
+			TestAppFactory:  setupTestApp,
+		},
+		{
+			Name:   "expired",
+			Method: http.MethodPost,
+			URL:    path + "/accept",
+			Headers: map[string]string{
+				"Authorization": vader,
+			},
+			ExpectedStatus:  http.StatusConflict,
+			ExpectedContent: []string{`"code":"conflict"`},
+			TestAppFactory:  expired,
+		},
+	}
+
+	for _, scenario := range scenarios {
+		scenario.Test(t)
+	}
+}
+
+func TestExpireInvitations(t *testing.T) {
+	app := setupInvitationTestApp(t)
+	defer app.Cleanup()
+
+	invitation, err := app.FindRecordById("invitations", vaderInvitation)
+	require.NoError(t, err)
+
+	invitation.Set("expiresAt", time.Now().Add(-time.Minute))
+	require.NoError(t, app.Save(invitation))
+
+	var ran bool
+	for _, job := range app.Cron().Jobs() {
+		if job.Id() == "expireInvitations" {
+			job.Run()
+			ran = true
+		}
+	}
+	require.True(t, ran)
+
+	invitation, err = app.FindRecordById("invitations", vaderInvitation)
+	require.NoError(t, err)
+	require.Equal(t, "expired", invitation.GetString("status"))
+
+	// the email invitation hasn't expired yet
+	invitation, err = app.FindRecordById("invitations", emailInvitation)
+	require.NoError(t, err)
+	require.Equal(t, "pending", invitation.GetString("status"))
+}
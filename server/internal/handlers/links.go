@@ -0,0 +1,286 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"html/template"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	validation "github.com/go-ozzo/ozzo-validation/v4"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/ian-shakespeare/tribe-tracker/server/internal/database"
+	"github.com/ian-shakespeare/tribe-tracker/server/pkg/models"
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/tools/security"
+)
+
+const (
+	// joinTokenType tells invite link tokens apart from the other tokens
+	// signed with the same key.
+	joinTokenType = "join"
+
+	joinLinkDefaultTTL = 7 * 24 * time.Hour
+	joinLinkMaxTTL     = 30 * 24 * time.Hour
+
+	// appScheme is the URL scheme the mobile app is registered for.
+	appScheme = "tribetracker"
+)
+
+// joinExpiredHint is shown on the landing page of expired invite links.
+const joinExpiredHint = "Ask a family admin for a new one."
+
+// invitePage is the landing page of invite links and mailed invitations.
+var invitePage = template.Must(template.New("invite").Parse(`<!doctype html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>{{if .Family}}Join {{.Family}}{{else}}Invite link expired{{end}} · {{.AppName}}</title>
+<style>
+body { margin: 0; min-height: 100vh; display: flex; align-items: center; justify-content: center; font-family: system-ui, sans-serif; background: #1A2138; color: #fff; text-align: center; }
+main { padding: 2rem; max-width: 24rem; }
+a { display: inline-block; margin-top: 1.5rem; padding: 0.75rem 1.5rem; border-radius: 0.5rem; background: #3366FF; color: #fff; text-decoration: none; font-weight: 600; }
+</style>
+</head>
+<body>
+<main>
+{{if .Family}}
+<p>You've been invited to join</p>
+<h1>{{.Family}}</h1>
+<p>on {{.AppName}}.</p>
+<a href="{{.AppURL}}">Open in app</a>
+{{else}}
+<h1>Invite link expired</h1>
+<p>This invite link is invalid or has expired. {{.Hint}}</p>
+{{end}}
+</main>
+</body>
+</html>
+`))
+
+type createJoinLinkRequest struct {
+	// ExpiresIn is the lifetime of the link in seconds.
+	ExpiresIn *int `json:"expiresIn"`
+}
+
+func (r *createJoinLinkRequest) normalize() {}
+
+func (r *createJoinLinkRequest) validate(app core.App) error {
+	return validation.ValidateStruct(r,
+		validation.Field(&r.ExpiresIn, validation.Min(60), validation.Max(int(joinLinkMaxTTL.Seconds()))),
+	)
+}
+
+type joinFamilyRequest struct {
+	Token string `json:"token"`
+}
+
+func (r *joinFamilyRequest) normalize() {
+	r.Token = strings.TrimSpace(r.Token)
+}
+
+func (r *joinFamilyRequest) validate(app core.App) error {
+	return validation.ValidateStruct(r,
+		validation.Field(&r.Token, validation.Required),
+	)
+}
+
+// createJoinLink generates a shareable link anyone can use to join the
+// family until it expires. Links aren't stored, they are signed claims,
+// which only hold while their creator is still an owner or admin.
+func createJoinLink(e *core.RequestEvent) error {
+	familyId := e.Request.PathValue("id")
+
+	var req createJoinLinkRequest
+	if err := readBody(e, &req); err != nil {
+		return err
+	}
+
+	if _, err := findMembership(e.App, familyId, e.Auth.Id, models.RoleOwner, models.RoleAdmin); err != nil {
+		return err
+	}
+
+	ttl := joinLinkDefaultTTL
+	if req.ExpiresIn != nil {
+		ttl = time.Duration(*req.ExpiresIn) * time.Second
+	}
+
+	key, err := invitationSigningKey(e.App)
+	if err != nil {
+		return internalError("Failed to sign the invite link.", err)
+	}
+
+	expiresAt := time.Now().Add(ttl)
+	token, err := security.NewJWT(jwt.MapClaims{
+		"type":      joinTokenType,
+		"family":    familyId,
+		"createdBy": e.Auth.Id,
+	}, key, ttl)
+	if err != nil {
+		return internalError("Failed to sign the invite link.", err)
+	}
+
+	var res struct {
+		URL       string    `json:"url"`
+		Token     string    `json:"token"`
+		ExpiresAt time.Time `json:"expiresAt"`
+	}
+	res.URL = strings.TrimRight(e.App.Settings().Meta.AppURL, "/") + "/join/" + url.PathEscape(token)
+	res.Token = token
+	res.ExpiresAt = expiresAt.UTC().Truncate(time.Second)
+
+	return e.JSON(http.StatusCreated, res)
+}
+
+// joinFamily adds the user to the family of an invite link.
+func joinFamily(e *core.RequestEvent) error {
+	userId := e.Auth.Id
+
+	var req joinFamilyRequest
+	if err := readBody(e, &req); err != nil {
+		return err
+	}
+
+	familyId, createdBy, err := parseJoinToken(e.App, req.Token)
+	if err != nil {
+		return validationFailed(map[string]string{"token": "The invite link is invalid or expired."}, err)
+	}
+
+	if _, err := checkJoinLink(e.App.DB(), familyId, createdBy); err != nil {
+		return err
+	}
+
+	_, err = database.GetFamilyMember(e.App.DB(), familyId, userId)
+	if err == nil {
+		return conflict("You're already a member of this family.", nil)
+	} else if !errors.Is(err, sql.ErrNoRows) {
+		return internalError("Failed to get family member data.", err)
+	}
+
+	var familyMember models.FamilyMember
+	err = e.App.RunInTransaction(func(txApp core.App) error {
+		if err := checkJoinQuotas(txApp, familyId, userId); err != nil {
+			return err
+		}
+
+		var err error
+
+		familyMember, err = database.CreateFamilyMember(txApp, familyId, userId, models.RoleMember)
+		if err != nil {
+			return err
+		}
+
+		return audit(txApp, familyId, userId, models.AuditMemberJoined, userId, map[string]any{
+			"role":     familyMember.Role,
+			"joinLink": true,
+		})
+	})
+	if err != nil {
+		return fromSaveError(err, "Failed to join family.")
+	}
+
+	return e.JSON(http.StatusCreated, familyMember)
+}
+
+// getJoinPage is the public landing page of invite links, opened by people
+// who don't have the app handling the link yet.
+func getJoinPage(e *core.RequestEvent) error {
+	token := e.Request.PathValue("token")
+
+	familyId, createdBy, err := parseJoinToken(e.App, token)
+	if err != nil {
+		return renderInvitePage(e, "", "", joinExpiredHint)
+	}
+
+	family, err := checkJoinLink(e.App.DB(), familyId, createdBy)
+	if err != nil {
+		if apiErr := toAPIError(err); apiErr.status >= http.StatusInternalServerError {
+			return e.InternalServerError(apiErr.response.Message, err)
+		}
+
+		return renderInvitePage(e, "", "", joinExpiredHint)
+	}
+
+	return renderInvitePage(e, family.Name, appScheme+"://join/"+url.PathEscape(token), "")
+}
+
+// renderInvitePage responds with the landing page inviting to the family,
+// with a button opening appURL in the app. Without a family, the page tells
+// the link expired, followed by hint.
+func renderInvitePage(e *core.RequestEvent, family, appURL, hint string) error {
+	var data struct {
+		AppName string
+		Family  string
+		// AppURL is a custom scheme URL, which html/template would otherwise
+		// consider unsafe.
+		AppURL template.URL
+		Hint   string
+	}
+	data.AppName = e.App.Settings().Meta.AppName
+	data.Family = family
+	data.AppURL = template.URL(appURL)
+	data.Hint = hint
+
+	status := http.StatusOK
+	if family == "" {
+		status = http.StatusNotFound
+	}
+
+	var page strings.Builder
+	if err := invitePage.Execute(&page, data); err != nil {
+		return e.InternalServerError("Failed to render the page.", err)
+	}
+
+	e.Response.Header().Set("Cache-Control", "no-store")
+	e.Response.Header().Set("Referrer-Policy", "no-referrer")
+
+	return e.HTML(status, page.String())
+}
+
+// checkJoinLink returns the family of an invite link, failing if the family
+// was deleted or the link's creator can no longer invite to it, like
+// checkDisplayToken does for display tokens.
+func checkJoinLink(db dbx.Builder, familyId, createdBy string) (models.Family, error) {
+	// links outlive the family they were created for
+	family, err := database.GetFamily(db, familyId)
+	if errors.Is(err, sql.ErrNoRows) {
+		return family, notFound("The family no longer exists.", err)
+	} else if err != nil {
+		return family, internalError("Failed to get family data.", err)
+	}
+
+	creator, err := database.GetFamilyMember(db, familyId, createdBy)
+	if errors.Is(err, sql.ErrNoRows) || (err == nil && creator.Role != models.RoleOwner && creator.Role != models.RoleAdmin) {
+		return family, forbidden("The creator of this invite link can no longer invite to the family.", err)
+	} else if err != nil {
+		return family, internalError("Failed to get family member data.", err)
+	}
+
+	return family, nil
+}
+
+// parseJoinToken verifies an invite link token and returns its family id and
+// creator.
+func parseJoinToken(app core.App, token string) (string, string, error) {
+	key, err := invitationSigningKey(app)
+	if err != nil {
+		return "", "", err
+	}
+
+	claims, err := security.ParseJWT(token, key)
+	if err != nil {
+		return "", "", err
+	}
+
+	familyId, _ := claims["family"].(string)
+	createdBy, _ := claims["createdBy"].(string)
+	if claims["type"] != joinTokenType || familyId == "" || createdBy == "" {
+		return "", "", errors.New("not an invite link token")
+	}
+
+	return familyId, createdBy, nil
+}
@@ -0,0 +1,232 @@
+package handlers_test
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/tests"
+	"github.com/stretchr/testify/require"
+)
+
+func joinToken(t testing.TB, familyId string, ttl time.Duration) string {
+	return signToken(t, jwt.MapClaims{"type": "join", "family": familyId, "createdBy": lukeId}, ttl)
+}
+
+func TestCreateJoinLink(t *testing.T) {
+	luke := generateToken(t, "users", "luke.skywalker@email.com")
+	leia := generateToken(t, "users", "leia.organa@email.com")
+
+	path := "/mobile/families/" + skywalkersId + "/join-links"
+	scenarios := []tests.ApiScenario{
+		{
+			Name:   "not an admin",
+			Method: http.MethodPost,
+			URL:    path,
+			Body:   strings.NewReader(`{}`),
+			Headers: map[string]string{
+				"Authorization": leia,
+			},
+			ExpectedStatus:  http.StatusForbidden,
+			ExpectedContent: []string{`"code":"forbidden"`},
+			TestAppFactory:  setupTestApp,
+		},
+		{
+			Name:   "too long",
+			Method: http.MethodPost,
+			URL:    path,
+			Body:   strings.NewReader(`{"expiresIn":31536000}`),
+			Headers: map[string]string{
+				"Authorization": luke,
+			},
+			ExpectedStatus:  http.StatusBadRequest,
+			ExpectedContent: []string{`"code":"validation_failed"`, `"expiresIn":`},
+			TestAppFactory:  setupTestApp,
+		},
+		{
+			Name:   "created",
+			Method: http.MethodPost,
+			URL:    path,
+			Body:   strings.NewReader(`{"expiresIn":3600}`),
+			Headers: map[string]string{
+				"Authorization": luke,
+			},
+			ExpectedStatus:  http.StatusCreated,
+			ExpectedContent: []string{`"url":"http://localhost:8090/join/ey`, `"token":"ey`, `"expiresAt":`},
+			TestAppFactory:  setupTestApp,
+		},
+	}
+
+	for _, scenario := range scenarios {
+		scenario.Test(t)
+	}
+}
+
+func TestJoinFamily(t *testing.T) {
+	luke := generateToken(t, "users", "luke.skywalker@email.com")
+	vader := generateToken(t, "users", vaderEmail)
+
+	path := "/mobile/families/join"
+	scenarios := []tests.ApiScenario{
+		{
+			Name:   "expired",
+			Method: http.MethodPost,
+			URL:    path,
+			Body:   strings.NewReader(`{"token":"` + joinToken(t, skywalkersId, -time.Minute) + `"}`),
+			Headers: map[string]string{
+				"Authorization": vader,
+			},
+			ExpectedStatus:  http.StatusBadRequest,
+			ExpectedContent: []string{`"code":"validation_failed"`, `"token":`},
+			TestAppFactory:  setupTestApp,
+		},
+		{
+			Name:   "invitation token",
+			Method: http.MethodPost,
+			URL:    path,
+			Body:   strings.NewReader(`{"token":"` + invitationToken(t, vaderInvitation, vaderEmail) + `"}`),
+			Headers: map[string]string{
+				"Authorization": vader,
+			},
+			ExpectedStatus:  http.StatusBadRequest,
+			ExpectedContent: []string{`"code":"validation_failed"`, `"token":`},
+			TestAppFactory:  setupTestApp,
+		},
+		{
+			Name:   "deleted family",
+			Method: http.MethodPost,
+			URL:    path,
+			Body:   strings.NewReader(`{"token":"` + joinToken(t, skywalkersId, time.Hour) + `"}`),
+			Headers: map[string]string{
+				"Authorization": vader,
+			},
+			ExpectedStatus:  http.StatusNotFound,
+			ExpectedContent: []string{`"code":"not_found"`},
+			TestAppFactory: func(t testing.TB) *tests.TestApp {
+				app := setupTestApp(t)
+
+				family, err := app.FindRecordById("families", skywalkersId)
+				require.NoError(t, err)
+
+				family.Set("isDeleted", true)
+				require.NoError(t, app.Save(family))
+
+				return app
+			},
+			AfterTestFunc: func(t testing.TB, app *tests.TestApp, res *http.Response) {
+				_, err := app.FindFirstRecordByFilter("familyMembers", "family = {:family} && user = {:user}", dbx.Params{"family": skywalkersId, "user": vaderId})
+				require.Error(t, err)
+			},
+		},
+		{
+			Name:   "creator demoted",
+			Method: http.MethodPost,
+			URL:    path,
+			Body:   strings.NewReader(`{"token":"` + joinToken(t, skywalkersId, time.Hour) + `"}`),
+			Headers: map[string]string{
+				"Authorization": vader,
+			},
+			BeforeTestFunc: func(t testing.TB, app *tests.TestApp, e *core.ServeEvent) {
+				membership, err := app.FindRecordById("familyMembers", lukeMembershipId)
+				require.NoError(t, err)
+				membership.Set("role", "member")
+				require.NoError(t, app.Save(membership))
+			},
+			ExpectedStatus:  http.StatusForbidden,
+			ExpectedContent: []string{`"code":"forbidden"`, `creator`},
+			TestAppFactory:  setupTestApp,
+		},
+		{
+			Name:   "creator left",
+			Method: http.MethodPost,
+			URL:    path,
+			Body:   strings.NewReader(`{"token":"` + joinToken(t, skywalkersId, time.Hour) + `"}`),
+			Headers: map[string]string{
+				"Authorization": vader,
+			},
+			BeforeTestFunc: func(t testing.TB, app *tests.TestApp, e *core.ServeEvent) {
+				membership, err := app.FindRecordById("familyMembers", lukeMembershipId)
+				require.NoError(t, err)
+				require.NoError(t, app.Delete(membership))
+			},
+			ExpectedStatus:  http.StatusForbidden,
+			ExpectedContent: []string{`"code":"forbidden"`, `creator`},
+			TestAppFactory:  setupTestApp,
+		},
+		{
+			Name:   "already a member",
+			Method: http.MethodPost,
+			URL:    path,
+			Body:   strings.NewReader(`{"token":"` + joinToken(t, skywalkersId, time.Hour) + `"}`),
+			Headers: map[string]string{
+				"Authorization": luke,
+			},
+			ExpectedStatus:  http.StatusConflict,
+			ExpectedContent: []string{`"code":"conflict"`},
+			TestAppFactory:  setupTestApp,
+		},
+		{
+			Name:   "joined",
+			Method: http.MethodPost,
+			URL:    path,
+			Body:   strings.NewReader(`{"token":"` + joinToken(t, skywalkersId, time.Hour) + `"}`),
+			Headers: map[string]string{
+				"Authorization": vader,
+			},
+			ExpectedStatus:  http.StatusCreated,
+			ExpectedContent: []string{`"family":"` + skywalkersId + `"`, `"user":"` + vaderId + `"`, `"role":"member"`},
+			TestAppFactory:  setupTestApp,
+		},
+	}
+
+	for _, scenario := range scenarios {
+		scenario.Test(t)
+	}
+}
+
+func TestJoinPage(t *testing.T) {
+	token := joinToken(t, skywalkersId, time.Hour)
+
+	scenarios := []tests.ApiScenario{
+		{
+			Name:            "valid link",
+			Method:          http.MethodGet,
+			URL:             "/join/" + token,
+			ExpectedStatus:  http.StatusOK,
+			ExpectedContent: []string{`<h1>Skywalkers</h1>`, `href="tribetracker://join/` + token + `"`},
+			TestAppFactory:  setupTestApp,
+		},
+		{
+			Name:               "expired link",
+			Method:             http.MethodGet,
+			URL:                "/join/" + joinToken(t, skywalkersId, -time.Minute),
+			ExpectedStatus:     http.StatusNotFound,
+			ExpectedContent:    []string{`Invite link expired`},
+			NotExpectedContent: []string{`Skywalkers`, `tribetracker://`},
+			TestAppFactory:     setupTestApp,
+		},
+		{
+			Name:   "creator demoted",
+			Method: http.MethodGet,
+			URL:    "/join/" + token,
+			BeforeTestFunc: func(t testing.TB, app *tests.TestApp, e *core.ServeEvent) {
+				membership, err := app.FindRecordById("familyMembers", lukeMembershipId)
+				require.NoError(t, err)
+				membership.Set("role", "member")
+				require.NoError(t, app.Save(membership))
+			},
+			ExpectedStatus:     http.StatusNotFound,
+			ExpectedContent:    []string{`Invite link expired`},
+			NotExpectedContent: []string{`Skywalkers`, `tribetracker://`},
+			TestAppFactory:     setupTestApp,
+		},
+	}
+
+	for _, scenario := range scenarios {
+		scenario.Test(t)
+	}
+}
@@ -0,0 +1,164 @@
+package handlers
+
+import (
+	"encoding/json"
+	"slices"
+	"time"
+
+	validation "github.com/go-ozzo/ozzo-validation/v4"
+	"github.com/ian-shakespeare/tribe-tracker/server/internal/database"
+	"github.com/ian-shakespeare/tribe-tracker/server/internal/geo"
+	"github.com/ian-shakespeare/tribe-tracker/server/pkg/models"
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/tools/types"
+)
+
+const (
+	// locationMaxAge is how long ago a location can have been recorded, so a
+	// device that was offline for a while can still push its backlog.
+	locationMaxAge = 7 * 24 * time.Hour
+
+	// locationClockSkew is how far ahead of the server a device's clock can
+	// be. Locations recorded within it are stored as recorded now.
+	locationClockSkew = time.Minute
+
+	// locationPruneSchedule is the cron schedule of the job deleting the
+	// locations past their retention.
+	locationPruneSchedule = "30 3 * * *"
+)
+
+// precisions lists the sharing precisions from the most to the least precise.
+var precisions = []string{models.PrecisionExact, models.PrecisionApproximate, models.PrecisionCity}
+
+// precisionDecimals is the number of decimals of a degree coordinates are
+// rounded to when shared with a precision: about a kilometer for approximate
+// and ten for city.
+var precisionDecimals = map[string]int{
+	models.PrecisionApproximate: 2,
+	models.PrecisionCity:        1,
+}
+
+// recordedAtRule validates when a location was recorded.
+var recordedAtRule = validation.By(func(value any) error {
+	recordedAt, _ := value.(types.DateTime)
+	if recordedAt.IsZero() {
+		return nil
+	}
+
+	now := time.Now()
+	if recordedAt.Time().After(now.Add(locationClockSkew)) {
+		return validation.NewError("validation_recorded_in_future", "Can't be in the future.")
+	}
+
+	if recordedAt.Time().Before(now.Add(-locationMaxAge)) {
+		return validation.NewError("validation_recorded_too_long_ago", "Can't be more than 7 days ago.")
+	}
+
+	return nil
+})
+
+// recordedAt returns when a validated location was recorded, defaulting to
+// now and never in the future.
+func recordedAt(value types.DateTime) types.DateTime {
+	now := types.NowDateTime()
+	if value.IsZero() || value.After(now) {
+		return now
+	}
+
+	return value
+}
+
+// checkLocationRecordedAt validates the recordedAt of locations created
+// through the records API.
+func checkLocationRecordedAt(e *core.RecordRequestEvent) error {
+	value := e.Record.GetDateTime("recordedAt")
+	if err := recordedAtRule.Validate(value); err != nil {
+		return e.BadRequestError("Invalid recordedAt.", validation.Errors{"recordedAt": err})
+	}
+
+	e.Record.Set("recordedAt", recordedAt(value))
+
+	return e.Next()
+}
+
+// defaultRecordedAt stamps locations saved without a recordedAt as recorded
+// when they were created.
+func defaultRecordedAt(e *core.RecordEvent) error {
+	if e.Record.GetDateTime("recordedAt").IsZero() {
+		createdAt := e.Record.GetDateTime("createdAt")
+		if createdAt.IsZero() {
+			createdAt = types.NowDateTime()
+		}
+
+		e.Record.Set("recordedAt", createdAt)
+	}
+
+	return e.Next()
+}
+
+// applyPrecision rounds the coordinates of the locations to the precision
+// their user's location is shared with.
+func applyPrecision(locations []models.Location, precision func(userId string) string) error {
+	for i, location := range locations {
+		decimals, ok := precisionDecimals[precision(location.User)]
+		if !ok {
+			continue
+		}
+
+		var point types.GeoPoint
+		if err := point.Scan(location.Coordinates); err != nil {
+			return err
+		}
+
+		coordinates, err := json.Marshal(geo.Round(point, decimals))
+		if err != nil {
+			return err
+		}
+
+		locations[i].Coordinates = string(coordinates)
+	}
+
+	return nil
+}
+
+// familyPrecision shares every location with the family's precision.
+func familyPrecision(family models.Family) func(string) string {
+	return func(string) string {
+		return family.SharingPrecision
+	}
+}
+
+// memberPrecision shares the user's own location exactly and the other
+// members' with the most precise of the precisions of the families they share.
+// Members sharing no family with the user are shown with the least precise.
+func memberPrecision(userId string, shared map[string][]string) func(string) string {
+	return func(memberId string) string {
+		if memberId == userId {
+			return models.PrecisionExact
+		}
+
+		for _, precision := range precisions {
+			if slices.Contains(shared[memberId], precision) {
+				return precision
+			}
+		}
+
+		return precisions[len(precisions)-1]
+	}
+}
+
+// pruneLocations deletes the locations recorded longer ago than the location
+// retention.
+func pruneLocations(app core.App) {
+	days := appConfig(app).Retention.LocationDays
+	if days <= 0 {
+		return
+	}
+
+	count, err := database.DeleteLocationsBefore(app.DB(), time.Now().AddDate(0, 0, -days))
+	if err != nil {
+		app.Logger().Error("Failed to prune locations", "error", err)
+	} else if count > 0 {
+		app.Logger().Info("Pruned locations", "count", count)
+	}
+}
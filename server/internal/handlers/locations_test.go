@@ -0,0 +1,115 @@
+package handlers_test
+
+import (
+	"database/sql"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ian-shakespeare/tribe-tracker/server/internal/config"
+	"github.com/ian-shakespeare/tribe-tracker/server/internal/handlers"
+	"github.com/pocketbase/pocketbase/tests"
+	"github.com/pocketbase/pocketbase/tools/types"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLocationRecordedAt(t *testing.T) {
+	token := generateToken(t, "users", "luke.skywalker@email.com")
+
+	body := func(recordedAt time.Time) string {
+		return `{"user":"` + lukeId + `","coordinates":{"lon":8.99,"lat":33.47},"recordedAt":"` + recordedAt.UTC().Format(time.RFC3339) + `"}`
+	}
+
+	recordedAt := time.Now().Add(-time.Hour).UTC().Truncate(time.Second)
+
+	path := "/api/collections/locations/records"
+	scenarios := []tests.ApiScenario{
+		{
+			Name:   "recorded now",
+			Method: http.MethodPost,
+			URL:    path,
+			Body:   strings.NewReader(`{"user":"` + lukeId + `","coordinates":{"lon":8.99,"lat":33.47}}`),
+			Headers: map[string]string{
+				"Authorization": token,
+				"Content-Type":  "application/json",
+			},
+			ExpectedStatus:  http.StatusOK,
+			ExpectedContent: []string{`"recordedAt":"20`},
+			TestAppFactory:  setupTestApp,
+		},
+		{
+			Name:   "recorded offline",
+			Method: http.MethodPost,
+			URL:    path,
+			Body:   strings.NewReader(body(recordedAt)),
+			Headers: map[string]string{
+				"Authorization": token,
+				"Content-Type":  "application/json",
+			},
+			ExpectedStatus:  http.StatusOK,
+			ExpectedContent: []string{`"recordedAt":"` + recordedAt.Format(types.DefaultDateLayout) + `"`},
+			TestAppFactory:  setupTestApp,
+		},
+		{
+			Name:   "recorded in the future",
+			Method: http.MethodPost,
+			URL:    path,
+			Body:   strings.NewReader(body(time.Now().Add(time.Hour))),
+			Headers: map[string]string{
+				"Authorization": token,
+				"Content-Type":  "application/json",
+			},
+			ExpectedStatus:  http.StatusBadRequest,
+			ExpectedContent: []string{`"recordedAt":{"code":"validation_recorded_in_future"`},
+			TestAppFactory:  setupTestApp,
+		},
+		{
+			Name:   "recorded too long ago",
+			Method: http.MethodPost,
+			URL:    path,
+			Body:   strings.NewReader(body(time.Now().Add(-30 * 24 * time.Hour))),
+			Headers: map[string]string{
+				"Authorization": token,
+				"Content-Type":  "application/json",
+			},
+			ExpectedStatus:  http.StatusBadRequest,
+			ExpectedContent: []string{`"recordedAt":{"code":"validation_recorded_too_long_ago"`},
+			TestAppFactory:  setupTestApp,
+		},
+	}
+
+	for _, scenario := range scenarios {
+		scenario.Test(t)
+	}
+}
+
+func TestPruneLocations(t *testing.T) {
+	app, err := tests.NewTestApp(testDataDir)
+	require.NoError(t, err)
+	defer app.Cleanup()
+
+	cfg := config.Default()
+	cfg.Retention.LocationDays = 30
+	handlers.Bind(app, cfg)
+
+	seedRecords(t, app, "locations",
+		map[string]any{"id": "recentlocation1", "user": lukeId, "coordinates": types.GeoPoint{Lon: 8.99, Lat: 33.47}, "recordedAt": time.Now().AddDate(0, 0, -29)},
+		map[string]any{"id": "stalelocation01", "user": lukeId, "coordinates": types.GeoPoint{Lon: 8.99, Lat: 33.47}, "recordedAt": time.Now().AddDate(0, 0, -31)},
+	)
+
+	var ran bool
+	for _, job := range app.Cron().Jobs() {
+		if job.Id() == "pruneLocations" {
+			job.Run()
+			ran = true
+		}
+	}
+	require.True(t, ran)
+
+	_, err = app.FindRecordById("locations", "recentlocation1")
+	require.NoError(t, err)
+
+	_, err = app.FindRecordById("locations", "stalelocation01")
+	require.ErrorIs(t, err, sql.ErrNoRows)
+}
@@ -0,0 +1,401 @@
+package handlers
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+
+	validation "github.com/go-ozzo/ozzo-validation/v4"
+	"github.com/ian-shakespeare/tribe-tracker/server/internal/database"
+	"github.com/ian-shakespeare/tribe-tracker/server/pkg/models"
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/tools/types"
+)
+
+// pushMaxMutations caps the size of a single push so one request can't hold
+// the write transaction for too long.
+const pushMaxMutations = 100
+
+// Mutation types accepted by /mobile/sync/push.
+const (
+	mutationUpdateProfile  = "updateProfile"
+	mutationRenameFamily   = "renameFamily"
+	mutationLeaveFamily    = "leaveFamily"
+	mutationCreateLocation = "createLocation"
+)
+
+// Outcome of a single mutation.
+const (
+	pushApplied  = "applied"
+	pushConflict = "conflict"
+	pushRejected = "rejected"
+	// pushAborted mutations were valid but rolled back because another
+	// mutation in the batch failed.
+	pushAborted = "aborted"
+)
+
+// errPushRejected rolls back a push in which at least one mutation failed.
+var errPushRejected = errors.New("push rejected")
+
+type pushRequest struct {
+	Mutations []pushMutation `json:"mutations"`
+}
+
+// pushMutation is a change made offline by the client.
+type pushMutation struct {
+	Type string `json:"type"`
+
+	// ID is the id of the mutated record: the user for updateProfile, the
+	// family for renameFamily and leaveFamily, and the client generated id of
+	// the new location for createLocation.
+	ID string `json:"id"`
+
+	// BaseVersion is the version of the record the client edited, as last
+	// received through sync. It is required to update existing records.
+	BaseVersion *int `json:"baseVersion"`
+
+	Data json.RawMessage `json:"data"`
+}
+
+func (r *pushRequest) normalize() {
+	for i := range r.Mutations {
+		r.Mutations[i].Type = strings.TrimSpace(r.Mutations[i].Type)
+		r.Mutations[i].ID = strings.TrimSpace(r.Mutations[i].ID)
+	}
+}
+
+func (r *pushRequest) validate(app core.App) error {
+	return validation.ValidateStruct(r,
+		validation.Field(&r.Mutations, validation.Required, validation.Length(1, pushMaxMutations)),
+	)
+}
+
+type updateProfileData struct {
+	FirstName *string `json:"firstName"`
+	LastName  *string `json:"lastName"`
+}
+
+func (d *updateProfileData) normalize() {
+	if d.FirstName != nil {
+		*d.FirstName = strings.TrimSpace(*d.FirstName)
+	}
+	if d.LastName != nil {
+		*d.LastName = strings.TrimSpace(*d.LastName)
+	}
+}
+
+func (d *updateProfileData) validate(app core.App) error {
+	return validation.ValidateStruct(d,
+		validation.Field(&d.FirstName, collectionField(app, "users", "firstName")),
+		validation.Field(&d.LastName, collectionField(app, "users", "lastName")),
+	)
+}
+
+type renameFamilyData struct {
+	Name string `json:"name"`
+}
+
+func (d *renameFamilyData) normalize() {
+	d.Name = strings.TrimSpace(d.Name)
+}
+
+func (d *renameFamilyData) validate(app core.App) error {
+	return validation.ValidateStruct(d,
+		validation.Field(&d.Name, collectionField(app, "families", "name")),
+	)
+}
+
+type createLocationData struct {
+	Coordinates *types.GeoPoint `json:"coordinates"`
+	Device      string          `json:"device"`
+	// RecordedAt is when the device recorded the location, defaulting to
+	// when it is pushed.
+	RecordedAt types.DateTime `json:"recordedAt"`
+}
+
+func (d *createLocationData) normalize() {
+	d.Device = strings.TrimSpace(d.Device)
+}
+
+func (d *createLocationData) validate(app core.App) error {
+	return validation.ValidateStruct(d,
+		validation.Field(&d.Coordinates, validation.NotNil, collectionField(app, "locations", "coordinates")),
+		validation.Field(&d.RecordedAt, recordedAtRule),
+	)
+}
+
+type pushResult struct {
+	ID     string         `json:"id"`
+	Type   string         `json:"type"`
+	Status string         `json:"status"`
+	Error  *ErrorResponse `json:"error,omitempty"`
+
+	// Record is the server's copy of the record: the new state if the
+	// mutation was applied, or the current state on a conflict.
+	Record any `json:"record,omitempty"`
+}
+
+// pushSyncData applies a batch of client mutations in a single transaction.
+// Either every mutation is applied or none are, and the response reports the
+// outcome of each so offline edits can be retried or rebased on conflicts.
+// Locations over the rate quota are the exception: they are rejected on their
+// own, or a backlog of offline locations could never be pushed. Their ids are
+// listed in the response's rejected field and Retry-After tells when to push
+// them again.
+func pushSyncData(e *core.RequestEvent) error {
+	userId := e.Auth.Id
+	deviceId := e.Request.Header.Get(DeviceHeader)
+
+	var req pushRequest
+	if err := readBody(e, &req); err != nil {
+		return err
+	}
+
+	results := make([]pushResult, len(req.Mutations))
+	rejected := []string{}
+	err := e.App.RunInTransaction(func(txApp core.App) error {
+		failed := false
+		rejected = rejected[:0]
+
+		for i, mutation := range req.Mutations {
+			record, err := applyMutation(txApp, userId, deviceId, mutation)
+
+			result := pushResult{
+				ID:     mutation.ID,
+				Type:   mutation.Type,
+				Status: pushApplied,
+				Record: record,
+			}
+
+			if err != nil {
+				apiErr := fromSaveError(err, "Failed to apply mutation.")
+				if apiErr.status >= http.StatusInternalServerError {
+					return apiErr
+				}
+
+				// locations over the rate quota are dropped without failing
+				// the batch
+				dropped := mutation.Type == mutationCreateLocation && apiErr.response.Code == CodeTooManyRequests
+				failed = failed || !dropped
+				if dropped {
+					rejected = append(rejected, mutation.ID)
+				}
+
+				result.Status = pushRejected
+				if apiErr.response.Code == CodeConflict {
+					result.Status = pushConflict
+				}
+
+				response := apiErr.response
+				result.Record, response.Current = response.Current, nil
+				result.Error = &response
+			}
+
+			results[i] = result
+		}
+
+		if failed {
+			return errPushRejected
+		}
+
+		return nil
+	})
+	if err != nil && !errors.Is(err, errPushRejected) {
+		return err
+	}
+
+	applied := err == nil
+	if !applied {
+		rejected = []string{}
+		for i := range results {
+			if results[i].Status == pushApplied {
+				results[i].Status = pushAborted
+				results[i].Record = nil
+			}
+		}
+	}
+
+	if len(rejected) > 0 {
+		if retryAfter, _ := checkLocationRate(e.App, userId); retryAfter > 0 {
+			e.Response.Header().Set("Retry-After", retryAfterSeconds(retryAfter))
+		}
+	}
+
+	if err := setFileToken(e); err != nil {
+		return err
+	}
+
+	for i := range results {
+		if user, ok := results[i].Record.(models.User); ok {
+			resolveUserAvatar(e.App, &user)
+			results[i].Record = user
+		}
+	}
+
+	var res struct {
+		Applied bool         `json:"applied"`
+		Results []pushResult `json:"results"`
+		// Rejected lists the mutations left out of an applied push, which
+		// the client has to push again.
+		Rejected []string `json:"rejected"`
+	}
+	res.Applied = applied
+	res.Results = results
+	res.Rejected = rejected
+
+	return e.JSON(http.StatusOK, res)
+}
+
+func applyMutation(app core.App, userId, deviceId string, mutation pushMutation) (any, error) {
+	switch mutation.Type {
+	case mutationUpdateProfile:
+		return updateProfile(app, userId, mutation)
+	case mutationRenameFamily:
+		return renameFamily(app, userId, mutation)
+	case mutationLeaveFamily:
+		return nil, leaveFamily(app, userId, mutation)
+	case mutationCreateLocation:
+		return createLocation(app, userId, deviceId, mutation)
+	default:
+		return nil, validationFailed(map[string]string{"type": "Unknown mutation type."}, nil)
+	}
+}
+
+// decodeMutationData decodes, normalizes and validates the mutation's data.
+func decodeMutationData(app core.App, mutation pushMutation, dst requestBody) error {
+	data := mutation.Data
+	if len(data) == 0 {
+		data = json.RawMessage("{}")
+	}
+
+	return decodeBody(app, bytes.NewReader(data), dst)
+}
+
+func requireBaseVersion(mutation pushMutation) error {
+	if mutation.BaseVersion == nil {
+		return validationFailed(map[string]string{"baseVersion": "Required to update existing records."}, nil)
+	}
+
+	return nil
+}
+
+func updateProfile(app core.App, userId string, mutation pushMutation) (any, error) {
+	if mutation.ID != userId {
+		return nil, forbidden("You can only edit your own profile.", nil)
+	}
+
+	var data updateProfileData
+	if err := decodeMutationData(app, mutation, &data); err != nil {
+		return nil, err
+	}
+
+	user, err := database.GetUser(app.DB(), userId)
+	if err != nil {
+		return nil, internalError("Failed to get user data.", err)
+	}
+
+	if err := requireBaseVersion(mutation); err != nil {
+		return nil, err
+	} else if *mutation.BaseVersion != user.Version {
+		return nil, versionConflict("The profile was changed by another device.", user)
+	}
+
+	if data.FirstName != nil {
+		user.FirstName = *data.FirstName
+	}
+	if data.LastName != nil {
+		user.LastName = *data.LastName
+	}
+
+	return database.UpdateUser(app, user)
+}
+
+func renameFamily(app core.App, userId string, mutation pushMutation) (any, error) {
+	var data renameFamilyData
+	if err := decodeMutationData(app, mutation, &data); err != nil {
+		return nil, err
+	}
+
+	if err := requireBaseVersion(mutation); err != nil {
+		return nil, err
+	}
+
+	return editFamily(app, userId, mutation.ID, *mutation.BaseVersion, func(family *models.Family) {
+		family.Name = data.Name
+	})
+}
+
+func leaveFamily(app core.App, userId string, mutation pushMutation) error {
+	familyMember, err := findMembership(app, mutation.ID, userId)
+	if err != nil {
+		return err
+	}
+
+	if familyMember.Role == models.RoleOwner {
+		return forbidden("The owner can't leave their family.", nil)
+	}
+
+	if err := database.DeleteFamilyMember(app, familyMember.ID); err != nil {
+		return err
+	}
+
+	return audit(app, familyMember.Family, userId, models.AuditMemberLeft, userId, map[string]any{
+		"role": familyMember.Role,
+	})
+}
+
+func createLocation(app core.App, userId, deviceId string, mutation pushMutation) (any, error) {
+	err := validation.Validate(mutation.ID, validation.Required, collectionField(app, "locations", "id"))
+	if err != nil {
+		return nil, validationFailed(map[string]string{"id": err.Error()}, err)
+	}
+
+	var data createLocationData
+	if err := decodeMutationData(app, mutation, &data); err != nil {
+		return nil, err
+	}
+
+	// Pushes are retried until the client sees a response, so a location
+	// that already exists was most likely created by an earlier attempt.
+	existing, err := database.GetLocation(app.DB(), mutation.ID)
+	if err == nil {
+		if existing.User != userId {
+			return nil, conflict("A location with that id already exists.", nil)
+		}
+
+		return existing, nil
+	} else if !errors.Is(err, sql.ErrNoRows) {
+		return nil, internalError("Failed to get location data.", err)
+	}
+
+	if _, err := checkLocationRate(app, userId); err != nil {
+		return nil, err
+	}
+
+	if data.Device == "" {
+		data.Device = deviceId
+	}
+
+	if data.Device != "" {
+		device, err := findActiveDevice(app, userId, data.Device)
+		if err != nil {
+			return nil, err
+		}
+
+		if !device.SharesLocation {
+			return nil, forbidden("This device doesn't share its location.", nil)
+		}
+	}
+
+	location := models.Location{
+		ID:         mutation.ID,
+		User:       userId,
+		Device:     data.Device,
+		RecordedAt: recordedAt(data.RecordedAt),
+	}
+
+	return database.CreateLocation(app, location, *data.Coordinates)
+}
@@ -0,0 +1,373 @@
+package handlers_test
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ian-shakespeare/tribe-tracker/server/internal/handlers"
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/tests"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	lukeId = "pjrriu6noxafz76"
+	leiaId = "bcruhrwalqnwncy"
+)
+
+func TestPushSyncData(t *testing.T) {
+	luke := generateToken(t, "users", "luke.skywalker@email.com")
+
+	// a backlog of locations recorded offline
+	offlineLocations := make([]string, 13)
+	for i := range offlineLocations {
+		offlineLocations[i] = fmt.Sprintf(`{"type":"createLocation","id":"offlineloc%05d","data":{"coordinates":{"lat":33.47,"lon":8.99}}}`, i)
+	}
+	leia := generateToken(t, "users", "leia.organa@email.com")
+
+	recordedAt := time.Now().Add(-2 * time.Hour).UTC().Truncate(time.Millisecond)
+
+	path := "/mobile/sync/push"
+	scenarios := []tests.ApiScenario{
+		{
+			Name:            "unauthorized",
+			Method:          http.MethodPost,
+			URL:             path,
+			Body:            strings.NewReader(`{"mutations":[]}`),
+			ExpectedStatus:  http.StatusUnauthorized,
+			ExpectedContent: []string{`"code":"unauthorized"`},
+			TestAppFactory:  setupTestApp,
+		},
+		{
+			Name:   "no mutations",
+			Method: http.MethodPost,
+			URL:    path,
+			Body:   strings.NewReader(`{"mutations":[]}`),
+			Headers: map[string]string{
+				"Authorization": luke,
+			},
+			ExpectedStatus:  http.StatusBadRequest,
+			ExpectedContent: []string{`"code":"validation_failed"`, `"mutations":`},
+			TestAppFactory:  setupTestApp,
+		},
+		{
+			Name:   "applied",
+			Method: http.MethodPost,
+			URL:    path,
+			Body: strings.NewReader(`{"mutations":[
+				{"type":"updateProfile","id":"` + lukeId + `","baseVersion":1,"data":{"firstName":" Luke "}},
+				{"type":"renameFamily","id":"` + skywalkersId + `","baseVersion":1,"data":{"name":"Skywalker Clan"}},
+				{"type":"createLocation","id":"offlinelocation","data":{"coordinates":{"lat":33.47,"lon":8.99}}}
+			]}`),
+			Headers: map[string]string{
+				"Authorization": luke,
+			},
+			ExpectedStatus: http.StatusOK,
+			ExpectedContent: []string{
+				`"applied":true`,
+				`"firstName":"Luke"`,
+				`"name":"Skywalker Clan"`,
+				`"version":2`,
+				`"id":"offlinelocation"`,
+				`"avatarUrl":"http://localhost:8090/api/files/users/` + lukeId + `/luke_lii4ry6x0q.jpeg"`,
+			},
+			NotExpectedContent: []string{`"status":"rejected"`, `"status":"conflict"`, `token=`},
+			TestAppFactory:     setupTestApp,
+			AfterTestFunc: func(t testing.TB, app *tests.TestApp, res *http.Response) {
+				require.NotEmpty(t, res.Header.Get(handlers.FileTokenHeader))
+
+				family, err := app.FindRecordById("families", skywalkersId)
+				require.NoError(t, err)
+				require.Equal(t, "Skywalker Clan", family.GetString("name"))
+
+				location, err := app.FindRecordById("locations", "offlinelocation")
+				require.NoError(t, err)
+				require.Equal(t, lukeId, location.GetString("user"))
+				require.False(t, location.GetDateTime("recordedAt").IsZero())
+			},
+		},
+		{
+			Name:   "location recorded offline",
+			Method: http.MethodPost,
+			URL:    path,
+			Body: strings.NewReader(`{"mutations":[
+				{"type":"createLocation","id":"offlinelocation","data":{"coordinates":{"lat":33.47,"lon":8.99},"recordedAt":"` + recordedAt.Format(time.RFC3339Nano) + `"}}
+			]}`),
+			Headers: map[string]string{
+				"Authorization": luke,
+			},
+			ExpectedStatus:  http.StatusOK,
+			ExpectedContent: []string{`"applied":true`, `"rejected":[]`},
+			TestAppFactory:  setupTestApp,
+			AfterTestFunc: func(t testing.TB, app *tests.TestApp, res *http.Response) {
+				location, err := app.FindRecordById("locations", "offlinelocation")
+				require.NoError(t, err)
+				require.True(t, recordedAt.Equal(location.GetDateTime("recordedAt").Time()))
+			},
+		},
+		{
+			Name:   "location recorded in the future",
+			Method: http.MethodPost,
+			URL:    path,
+			Body: strings.NewReader(`{"mutations":[
+				{"type":"createLocation","id":"offlinelocation","data":{"coordinates":{"lat":33.47,"lon":8.99},"recordedAt":"` + time.Now().Add(time.Hour).Format(time.RFC3339) + `"}}
+			]}`),
+			Headers: map[string]string{
+				"Authorization": luke,
+			},
+			ExpectedStatus:  http.StatusOK,
+			ExpectedContent: []string{`"applied":false`, `"status":"rejected"`, `"recordedAt":"Can't be in the future."`},
+			TestAppFactory:  setupTestApp,
+		},
+		{
+			Name:   "location recorded too long ago",
+			Method: http.MethodPost,
+			URL:    path,
+			Body: strings.NewReader(`{"mutations":[
+				{"type":"createLocation","id":"offlinelocation","data":{"coordinates":{"lat":33.47,"lon":8.99},"recordedAt":"` + time.Now().Add(-30*24*time.Hour).Format(time.RFC3339) + `"}}
+			]}`),
+			Headers: map[string]string{
+				"Authorization": luke,
+			},
+			ExpectedStatus:  http.StatusOK,
+			ExpectedContent: []string{`"applied":false`, `"status":"rejected"`, `"recordedAt":"Can't be more than 7 days ago."`},
+			TestAppFactory:  setupTestApp,
+		},
+		{
+			Name:   "stale version",
+			Method: http.MethodPost,
+			URL:    path,
+			Body: strings.NewReader(`{"mutations":[
+				{"type":"renameFamily","id":"` + skywalkersId + `","baseVersion":1,"data":{"name":"Skywalker Clan"}}
+			]}`),
+			Headers: map[string]string{
+				"Authorization": luke,
+			},
+			ExpectedStatus:     http.StatusOK,
+			ExpectedContent:    []string{`"applied":false`, `"status":"conflict"`, `"name":"Jedi Order"`, `"version":2`},
+			NotExpectedContent: []string{`"current":`},
+			TestAppFactory: func(t testing.TB) *tests.TestApp {
+				app := setupTestApp(t)
+
+				family, err := app.FindRecordById("families", skywalkersId)
+				require.NoError(t, err)
+
+				family.Set("name", "Jedi Order")
+				require.NoError(t, app.Save(family))
+
+				return app
+			},
+		},
+		{
+			Name:   "missing version",
+			Method: http.MethodPost,
+			URL:    path,
+			Body: strings.NewReader(`{"mutations":[
+				{"type":"updateProfile","id":"` + lukeId + `","data":{"firstName":"Luke"}}
+			]}`),
+			Headers: map[string]string{
+				"Authorization": luke,
+			},
+			ExpectedStatus:  http.StatusOK,
+			ExpectedContent: []string{`"status":"rejected"`, `"baseVersion":`},
+			TestAppFactory:  setupTestApp,
+		},
+		{
+			Name:   "one invalid mutation aborts the batch",
+			Method: http.MethodPost,
+			URL:    path,
+			Body: strings.NewReader(`{"mutations":[
+				{"type":"createLocation","id":"offlinelocation","data":{"coordinates":{"lat":33.47,"lon":8.99}}},
+				{"type":"renameFamily","id":"` + skywalkersId + `","baseVersion":1,"data":{"name":"S"}},
+				{"type":"deleteEverything","id":"` + skywalkersId + `"}
+			]}`),
+			Headers: map[string]string{
+				"Authorization": luke,
+			},
+			ExpectedStatus: http.StatusOK,
+			ExpectedContent: []string{
+				`"applied":false`,
+				`"status":"aborted"`,
+				`"status":"rejected"`,
+				`"name":`,
+				`"type":"Unknown mutation type."`,
+			},
+			TestAppFactory: setupTestApp,
+			AfterTestFunc: func(t testing.TB, app *tests.TestApp, res *http.Response) {
+				_, err := app.FindRecordById("locations", "offlinelocation")
+				require.Error(t, err)
+			},
+		},
+		{
+			Name:   "rename without role",
+			Method: http.MethodPost,
+			URL:    path,
+			Body: strings.NewReader(`{"mutations":[
+				{"type":"renameFamily","id":"` + skywalkersId + `","baseVersion":1,"data":{"name":"Organas"}}
+			]}`),
+			Headers: map[string]string{
+				"Authorization": leia,
+			},
+			ExpectedStatus:  http.StatusOK,
+			ExpectedContent: []string{`"status":"rejected"`, `"code":"forbidden"`},
+			TestAppFactory:  setupTestApp,
+		},
+		{
+			Name:   "edit another profile",
+			Method: http.MethodPost,
+			URL:    path,
+			Body: strings.NewReader(`{"mutations":[
+				{"type":"updateProfile","id":"` + lukeId + `","baseVersion":1,"data":{"firstName":"Leia"}}
+			]}`),
+			Headers: map[string]string{
+				"Authorization": leia,
+			},
+			ExpectedStatus:  http.StatusOK,
+			ExpectedContent: []string{`"status":"rejected"`, `"code":"forbidden"`},
+			TestAppFactory:  setupTestApp,
+		},
+		{
+			Name:   "leave family",
+			Method: http.MethodPost,
+			URL:    path,
+			Body: strings.NewReader(`{"mutations":[
+				{"type":"leaveFamily","id":"` + skywalkersId + `"}
+			]}`),
+			Headers: map[string]string{
+				"Authorization": leia,
+			},
+			ExpectedStatus:  http.StatusOK,
+			ExpectedContent: []string{`"applied":true`, `"status":"applied"`},
+			TestAppFactory:  setupTestApp,
+			AfterTestFunc: func(t testing.TB, app *tests.TestApp, res *http.Response) {
+				_, err := app.FindFirstRecordByFilter("familyMembers", "family = {:family} && user = {:user}", map[string]any{
+					"family": skywalkersId,
+					"user":   leiaId,
+				})
+				require.Error(t, err)
+			},
+		},
+		{
+			Name:   "owner can't leave",
+			Method: http.MethodPost,
+			URL:    path,
+			Body: strings.NewReader(`{"mutations":[
+				{"type":"leaveFamily","id":"` + skywalkersId + `"}
+			]}`),
+			Headers: map[string]string{
+				"Authorization": luke,
+			},
+			ExpectedStatus:  http.StatusOK,
+			ExpectedContent: []string{`"applied":false`, `"code":"forbidden"`},
+			TestAppFactory:  setupTestApp,
+		},
+		{
+			Name:   "retried location",
+			Method: http.MethodPost,
+			URL:    path,
+			Body: strings.NewReader(`{"mutations":[
+				{"type":"createLocation","id":"offlinelocation","data":{"coordinates":{"lat":33.47,"lon":8.99}}}
+			]}`),
+			Headers: map[string]string{
+				"Authorization": luke,
+			},
+			ExpectedStatus:  http.StatusOK,
+			ExpectedContent: []string{`"applied":true`, `"id":"offlinelocation"`},
+			TestAppFactory: func(t testing.TB) *tests.TestApp {
+				app := setupTestApp(t)
+
+				locations, err := app.FindCollectionByNameOrId("locations")
+				require.NoError(t, err)
+
+				location := core.NewRecord(locations)
+				location.Id = "offlinelocation"
+				location.Set("user", lukeId)
+				location.Set("coordinates", map[string]float64{"lat": 33.47, "lon": 8.99})
+				require.NoError(t, app.Save(location))
+
+				return app
+			},
+		},
+		{
+			Name:   "location id taken by another user",
+			Method: http.MethodPost,
+			URL:    path,
+			Body: strings.NewReader(`{"mutations":[
+				{"type":"createLocation","id":"si098aybzuh2ko5","data":{"coordinates":{"lat":33.47,"lon":8.99}}}
+			]}`),
+			Headers: map[string]string{
+				"Authorization": leia,
+			},
+			ExpectedStatus:     http.StatusOK,
+			ExpectedContent:    []string{`"applied":false`, `"status":"conflict"`},
+			NotExpectedContent: []string{`"record":`},
+			TestAppFactory:     setupTestApp,
+		},
+		{
+			Name:   "location from a device that doesn't share location",
+			Method: http.MethodPost,
+			URL:    path,
+			Body: strings.NewReader(`{"mutations":[
+				{"type":"createLocation","id":"offlinelocation","data":{"coordinates":{"lat":33.47,"lon":8.99}}}
+			]}`),
+			Headers: map[string]string{
+				"Authorization":       luke,
+				handlers.DeviceHeader: lukeDisplayId,
+			},
+			ExpectedStatus:  http.StatusOK,
+			ExpectedContent: []string{`"applied":false`, `"code":"forbidden"`},
+			TestAppFactory:  setupDeviceTestApp,
+		},
+		{
+			Name:   "location tagged with device",
+			Method: http.MethodPost,
+			URL:    path,
+			Body: strings.NewReader(`{"mutations":[
+				{"type":"createLocation","id":"offlinelocation","data":{"coordinates":{"lat":33.47,"lon":8.99}}}
+			]}`),
+			Headers: map[string]string{
+				"Authorization":       luke,
+				handlers.DeviceHeader: lukePhoneId,
+			},
+			ExpectedStatus:  http.StatusOK,
+			ExpectedContent: []string{`"applied":true`, `"device":"` + lukePhoneId + `"`},
+			TestAppFactory:  setupDeviceTestApp,
+		},
+		{
+			Name:   "locations over the rate quota",
+			Method: http.MethodPost,
+			URL:    path,
+			Body: strings.NewReader(`{"mutations":[` + strings.Join(offlineLocations, ",") + `,
+				{"type":"renameFamily","id":"` + skywalkersId + `","baseVersion":1,"data":{"name":"Skywalker Clan"}}
+			]}`),
+			Headers: map[string]string{
+				"Authorization": luke,
+			},
+			ExpectedStatus: http.StatusOK,
+			ExpectedContent: []string{
+				`"applied":true`,
+				`"status":"rejected"`,
+				`"code":"too_many_requests"`,
+				`"name":"Skywalker Clan"`,
+				`"rejected":["offlineloc00012"]`,
+			},
+			TestAppFactory: setupTestApp,
+			AfterTestFunc: func(t testing.TB, app *tests.TestApp, res *http.Response) {
+				require.NotEmpty(t, res.Header.Get("Retry-After"))
+
+				// only the locations past the quota of 12 per minute are dropped
+				locations, err := app.FindAllRecords("locations", dbx.Like("id", "offlineloc").Match(false, true))
+				require.NoError(t, err)
+				require.Len(t, locations, 12)
+			},
+		},
+	}
+
+	for _, scenario := range scenarios {
+		scenario.Test(t)
+	}
+}
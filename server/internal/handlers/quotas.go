@@ -0,0 +1,109 @@
+package handlers
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/ian-shakespeare/tribe-tracker/server/internal/database"
+	"github.com/pocketbase/pocketbase/core"
+)
+
+// locationRateWindow is the window LocationsPerMinute is counted over.
+const locationRateWindow = time.Minute
+
+// checkFamiliesQuota fails if the user can't belong to another family.
+func checkFamiliesQuota(app core.App, userId string) error {
+	limit := appConfig(app).Quotas.FamiliesPerUser
+	if limit <= 0 {
+		return nil
+	}
+
+	count, err := database.CountUserFamilies(app.DB(), userId)
+	if err != nil {
+		return internalError("Failed to get family data.", err)
+	}
+
+	if count >= limit {
+		return quotaExceeded(fmt.Sprintf("You can't belong to more than %d families.", limit))
+	}
+
+	return nil
+}
+
+// checkMembersQuota fails if the family can't take another member.
+func checkMembersQuota(app core.App, familyId string) error {
+	limit := appConfig(app).Quotas.MembersPerFamily
+	if limit <= 0 {
+		return nil
+	}
+
+	count, err := database.CountFamilyMembers(app.DB(), familyId)
+	if err != nil {
+		return internalError("Failed to get family member data.", err)
+	}
+
+	if count >= limit {
+		return quotaExceeded(fmt.Sprintf("A family can't have more than %d members.", limit))
+	}
+
+	return nil
+}
+
+// checkJoinQuotas fails if the user can't join the family.
+func checkJoinQuotas(app core.App, familyId, userId string) error {
+	if err := checkMembersQuota(app, familyId); err != nil {
+		return err
+	}
+
+	return checkFamiliesQuota(app, userId)
+}
+
+// checkLocationRate fails if the user recorded too many locations recently,
+// returning how long to wait before the next one is accepted.
+func checkLocationRate(app core.App, userId string) (time.Duration, error) {
+	limit := appConfig(app).Quotas.LocationsPerMinute
+	if limit <= 0 {
+		return 0, nil
+	}
+
+	now := time.Now()
+	count, oldest, err := database.CountRecentLocations(app.DB(), userId, now.Add(-locationRateWindow))
+	if err != nil {
+		return 0, internalError("Failed to get location data.", err)
+	}
+
+	if count < limit {
+		return 0, nil
+	}
+
+	// a slot frees up once the oldest location leaves the window
+	retryAfter := max(oldest.Time().Add(locationRateWindow).Sub(now), time.Second)
+
+	return retryAfter, tooManyRequests(fmt.Sprintf("You can't record more than %d locations per minute.", limit), nil)
+}
+
+// limitLocationRate applies the location rate quota to locations created
+// through the records API.
+func limitLocationRate(e *core.RecordRequestEvent) error {
+	if e.Auth == nil {
+		return e.Next()
+	}
+
+	retryAfter, err := checkLocationRate(e.App, e.Auth.Id)
+	if err != nil {
+		if retryAfter > 0 {
+			e.Response.Header().Set("Retry-After", retryAfterSeconds(retryAfter))
+			return e.TooManyRequestsError(err.Error(), nil)
+		}
+
+		return e.InternalServerError("Failed to get location data.", err)
+	}
+
+	return e.Next()
+}
+
+// retryAfterSeconds formats a Retry-After header value, rounding up.
+func retryAfterSeconds(d time.Duration) string {
+	return strconv.Itoa(int((d + time.Second - 1) / time.Second))
+}
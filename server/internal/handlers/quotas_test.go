@@ -0,0 +1,130 @@
+package handlers_test
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ian-shakespeare/tribe-tracker/server/internal/config"
+	"github.com/ian-shakespeare/tribe-tracker/server/internal/handlers"
+	"github.com/pocketbase/pocketbase/tests"
+	"github.com/stretchr/testify/require"
+)
+
+// setupQuotaTestApp returns an app factory bound with the given quotas, in
+// which Luke just recorded a location.
+func setupQuotaTestApp(quotas config.Quotas) func(t testing.TB) *tests.TestApp {
+	return func(t testing.TB) *tests.TestApp {
+		app, err := tests.NewTestApp(testDataDir)
+		require.NoError(t, err)
+
+		handlers.Bind(app, config.Config{Quotas: quotas})
+
+		seedRecords(t, app, "locations",
+			map[string]any{"user": lukeId, "coordinates": map[string]float64{"lat": 33.47, "lon": 8.99}},
+		)
+
+		return app
+	}
+}
+
+func TestQuotas(t *testing.T) {
+	luke := generateToken(t, "users", "luke.skywalker@email.com")
+	vader := generateToken(t, "users", vaderEmail)
+
+	scenarios := []tests.ApiScenario{
+		{
+			Name:   "families per user",
+			Method: http.MethodPost,
+			URL:    "/mobile/families",
+			Body:   strings.NewReader(`{"name":"Rebels","code":"rebel-alliance"}`),
+			Headers: map[string]string{
+				"Authorization": luke,
+			},
+			ExpectedStatus:  http.StatusForbidden,
+			ExpectedContent: []string{`"code":"quota_exceeded"`},
+			TestAppFactory:  setupQuotaTestApp(config.Quotas{FamiliesPerUser: 1}),
+		},
+		{
+			Name:   "members per family on invite",
+			Method: http.MethodPost,
+			URL:    "/mobile/families/" + skywalkersId + "/invitations",
+			Body:   strings.NewReader(`{"email":"` + grandmaEmail + `"}`),
+			Headers: map[string]string{
+				"Authorization": luke,
+			},
+			ExpectedStatus:  http.StatusForbidden,
+			ExpectedContent: []string{`"code":"quota_exceeded"`},
+			TestAppFactory:  setupQuotaTestApp(config.Quotas{MembersPerFamily: 3}),
+		},
+		{
+			Name:   "members per family on join",
+			Method: http.MethodPost,
+			URL:    "/mobile/families/join",
+			Body:   strings.NewReader(`{"token":"` + joinToken(t, skywalkersId, time.Hour) + `"}`),
+			Headers: map[string]string{
+				"Authorization": vader,
+			},
+			ExpectedStatus:  http.StatusForbidden,
+			ExpectedContent: []string{`"code":"quota_exceeded"`},
+			TestAppFactory:  setupQuotaTestApp(config.Quotas{MembersPerFamily: 3}),
+		},
+		{
+			Name:   "members per family on accept",
+			Method: http.MethodPost,
+			URL:    "/mobile/invitations/" + vaderInvitation + "/accept",
+			Headers: map[string]string{
+				"Authorization": vader,
+			},
+			ExpectedStatus:  http.StatusForbidden,
+			ExpectedContent: []string{`"code":"quota_exceeded"`},
+			TestAppFactory:  setupQuotaTestApp(config.Quotas{MembersPerFamily: 3}),
+		},
+		{
+			Name:   "location rate",
+			Method: http.MethodPost,
+			URL:    "/api/collections/locations/records",
+			Body:   strings.NewReader(`{"user":"` + lukeId + `","coordinates":{"lat":33.47,"lon":8.99}}`),
+			Headers: map[string]string{
+				"Authorization": luke,
+			},
+			ExpectedStatus:  http.StatusTooManyRequests,
+			ExpectedContent: []string{`"status":429`},
+			TestAppFactory:  setupQuotaTestApp(config.Quotas{LocationsPerMinute: 1}),
+			AfterTestFunc: func(t testing.TB, app *tests.TestApp, res *http.Response) {
+				require.NotEmpty(t, res.Header.Get("Retry-After"))
+			},
+		},
+		{
+			Name:   "location rate on push",
+			Method: http.MethodPost,
+			URL:    "/mobile/sync/push",
+			Body: strings.NewReader(`{"mutations":[
+				{"type":"createLocation","id":"offlinelocation","data":{"coordinates":{"lat":33.47,"lon":8.99}}}
+			]}`),
+			Headers: map[string]string{
+				"Authorization": luke,
+			},
+			ExpectedStatus:  http.StatusOK,
+			ExpectedContent: []string{`"status":"rejected"`, `"code":"too_many_requests"`},
+			TestAppFactory:  setupQuotaTestApp(config.Quotas{LocationsPerMinute: 1}),
+		},
+		{
+			Name:   "unlimited",
+			Method: http.MethodPost,
+			URL:    "/api/collections/locations/records",
+			Body:   strings.NewReader(`{"user":"` + lukeId + `","coordinates":{"lat":33.47,"lon":8.99}}`),
+			Headers: map[string]string{
+				"Authorization": luke,
+			},
+			ExpectedStatus:  http.StatusOK,
+			ExpectedContent: []string{`"user":"` + lukeId + `"`},
+			TestAppFactory:  setupQuotaTestApp(config.Quotas{}),
+		},
+	}
+
+	for _, scenario := range scenarios {
+		scenario.Test(t)
+	}
+}
@@ -0,0 +1,47 @@
+package handlers
+
+import (
+	"strings"
+	"time"
+
+	"github.com/ian-shakespeare/tribe-tracker/server/internal/config"
+	"github.com/ian-shakespeare/tribe-tracker/server/internal/ratelimit"
+	"github.com/pocketbase/pocketbase/core"
+)
+
+// newLimiter returns the limiter of a rate limit, or nil if it is disabled.
+func newLimiter(l config.RateLimit) *ratelimit.Limiter {
+	if l.PerMinute <= 0 {
+		return nil
+	}
+
+	return ratelimit.New(l.PerMinute, l.Burst)
+}
+
+// limitRequests returns a middleware rate limiting authenticated users.
+func limitRequests(limits config.RateLimits) func(e *core.RequestEvent) error {
+	syncLimiter := newLimiter(limits.Sync)
+	defaultLimiter := newLimiter(limits.Default)
+
+	return func(e *core.RequestEvent) error {
+		if e.Auth == nil {
+			return e.Next()
+		}
+
+		limiter := defaultLimiter
+		if strings.HasPrefix(e.Request.URL.Path, "/mobile/sync") {
+			limiter = syncLimiter
+		}
+
+		if limiter == nil {
+			return e.Next()
+		}
+
+		if allowed, wait := limiter.Allow(e.Auth.Id, time.Now()); !allowed {
+			e.Response.Header().Set("Retry-After", retryAfterSeconds(wait))
+			return tooManyRequests("Too many requests. Try again later.", nil)
+		}
+
+		return e.Next()
+	}
+}
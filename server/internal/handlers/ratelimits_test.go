@@ -0,0 +1,109 @@
+package handlers_test
+
+import (
+	"net/http"
+	"net/url"
+	"testing"
+
+	"github.com/ian-shakespeare/tribe-tracker/server/internal/config"
+	"github.com/ian-shakespeare/tribe-tracker/server/internal/handlers"
+	"github.com/pocketbase/pocketbase/tests"
+	"github.com/pocketbase/pocketbase/tools/security"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRateLimits(t *testing.T) {
+	luke := generateToken(t, "users", "luke.skywalker@email.com")
+	leia := generateToken(t, "users", "leia.organa@email.com")
+
+	// the limiters live as long as the app, so the scenarios share one
+	app, err := tests.NewTestApp(testDataDir)
+	require.NoError(t, err)
+	defer app.Cleanup()
+
+	handlers.Bind(app, config.Config{
+		RateLimits: config.RateLimits{
+			Sync:    config.RateLimit{PerMinute: 1, Burst: 1},
+			Default: config.RateLimit{PerMinute: 1, Burst: 1},
+		},
+	})
+
+	seedRecords(t, app, "displayTokens",
+		map[string]any{"family": skywalkersId, "createdBy": lukeId, "name": "Kitchen", "tokenHash": security.SHA256(kitchenToken)},
+	)
+
+	sync := "/mobile/sync?after=" + url.QueryEscape("1970-01-01T00:00:00.000Z")
+	snapshot := "/display/families/" + skywalkersId + "/snapshot"
+
+	scenarios := []tests.ApiScenario{
+		{
+			Name:   "first sync",
+			Method: http.MethodGet,
+			URL:    sync,
+			Headers: map[string]string{
+				"Authorization": luke,
+			},
+			ExpectedStatus:  http.StatusOK,
+			ExpectedContent: []string{`"users":[`},
+		},
+		{
+			Name:   "sync over the limit",
+			Method: http.MethodGet,
+			URL:    sync,
+			Headers: map[string]string{
+				"Authorization": luke,
+			},
+			ExpectedStatus:  http.StatusTooManyRequests,
+			ExpectedContent: []string{`"code":"too_many_requests"`},
+			AfterTestFunc: func(t testing.TB, app *tests.TestApp, res *http.Response) {
+				require.Equal(t, "60", res.Header.Get("Retry-After"))
+			},
+		},
+		{
+			Name:   "other routes have their own limit",
+			Method: http.MethodGet,
+			URL:    "/mobile/families/" + skywalkersId,
+			Headers: map[string]string{
+				"Authorization": luke,
+			},
+			ExpectedStatus:  http.StatusOK,
+			ExpectedContent: []string{`"name":"Skywalkers"`},
+		},
+		{
+			Name:   "other users have their own limit",
+			Method: http.MethodGet,
+			URL:    sync,
+			Headers: map[string]string{
+				"Authorization": leia,
+			},
+			ExpectedStatus:  http.StatusOK,
+			ExpectedContent: []string{`"users":[`},
+		},
+		{
+			Name:   "display tokens are exempt",
+			Method: http.MethodGet,
+			URL:    snapshot,
+			Headers: map[string]string{
+				"Authorization": "Bearer " + kitchenToken,
+			},
+			ExpectedStatus:  http.StatusOK,
+			ExpectedContent: []string{`"name":"Skywalkers"`},
+		},
+		{
+			Name:   "display tokens are exempt on repeat",
+			Method: http.MethodGet,
+			URL:    snapshot,
+			Headers: map[string]string{
+				"Authorization": "Bearer " + kitchenToken,
+			},
+			ExpectedStatus:  http.StatusOK,
+			ExpectedContent: []string{`"name":"Skywalkers"`},
+		},
+	}
+
+	for _, scenario := range scenarios {
+		scenario.TestAppFactory = func(t testing.TB) *tests.TestApp { return app }
+		scenario.DisableTestAppCleanup = true
+		scenario.Test(t)
+	}
+}
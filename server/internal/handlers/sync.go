@@ -0,0 +1,272 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/ian-shakespeare/tribe-tracker/server/internal/database"
+	"github.com/ian-shakespeare/tribe-tracker/server/pkg/models"
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/tools/security"
+	"github.com/pocketbase/pocketbase/tools/types"
+)
+
+const (
+	// syncGzipMinLength is the smallest sync response worth compressing;
+	// most background syncs return only empty arrays.
+	syncGzipMinLength = 1024
+
+	// syncCursorMargin is how far behind the start of a sync its cursors
+	// stay. Records are timestamped when saved but only become visible once
+	// their transaction commits, so a record stamped just before a sync can
+	// appear after it with an older timestamp than what the sync returned.
+	syncCursorMargin = 30 * time.Second
+)
+
+// Entity types returned by /mobile/sync.
+const (
+	syncUsers         = "users"
+	syncFamilies      = "families"
+	syncFamilyMembers = "familyMembers"
+	syncLocations     = "locations"
+)
+
+var syncEntities = []string{syncUsers, syncFamilies, syncFamilyMembers, syncLocations}
+
+// syncDeletedFamilyMembers lists the ids of family members removed since the
+// familyMembers cursor, which clients must delete.
+const syncDeletedFamilyMembers = "deletedFamilyMembers"
+
+// syncQuery is the parsed query string of a sync request.
+type syncQuery struct {
+	// families limits the sync to these families, or all of the user's
+	// families if empty.
+	families []string
+
+	// cursors holds the cursor of every included entity type. Each defaults
+	// to the after parameter and can be overridden with <entity>After.
+	cursors map[string]time.Time
+}
+
+func parseSyncQuery(params url.Values) (syncQuery, error) {
+	query := syncQuery{
+		families: splitList(params.Get("families")),
+		cursors:  map[string]time.Time{},
+	}
+
+	include := splitList(params.Get("include"))
+	if len(include) == 0 {
+		include = syncEntities
+	}
+
+	fields := map[string]string{}
+	for _, entity := range include {
+		if !slices.Contains(syncEntities, entity) {
+			fields["include"] = "Unknown entity type " + entity + "."
+			continue
+		}
+
+		param := entity + "After"
+		value := params.Get(param)
+		if value == "" {
+			param = "after"
+			value = params.Get(param)
+		}
+
+		after, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			fields[param] = "Must be an RFC3339 timestamp."
+			continue
+		}
+
+		query.cursors[entity] = after.UTC()
+	}
+
+	if len(fields) > 0 {
+		return syncQuery{}, validationFailed(fields, nil)
+	}
+
+	return query, nil
+}
+
+// key identifies the query for the response ETag.
+func (q syncQuery) key() string {
+	var b strings.Builder
+	b.WriteString(strings.Join(q.families, ","))
+	for _, entity := range syncEntities {
+		if after, ok := q.cursors[entity]; ok {
+			b.WriteString("|" + entity + "=" + after.Format(time.RFC3339Nano))
+		}
+	}
+
+	return b.String()
+}
+
+// splitList splits a comma separated query parameter, dropping empty items.
+func splitList(value string) []string {
+	var items []string
+	for item := range strings.SplitSeq(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+
+	return items
+}
+
+// nextCursor returns the cursor following after given the latest stored
+// datetime, which is held back to syncCursorMargin before the read started.
+// Records between the cursor and the read are sent again by the next sync
+// rather than missed.
+func nextCursor(after time.Time, value string, readAt time.Time) time.Time {
+	t, err := types.ParseDateTime(value)
+	if err != nil {
+		return after
+	}
+
+	next := t.Time()
+	if limit := readAt.Add(-syncCursorMargin); next.After(limit) {
+		next = limit
+	}
+
+	if !next.After(after) {
+		return after
+	}
+
+	return next
+}
+
+func getSyncData(e *core.RequestEvent) error {
+	userId := e.Auth.Id
+
+	query, err := parseSyncQuery(e.Request.URL.Query())
+	if err != nil {
+		return err
+	}
+
+	// sent with 304 responses too, so the cached avatar URLs stay usable
+	if err := setFileToken(e); err != nil {
+		return err
+	}
+
+	res := map[string]any{}
+	cursors := map[string]time.Time{}
+
+	// All reads share one snapshot, so the payload never references records
+	// written between two of the queries.
+	var etag string
+	notModified := false
+	readAt := time.Now().UTC()
+	err = database.ReadTransaction(e.App, func(tx dbx.Builder) error {
+		// The version only changes when data visible to the user does, so an
+		// unchanged sync can be answered without running the sync queries.
+		version, err := database.GetSyncVersion(tx, userId, query.families)
+		if err != nil {
+			return internalError("Failed to get sync version.", err)
+		}
+
+		etag = `"` + security.SHA256(fmt.Sprintf("%s|%+v", query.key(), version))[:32] + `"`
+		if etagMatches(e.Request.Header.Get("If-None-Match"), etag) {
+			notModified = true
+			return nil
+		}
+
+		if after, ok := query.cursors[syncUsers]; ok {
+			users, err := database.GetRecentUsers(tx, userId, query.families, after)
+			if err != nil {
+				return internalError("Failed to get user data.", err)
+			}
+			for i := range users {
+				resolveUserAvatar(e.App, &users[i])
+			}
+			res[syncUsers] = users
+			cursors[syncUsers] = nextCursor(after, version.UsersUpdatedAt, readAt)
+		}
+
+		if after, ok := query.cursors[syncFamilies]; ok {
+			families, err := database.GetRecentFamilies(tx, userId, query.families, after)
+			if err != nil {
+				return internalError("Failed to get family data.", err)
+			}
+			res[syncFamilies] = families
+			cursors[syncFamilies] = nextCursor(after, version.FamiliesUpdatedAt, readAt)
+		}
+
+		if after, ok := query.cursors[syncFamilyMembers]; ok {
+			familyMembers, err := database.GetRecentFamilyMembers(tx, userId, query.families, after)
+			if err != nil {
+				return internalError("Failed to get family member data.", err)
+			}
+			deleted, err := database.GetRecentDeletedFamilyMembers(tx, userId, query.families, after)
+			if err != nil {
+				return internalError("Failed to get family member data.", err)
+			}
+			res[syncFamilyMembers] = familyMembers
+			res[syncDeletedFamilyMembers] = deleted
+			cursors[syncFamilyMembers] = nextCursor(after, max(version.MembersCreatedAt, version.MembersDeletedAt), readAt)
+		}
+
+		if after, ok := query.cursors[syncLocations]; ok {
+			locations, err := database.GetRecentLocations(tx, userId, query.families, after)
+			if err != nil {
+				return internalError("Failed to get location data.", err)
+			}
+			precisions, err := database.GetSharingPrecisions(tx, userId, query.families)
+			if err != nil {
+				return internalError("Failed to get family data.", err)
+			}
+			if err := applyPrecision(locations, memberPrecision(userId, precisions)); err != nil {
+				return internalError("Failed to read location data.", err)
+			}
+			res[syncLocations] = locations
+			cursors[syncLocations] = nextCursor(after, version.LocationsCreatedAt, readAt)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if notModified {
+		return blobWithETag(e, http.StatusNotModified, nil, etag)
+	}
+
+	// syncedAt is the single cursor for clients that don't track one per
+	// entity type.
+	var syncedAt time.Time
+	for _, cursor := range cursors {
+		if cursor.After(syncedAt) {
+			syncedAt = cursor
+		}
+	}
+	res["cursors"] = cursors
+	res["syncedAt"] = syncedAt
+
+	body, err := json.Marshal(res)
+	if err != nil {
+		return internalError("Failed to encode response.", err)
+	}
+
+	return blobWithETag(e, http.StatusOK, body, etag)
+}
+
+// recordDeletedFamilyMember keeps a tombstone of deleted family members, in
+// the same transaction as the delete, for sync to pass on.
+func recordDeletedFamilyMember(e *core.RecordEvent) error {
+	if err := e.Next(); err != nil {
+		return err
+	}
+
+	return database.CreateDeletedFamilyMember(e.App, models.FamilyMember{
+		ID:     e.Record.Id,
+		Family: e.Record.GetString("family"),
+		User:   e.Record.GetString("user"),
+	})
+}
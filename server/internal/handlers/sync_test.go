@@ -0,0 +1,502 @@
+package handlers_test
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/ian-shakespeare/tribe-tracker/server/internal/handlers"
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/tests"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGetSyncData(t *testing.T) {
+	token := generateToken(t, "users", "luke.skywalker@email.com")
+
+	path := "/mobile/sync"
+	scenarios := []tests.ApiScenario{
+		{
+			Name:               "unauthorzed",
+			Method:             http.MethodGet,
+			URL:                path,
+			ExpectedStatus:     http.StatusUnauthorized,
+			ExpectedContent:    []string{`"code":"unauthorized"`},
+			NotExpectedContent: []string{`users`, `families`, `locations`},
+			TestAppFactory:     setupTestApp,
+		},
+		{
+			Name:   "invalid http method",
+			Method: http.MethodPost,
+			URL:    path,
+			Headers: map[string]string{
+				"Authorization": token,
+			},
+			ExpectedStatus:     http.StatusNotFound,
+			NotExpectedContent: []string{"users", "families", "locations"},
+			TestAppFactory:     setupTestApp,
+		},
+		{
+			Name:   "invalid after",
+			Method: http.MethodGet,
+			URL:    path + "?after=yesterday",
+			Headers: map[string]string{
+				"Authorization": token,
+			},
+			ExpectedStatus:  http.StatusBadRequest,
+			ExpectedContent: []string{`"code":"validation_failed"`, `"after":`},
+			TestAppFactory:  setupTestApp,
+		},
+		{
+			Name:   "first sync",
+			Method: http.MethodGet,
+			URL:    path + "?after=" + url.QueryEscape("1970-01-01T00:00:00.000Z"),
+			Headers: map[string]string{
+				"Authorization": token,
+			},
+			ExpectedStatus: http.StatusOK,
+			ExpectedContent: []string{
+				"users",
+				"families",
+				"locations",
+				`"avatarUrl":"http://localhost:8090/api/files/users/bcruhrwalqnwncy/leia_lj1hqkubfd.jpg"`,
+				`"avatarThumbUrl":"http://localhost:8090/api/files/users/bcruhrwalqnwncy/leia_lj1hqkubfd.jpg?thumb=100x100"`,
+			},
+			NotExpectedContent: []string{`users":[]`, `families":[]`, `locations":[]`, `token=`},
+			TestAppFactory:     setupTestApp,
+			AfterTestFunc: func(t testing.TB, app *tests.TestApp, res *http.Response) {
+				require.NotEmpty(t, res.Header.Get(handlers.FileTokenHeader))
+
+				b, _ := io.ReadAll(res.Body)
+				t.Logf("response: %s", string(b))
+			},
+		},
+		{
+			Name:   "approximate precision",
+			Method: http.MethodGet,
+			URL:    path + "?after=" + url.QueryEscape("1970-01-01T00:00:00.000Z"),
+			Headers: map[string]string{
+				"Authorization": token,
+			},
+			ExpectedStatus: http.StatusOK,
+			ExpectedContent: []string{
+				`{\"lon\":9.01,\"lat\":62}`,
+				// the caller's own location stays exact
+				`{\"lon\":8.986816,\"lat\":33.468108}`,
+			},
+			NotExpectedContent: []string{`9.008789`},
+			TestAppFactory: func(t testing.TB) *tests.TestApp {
+				app := setupTestApp(t)
+
+				family, err := app.FindRecordById("families", skywalkersId)
+				require.NoError(t, err)
+				family.Set("sharingPrecision", "approximate")
+				require.NoError(t, app.Save(family))
+
+				return app
+			},
+		},
+		{
+			Name:   "up to date sync",
+			Method: http.MethodGet,
+			URL:    path + "?after=" + url.QueryEscape("2026-02-01T17:51:44.784Z"),
+			Headers: map[string]string{
+				"Authorization": token,
+			},
+			ExpectedStatus:  http.StatusOK,
+			ExpectedContent: []string{`users":[]`, `families":[]`, `locations":[]`},
+			TestAppFactory:  setupTestApp,
+		},
+	}
+
+	for _, scenario := range scenarios {
+		scenario.Test(t)
+	}
+}
+
+func TestGetSyncDataConditional(t *testing.T) {
+	token := generateToken(t, "users", "luke.skywalker@email.com")
+
+	path := "/mobile/sync?after=" + url.QueryEscape("1970-01-01T00:00:00.000Z")
+
+	var etag string
+	(&tests.ApiScenario{
+		Name:   "etag",
+		Method: http.MethodGet,
+		URL:    path,
+		Headers: map[string]string{
+			"Authorization": token,
+		},
+		ExpectedStatus:  http.StatusOK,
+		ExpectedContent: []string{`"users":`},
+		TestAppFactory:  setupTestApp,
+		AfterTestFunc: func(t testing.TB, app *tests.TestApp, res *http.Response) {
+			etag = res.Header.Get("ETag")
+			require.NotEmpty(t, etag)
+		},
+	}).Test(t)
+
+	scenarios := []tests.ApiScenario{
+		{
+			Name:   "not modified",
+			Method: http.MethodGet,
+			URL:    path,
+			Headers: map[string]string{
+				"Authorization": token,
+				"If-None-Match": etag,
+			},
+			ExpectedStatus: http.StatusNotModified,
+			TestAppFactory: setupTestApp,
+			AfterTestFunc: func(t testing.TB, app *tests.TestApp, res *http.Response) {
+				// the cached body has no file tokens, so a fresh one comes
+				// with every response
+				require.NotEmpty(t, res.Header.Get(handlers.FileTokenHeader))
+			},
+		},
+		{
+			Name:   "modified since etag",
+			Method: http.MethodGet,
+			URL:    path,
+			Headers: map[string]string{
+				"Authorization": token,
+				"If-None-Match": etag,
+			},
+			ExpectedStatus:  http.StatusOK,
+			ExpectedContent: []string{`"locations":`},
+			TestAppFactory: func(t testing.TB) *tests.TestApp {
+				app := setupTestApp(t)
+
+				locations, err := app.FindCollectionByNameOrId("locations")
+				require.NoError(t, err)
+
+				location := core.NewRecord(locations)
+				location.Set("user", "bcruhrwalqnwncy")
+				location.Set("coordinates", map[string]float64{"lon": 8.99, "lat": 33.47})
+				require.NoError(t, app.Save(location))
+
+				return app
+			},
+			AfterTestFunc: func(t testing.TB, app *tests.TestApp, res *http.Response) {
+				require.NotEqual(t, etag, res.Header.Get("ETag"))
+			},
+		},
+		{
+			Name:   "device changed since etag",
+			Method: http.MethodGet,
+			URL:    path,
+			Headers: map[string]string{
+				"Authorization": token,
+				"If-None-Match": etag,
+			},
+			ExpectedStatus:  http.StatusOK,
+			ExpectedContent: []string{`"locations":`},
+			TestAppFactory: func(t testing.TB) *tests.TestApp {
+				app := setupTestApp(t)
+
+				// Leia's untagged locations are hidden once a device shares
+				seedRecords(t, app, "devices",
+					map[string]any{"user": leiaId, "name": "Leia's Pixel", "platform": "android", "sharesLocation": true, "lastSeenAt": time.Now()},
+				)
+
+				return app
+			},
+			AfterTestFunc: func(t testing.TB, app *tests.TestApp, res *http.Response) {
+				require.NotEqual(t, etag, res.Header.Get("ETag"))
+			},
+		},
+		{
+			Name:   "different cursor",
+			Method: http.MethodGet,
+			URL:    "/mobile/sync?after=" + url.QueryEscape("2026-02-01T17:51:44.784Z"),
+			Headers: map[string]string{
+				"Authorization": token,
+				"If-None-Match": etag,
+			},
+			ExpectedStatus:  http.StatusOK,
+			ExpectedContent: []string{`users":[]`},
+			TestAppFactory:  setupTestApp,
+		},
+		{
+			Name:   "gzip",
+			Method: http.MethodGet,
+			URL:    path,
+			Headers: map[string]string{
+				"Authorization":   token,
+				"Accept-Encoding": "gzip",
+			},
+			ExpectedStatus:     http.StatusOK,
+			NotExpectedContent: []string{`"users":`},
+			TestAppFactory:     setupTestApp,
+			AfterTestFunc: func(t testing.TB, app *tests.TestApp, res *http.Response) {
+				require.Equal(t, "gzip", res.Header.Get("Content-Encoding"))
+			},
+		},
+	}
+
+	for _, scenario := range scenarios {
+		scenario.Test(t)
+	}
+}
+
+func TestGetSyncDataSyncedAt(t *testing.T) {
+	token := generateToken(t, "users", "leia.organa@email.com")
+
+	var syncedAt string
+	(&tests.ApiScenario{
+		Name:   "first sync",
+		Method: http.MethodGet,
+		URL:    "/mobile/sync?after=" + url.QueryEscape("1970-01-01T00:00:00.000Z"),
+		Headers: map[string]string{
+			"Authorization": token,
+		},
+		ExpectedStatus:  http.StatusOK,
+		ExpectedContent: []string{`"syncedAt":"20`},
+		TestAppFactory:  setupTestApp,
+		AfterTestFunc: func(t testing.TB, app *tests.TestApp, res *http.Response) {
+			var body struct {
+				SyncedAt string `json:"syncedAt"`
+			}
+			require.NoError(t, json.NewDecoder(res.Body).Decode(&body))
+			syncedAt = body.SyncedAt
+		},
+	}).Test(t)
+
+	(&tests.ApiScenario{
+		Name:   "resume from syncedAt",
+		Method: http.MethodGet,
+		URL:    "/mobile/sync?after=" + url.QueryEscape(syncedAt),
+		Headers: map[string]string{
+			"Authorization": token,
+		},
+		ExpectedStatus:  http.StatusOK,
+		ExpectedContent: []string{`families":[]`, `familyMembers":[]`, `locations":[]`, `"syncedAt":"` + syncedAt + `"`},
+		TestAppFactory:  setupTestApp,
+	}).Test(t)
+}
+
+func TestGetSyncDataLateCommit(t *testing.T) {
+	token := generateToken(t, "users", "leia.organa@email.com")
+
+	app := setupTestApp(t)
+	defer app.Cleanup()
+
+	factory := func(t testing.TB) *tests.TestApp {
+		return app
+	}
+
+	locations, err := app.FindCollectionByNameOrId("locations")
+	require.NoError(t, err)
+
+	leia := core.NewRecord(locations)
+	leia.Set("user", leiaId)
+	leia.Set("coordinates", map[string]float64{"lon": 8.99, "lat": 33.47})
+	require.NoError(t, app.Save(leia))
+
+	var syncedAt string
+	(&tests.ApiScenario{
+		Name:   "first sync",
+		Method: http.MethodGet,
+		URL:    "/mobile/sync?include=locations&after=" + url.QueryEscape("1970-01-01T00:00:00.000Z"),
+		Headers: map[string]string{
+			"Authorization": token,
+		},
+		ExpectedStatus:        http.StatusOK,
+		ExpectedContent:       []string{leia.Id},
+		TestAppFactory:        factory,
+		DisableTestAppCleanup: true,
+		AfterTestFunc: func(t testing.TB, app *tests.TestApp, res *http.Response) {
+			var body struct {
+				SyncedAt time.Time `json:"syncedAt"`
+			}
+			require.NoError(t, json.NewDecoder(res.Body).Decode(&body))
+			require.True(t, body.SyncedAt.Before(leia.GetDateTime("createdAt").Time()))
+			syncedAt = body.SyncedAt.Format(time.RFC3339Nano)
+		},
+	}).Test(t)
+
+	// a location stamped before Leia's but only committed after the first
+	// sync
+	luke := core.NewRecord(locations)
+	luke.Set("user", lukeId)
+	luke.Set("coordinates", map[string]float64{"lon": 8.98, "lat": 33.46})
+	require.NoError(t, app.Save(luke))
+
+	_, err = app.DB().NewQuery("update locations set createdAt = {:createdAt} where id = {:id}").Bind(dbx.Params{
+		"createdAt": leia.GetDateTime("createdAt").Add(-time.Second).String(),
+		"id":        luke.Id,
+	}).Execute()
+	require.NoError(t, err)
+
+	(&tests.ApiScenario{
+		Name:   "resume from syncedAt",
+		Method: http.MethodGet,
+		URL:    "/mobile/sync?include=locations&after=" + url.QueryEscape(syncedAt),
+		Headers: map[string]string{
+			"Authorization": token,
+		},
+		ExpectedStatus:        http.StatusOK,
+		ExpectedContent:       []string{luke.Id},
+		TestAppFactory:        factory,
+		DisableTestAppCleanup: true,
+	}).Test(t)
+}
+
+func TestGetSyncDataDeletedFamilyMembers(t *testing.T) {
+	path := "/mobile/sync?include=familyMembers&after=" + url.QueryEscape("2026-01-01T00:00:00.000Z")
+
+	factory := func(t testing.TB) *tests.TestApp {
+		app := setupTestApp(t)
+
+		membership, err := app.FindRecordById("familyMembers", leiaMembershipId)
+		require.NoError(t, err)
+		require.NoError(t, app.Delete(membership))
+
+		return app
+	}
+
+	scenarios := []tests.ApiScenario{
+		{
+			Name:   "remaining member",
+			Method: http.MethodGet,
+			URL:    path,
+			Headers: map[string]string{
+				"Authorization": generateToken(t, "users", "luke.skywalker@email.com"),
+			},
+			ExpectedStatus:  http.StatusOK,
+			ExpectedContent: []string{`"deletedFamilyMembers":["` + leiaMembershipId + `"]`},
+			TestAppFactory:  factory,
+		},
+		{
+			Name:   "removed member",
+			Method: http.MethodGet,
+			URL:    path,
+			Headers: map[string]string{
+				"Authorization": generateToken(t, "users", "leia.organa@email.com"),
+			},
+			ExpectedStatus:  http.StatusOK,
+			ExpectedContent: []string{`"familyMembers":[]`, `"deletedFamilyMembers":["` + leiaMembershipId + `"]`},
+			TestAppFactory:  factory,
+		},
+		{
+			Name:   "other family",
+			Method: http.MethodGet,
+			URL:    path,
+			Headers: map[string]string{
+				"Authorization": generateToken(t, "users", "darth.vader@email.com"),
+			},
+			ExpectedStatus:  http.StatusOK,
+			ExpectedContent: []string{`"deletedFamilyMembers":[]`},
+			TestAppFactory:  factory,
+		},
+		{
+			Name:   "synced after the delete",
+			Method: http.MethodGet,
+			URL:    "/mobile/sync?include=familyMembers&after=" + url.QueryEscape(time.Now().Add(time.Hour).Format(time.RFC3339)),
+			Headers: map[string]string{
+				"Authorization": generateToken(t, "users", "luke.skywalker@email.com"),
+			},
+			ExpectedStatus:  http.StatusOK,
+			ExpectedContent: []string{`"deletedFamilyMembers":[]`},
+			TestAppFactory:  factory,
+		},
+	}
+
+	for _, scenario := range scenarios {
+		scenario.Test(t)
+	}
+}
+
+func TestGetSyncDataFilters(t *testing.T) {
+	token := generateToken(t, "users", "luke.skywalker@email.com")
+
+	path := "/mobile/sync?after=" + url.QueryEscape("1970-01-01T00:00:00.000Z")
+	scenarios := []tests.ApiScenario{
+		{
+			Name:   "unknown entity type",
+			Method: http.MethodGet,
+			URL:    path + "&include=users,messages",
+			Headers: map[string]string{
+				"Authorization": token,
+			},
+			ExpectedStatus:  http.StatusBadRequest,
+			ExpectedContent: []string{`"code":"validation_failed"`, `"include":`},
+			TestAppFactory:  setupTestApp,
+		},
+		{
+			Name:   "invalid entity cursor",
+			Method: http.MethodGet,
+			URL:    path + "&locationsAfter=yesterday",
+			Headers: map[string]string{
+				"Authorization": token,
+			},
+			ExpectedStatus:  http.StatusBadRequest,
+			ExpectedContent: []string{`"code":"validation_failed"`, `"locationsAfter":`},
+			TestAppFactory:  setupTestApp,
+		},
+		{
+			Name:   "entity cursors without after",
+			Method: http.MethodGet,
+			URL:    "/mobile/sync?include=locations&locationsAfter=" + url.QueryEscape("1970-01-01T00:00:00Z"),
+			Headers: map[string]string{
+				"Authorization": token,
+			},
+			ExpectedStatus:     http.StatusOK,
+			ExpectedContent:    []string{`"locations":[{`, `"cursors":{"locations":`},
+			NotExpectedContent: []string{`"users":`, `"families":`, `"familyMembers":`},
+			TestAppFactory:     setupTestApp,
+		},
+		{
+			Name:   "included entity types",
+			Method: http.MethodGet,
+			URL:    path + "&include=users,families",
+			Headers: map[string]string{
+				"Authorization": token,
+			},
+			ExpectedStatus:     http.StatusOK,
+			ExpectedContent:    []string{`"users":[{`, `"families":[{`},
+			NotExpectedContent: []string{`"familyMembers":`, `"locations":`},
+			TestAppFactory:     setupTestApp,
+		},
+		{
+			Name:   "entity cursor overrides after",
+			Method: http.MethodGet,
+			URL:    path + "&locationsAfter=" + url.QueryEscape("2100-01-01T00:00:00Z"),
+			Headers: map[string]string{
+				"Authorization": token,
+			},
+			ExpectedStatus:  http.StatusOK,
+			ExpectedContent: []string{`"users":[{`, `"locations":[]`, `"locations":"2100-01-01T00:00:00Z"`},
+			TestAppFactory:  setupTestApp,
+		},
+		{
+			Name:   "member family",
+			Method: http.MethodGet,
+			URL:    path + "&families=" + skywalkersId,
+			Headers: map[string]string{
+				"Authorization": token,
+			},
+			ExpectedStatus:  http.StatusOK,
+			ExpectedContent: []string{`"id":"` + skywalkersId + `"`, `"locations":[{`},
+			TestAppFactory:  setupTestApp,
+		},
+		{
+			Name:   "other family",
+			Method: http.MethodGet,
+			URL:    path + "&families=" + empireId,
+			Headers: map[string]string{
+				"Authorization": token,
+			},
+			ExpectedStatus:  http.StatusOK,
+			ExpectedContent: []string{`"users":[]`, `"families":[]`, `"familyMembers":[]`, `"locations":[]`},
+			TestAppFactory:  setupDisplayTestApp,
+		},
+	}
+
+	for _, scenario := range scenarios {
+		scenario.Test(t)
+	}
+}
@@ -0,0 +1,153 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	validation "github.com/go-ozzo/ozzo-validation/v4"
+	"github.com/pocketbase/pocketbase/core"
+)
+
+// requestBody is implemented by every JSON body accepted by a custom route.
+type requestBody interface {
+	// normalize cleans up user input (e.g. trimming whitespace) before it is
+	// validated.
+	normalize()
+
+	// validate checks the normalized body, returning validation.Errors keyed
+	// by JSON field name.
+	validate(app core.App) error
+}
+
+// readBody decodes the JSON request body into dst, rejecting unknown fields,
+// then normalizes and validates it.
+func readBody(e *core.RequestEvent, dst requestBody) error {
+	body := e.Request.Body
+	defer body.Close()
+
+	return decodeBody(e.App, body, dst)
+}
+
+// decodeBody decodes JSON from r into dst, rejecting unknown fields, then
+// normalizes and validates it.
+func decodeBody(app core.App, r io.Reader, dst requestBody) error {
+	decoder := json.NewDecoder(r)
+	decoder.DisallowUnknownFields()
+
+	if err := decoder.Decode(dst); err != nil {
+		if field, ok := unknownField(err); ok {
+			return validationFailed(map[string]string{field: "Unknown field."}, err)
+		}
+
+		return invalidRequest("Invalid request body.", err)
+	}
+
+	dst.normalize()
+
+	if err := dst.validate(app); err != nil {
+		return fromValidationError(err)
+	}
+
+	return nil
+}
+
+// unknownField extracts the offending field name from the error returned by
+// a json.Decoder with DisallowUnknownFields set.
+func unknownField(err error) (string, bool) {
+	quoted, ok := strings.CutPrefix(err.Error(), "json: unknown field ")
+	if !ok {
+		return "", false
+	}
+
+	field, err := strconv.Unquote(quoted)
+	if err != nil {
+		return "", false
+	}
+
+	return field, true
+}
+
+// fromValidationError converts an ozzo-validation error into an apiError
+// carrying one message per invalid field.
+func fromValidationError(err error) *apiError {
+	var internal validation.InternalError
+	if errors.As(err, &internal) {
+		return internalError("Failed to validate request.", err)
+	}
+
+	var errs validation.Errors
+	if !errors.As(err, &errs) {
+		return invalidRequest(err.Error(), err)
+	}
+
+	fields := map[string]string{}
+	flattenValidationErrors(fields, "", errs)
+
+	return validationFailed(fields, err)
+}
+
+// fromSaveError converts an error returned while saving records into an
+// apiError. Record validation failures become per-field messages and any
+// other error is reported with the given message.
+func fromSaveError(err error, message string) *apiError {
+	var apiErr *apiError
+	if errors.As(err, &apiErr) {
+		return apiErr
+	}
+
+	var errs validation.Errors
+	if errors.As(err, &errs) {
+		return fromValidationError(errs)
+	}
+
+	return internalError(message, err)
+}
+
+func flattenValidationErrors(fields map[string]string, prefix string, errs validation.Errors) {
+	for name, err := range errs {
+		key := name
+		if prefix != "" {
+			key = prefix + "." + name
+		}
+
+		var nested validation.Errors
+		if errors.As(err, &nested) {
+			flattenValidationErrors(fields, key, nested)
+		} else {
+			fields[key] = err.Error()
+		}
+	}
+}
+
+// collectionField validates a value against the options of a collection
+// field (required, length, pattern, etc.), so custom routes enforce the same
+// constraints as the PocketBase record APIs. Nil pointers are treated as
+// omitted values and skipped.
+func collectionField(app core.App, collectionName, fieldName string) validation.Rule {
+	return validation.By(func(value any) error {
+		value, isNil := validation.Indirect(value)
+		if isNil {
+			return nil
+		}
+
+		collection, err := app.FindCachedCollectionByNameOrId(collectionName)
+		if err != nil {
+			return validation.NewInternalError(err)
+		}
+
+		field := collection.Fields.GetByName(fieldName)
+		if field == nil {
+			return validation.NewInternalError(fmt.Errorf("missing field %s.%s", collectionName, fieldName))
+		}
+
+		record := core.NewRecord(collection)
+		record.Set(fieldName, value)
+
+		return field.ValidateValue(context.Background(), app, record)
+	})
+}
@@ -0,0 +1,59 @@
+package handlers
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/ian-shakespeare/tribe-tracker/server/internal/database"
+	"github.com/pocketbase/pocketbase/core"
+)
+
+// versionedCollections have a version field that increases with every
+// update, so concurrent edits from several devices can be detected instead of
+// the last write silently winning.
+var versionedCollections = []string{"users", "families"}
+
+// initVersion starts new versioned records at version 1.
+func initVersion(e *core.RecordEvent) error {
+	e.Record.Set("version", 1)
+
+	return e.Next()
+}
+
+// bumpVersion increments the version of every updated versioned record. The
+// new version is derived from the stored one so clients can't set it.
+func bumpVersion(e *core.RecordEvent) error {
+	version, err := database.GetVersion(e.App.DB(), e.Record.Collection().Name, e.Record.Id)
+	if err != nil {
+		return err
+	}
+
+	e.Record.Set("version", version+1)
+
+	return e.Next()
+}
+
+// versionETag formats a record version as an ETag for If-Match requests.
+func versionETag(version int) string {
+	return `"` + strconv.Itoa(version) + `"`
+}
+
+// baseVersion returns the version an update was based on, taken from the
+// If-Match header or, failing that, from the version sent in the body.
+func baseVersion(e *core.RequestEvent, bodyVersion *int) (int, error) {
+	ifMatch := strings.TrimSpace(e.Request.Header.Get("If-Match"))
+	if ifMatch == "" {
+		if bodyVersion == nil {
+			return 0, validationFailed(map[string]string{"version": "Send the version being updated in If-Match or the request body."}, nil)
+		}
+
+		return *bodyVersion, nil
+	}
+
+	version, err := strconv.Atoi(strings.Trim(strings.TrimPrefix(ifMatch, "W/"), `"`))
+	if err != nil {
+		return 0, invalidRequest("If-Match must be a record version.", err)
+	}
+
+	return version, nil
+}
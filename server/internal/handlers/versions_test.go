@@ -0,0 +1,36 @@
+package handlers_test
+
+import (
+	"testing"
+
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRecordVersions(t *testing.T) {
+	app := setupTestApp(t)
+	defer app.Cleanup()
+
+	family, err := app.FindRecordById("families", skywalkersId)
+	require.NoError(t, err)
+	require.Equal(t, 1, family.GetInt("version"))
+
+	family.Set("name", "Skywalker Clan")
+	require.NoError(t, app.Save(family))
+	require.Equal(t, 2, family.GetInt("version"))
+
+	// clients can't move the version themselves
+	family.Set("version", 40)
+	require.NoError(t, app.Save(family))
+	require.Equal(t, 3, family.GetInt("version"))
+
+	families, err := app.FindCollectionByNameOrId("families")
+	require.NoError(t, err)
+
+	created := core.NewRecord(families)
+	created.Set("name", "Lars")
+	created.Set("code", "moisture-farm")
+	created.Set("version", 12)
+	require.NoError(t, app.Save(created))
+	require.Equal(t, 1, created.GetInt("version"))
+}
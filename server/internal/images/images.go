@@ -0,0 +1,92 @@
+package images
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+	_ "image/gif"
+	"image/jpeg"
+	_ "image/png"
+	"io"
+	"path/filepath"
+	"strings"
+
+	"github.com/disintegration/imaging"
+	"github.com/gabriel-vasile/mimetype"
+
+	_ "golang.org/x/image/webp"
+)
+
+// JPEGQuality is the quality normalized images are encoded with.
+const JPEGQuality = 85
+
+// MaxPixels caps the width times height of the images that are decoded. An
+// image declares its dimensions in its header, so a small file can claim to
+// be huge and make the decoder allocate gigabytes.
+const MaxPixels = 50_000_000
+
+// ErrUnsupported is returned for images that can't be decoded, including
+// HEIC/HEIF photos since no decoder for them is available. Clients convert
+// those to JPEG before uploading them.
+var ErrUnsupported = errors.New("unsupported image format")
+
+// ErrTooLarge is returned for images with more than MaxPixels pixels.
+var ErrTooLarge = errors.New("image too large")
+
+// IsVector reports whether the content is an SVG image, which is stored as
+// is since it can't be decoded and carries no camera metadata.
+func IsVector(r io.Reader) (bool, error) {
+	mime, err := mimetype.DetectReader(r)
+	if err != nil {
+		return false, err
+	}
+
+	return mime.Is("image/svg+xml"), nil
+}
+
+// Normalize decodes a raster image and re-encodes it as a JPEG no larger than
+// maxDimension on either side. The EXIF orientation is applied before
+// encoding and every other piece of metadata, such as GPS coordinates, is
+// dropped. Transparent areas are flattened onto white.
+func Normalize(r io.Reader, maxDimension int) ([]byte, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	// the header is checked before anything is decoded
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if errors.Is(err, image.ErrFormat) {
+		return nil, ErrUnsupported
+	} else if err != nil {
+		return nil, err
+	}
+
+	if int64(config.Width)*int64(config.Height) > MaxPixels {
+		return nil, ErrTooLarge
+	}
+
+	img, err := imaging.Decode(bytes.NewReader(data), imaging.AutoOrientation(true))
+	if err != nil {
+		return nil, err
+	}
+
+	img = imaging.Fit(img, maxDimension, maxDimension, imaging.Lanczos)
+
+	bounds := img.Bounds()
+	flat := imaging.New(bounds.Dx(), bounds.Dy(), color.White)
+	flat = imaging.Overlay(flat, img, image.Point{}, 1)
+
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, flat, &jpeg.Options{Quality: JPEGQuality}); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// JPEGName replaces the extension of a file name with .jpg.
+func JPEGName(name string) string {
+	return strings.TrimSuffix(name, filepath.Ext(name)) + ".jpg"
+}
@@ -0,0 +1,96 @@
+package images_test
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"testing"
+
+	"github.com/ian-shakespeare/tribe-tracker/server/internal/images"
+	"github.com/stretchr/testify/require"
+)
+
+// exifJPEG encodes a w×h JPEG carrying an EXIF segment with the given
+// orientation.
+func exifJPEG(t *testing.T, w, h int, orientation byte) []byte {
+	t.Helper()
+
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for x := range w {
+		for y := range h {
+			img.Set(x, y, color.RGBA{R: 200, G: uint8(x), B: uint8(y), A: 255})
+		}
+	}
+
+	var buf bytes.Buffer
+	require.NoError(t, jpeg.Encode(&buf, img, nil))
+	encoded := buf.Bytes()
+
+	// big endian TIFF header and a single IFD entry with the orientation
+	exif := []byte("Exif\x00\x00MM\x00\x2a\x00\x00\x00\x08\x00\x01\x01\x12\x00\x03\x00\x00\x00\x01\x00")
+	exif = append(exif, orientation, 0, 0, 0, 0, 0, 0)
+	exif = append(exif, []byte("GPS 33.468108 8.986816")...)
+
+	segment := []byte{0xff, 0xe1, byte((len(exif) + 2) >> 8), byte(len(exif) + 2)}
+	segment = append(segment, exif...)
+
+	// the segment goes right after the start of image marker
+	out := append([]byte{}, encoded[:2]...)
+	out = append(out, segment...)
+
+	return append(out, encoded[2:]...)
+}
+
+func TestNormalize(t *testing.T) {
+	t.Run("strips metadata", func(t *testing.T) {
+		src := exifJPEG(t, 16, 16, 1)
+		require.True(t, bytes.Contains(src, []byte("Exif")))
+
+		out, err := images.Normalize(bytes.NewReader(src), 1024)
+		require.NoError(t, err)
+		require.False(t, bytes.Contains(out, []byte("Exif")))
+		require.False(t, bytes.Contains(out, []byte("GPS")))
+	})
+
+	t.Run("applies orientation", func(t *testing.T) {
+		out, err := images.Normalize(bytes.NewReader(exifJPEG(t, 16, 8, 6)), 1024)
+		require.NoError(t, err)
+
+		config, format, err := image.DecodeConfig(bytes.NewReader(out))
+		require.NoError(t, err)
+		require.Equal(t, "jpeg", format)
+		require.Equal(t, 8, config.Width)
+		require.Equal(t, 16, config.Height)
+	})
+
+	t.Run("caps dimensions", func(t *testing.T) {
+		out, err := images.Normalize(bytes.NewReader(exifJPEG(t, 64, 32, 1)), 16)
+		require.NoError(t, err)
+
+		config, _, err := image.DecodeConfig(bytes.NewReader(out))
+		require.NoError(t, err)
+		require.Equal(t, 16, config.Width)
+		require.Equal(t, 8, config.Height)
+	})
+
+	t.Run("too large", func(t *testing.T) {
+		// a GIF header declaring 65535×65535 pixels
+		bomb := []byte("GIF89a\xff\xff\xff\xff\x00\x00\x00\x2c\x00\x00\x00\x00\xff\xff\xff\xff\x00\x02\x02\x44\x01\x00\x3b")
+
+		_, err := images.Normalize(bytes.NewReader(bomb), 1024)
+		require.ErrorIs(t, err, images.ErrTooLarge)
+	})
+
+	t.Run("unsupported", func(t *testing.T) {
+		heic := []byte("\x00\x00\x00\x18ftypheic\x00\x00\x00\x00mif1heic")
+
+		_, err := images.Normalize(bytes.NewReader(heic), 1024)
+		require.ErrorIs(t, err, images.ErrUnsupported)
+	})
+}
+
+func TestJPEGName(t *testing.T) {
+	require.Equal(t, "photo_abc.jpg", images.JPEGName("photo_abc.heic"))
+	require.Equal(t, "photo.jpg", images.JPEGName("photo"))
+}
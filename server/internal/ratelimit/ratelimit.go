@@ -0,0 +1,86 @@
+package ratelimit
+
+import (
+	"sync"
+	"time"
+)
+
+// pruneInterval is how often buckets that refilled completely are dropped.
+const pruneInterval = time.Minute
+
+// Limiter is a set of token buckets, one per key. Each bucket holds up to
+// burst tokens and refills at a steady rate; a request takes one token.
+type Limiter struct {
+	rate  float64 // tokens per second
+	burst float64
+
+	mu         sync.Mutex
+	buckets    map[string]*bucket
+	lastPruned time.Time
+}
+
+type bucket struct {
+	tokens  float64
+	updated time.Time
+}
+
+// New returns a limiter allowing perMinute requests per minute per key, in
+// bursts of up to burst requests. A burst below 1 is raised to 1.
+func New(perMinute float64, burst int) *Limiter {
+	return &Limiter{
+		rate:    perMinute / 60,
+		burst:   float64(max(burst, 1)),
+		buckets: map[string]*bucket{},
+	}
+}
+
+// Allow takes a token from the key's bucket. If the bucket is empty it
+// returns false and how long until a token is available.
+func (l *Limiter) Allow(key string, now time.Time) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.prune(now)
+
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{tokens: l.burst, updated: now}
+		l.buckets[key] = b
+	}
+
+	b.tokens = l.refill(b, now)
+	b.updated = now
+
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+
+	wait := time.Duration((1 - b.tokens) / l.rate * float64(time.Second))
+
+	return false, wait
+}
+
+func (l *Limiter) refill(b *bucket, now time.Time) float64 {
+	elapsed := now.Sub(b.updated).Seconds()
+	if elapsed <= 0 {
+		return b.tokens
+	}
+
+	return min(l.burst, b.tokens+elapsed*l.rate)
+}
+
+// prune drops full buckets, which behave exactly like missing ones, so
+// memory only grows with the number of recently active keys.
+func (l *Limiter) prune(now time.Time) {
+	if now.Sub(l.lastPruned) < pruneInterval {
+		return
+	}
+	l.lastPruned = now
+
+	for key, b := range l.buckets {
+		if l.refill(b, now) >= l.burst {
+			delete(l.buckets, key)
+		}
+	}
+}
@@ -0,0 +1,68 @@
+package ratelimit_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ian-shakespeare/tribe-tracker/server/internal/ratelimit"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLimiter(t *testing.T) {
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	t.Run("burst", func(t *testing.T) {
+		limiter := ratelimit.New(60, 3)
+
+		for range 3 {
+			allowed, _ := limiter.Allow("luke", now)
+			require.True(t, allowed)
+		}
+
+		allowed, wait := limiter.Allow("luke", now)
+		require.False(t, allowed)
+		require.Equal(t, time.Second, wait)
+	})
+
+	t.Run("refill", func(t *testing.T) {
+		limiter := ratelimit.New(60, 1)
+
+		allowed, _ := limiter.Allow("luke", now)
+		require.True(t, allowed)
+
+		allowed, wait := limiter.Allow("luke", now.Add(500*time.Millisecond))
+		require.False(t, allowed)
+		require.Equal(t, 500*time.Millisecond, wait)
+
+		allowed, _ = limiter.Allow("luke", now.Add(time.Second))
+		require.True(t, allowed)
+	})
+
+	t.Run("keys are independent", func(t *testing.T) {
+		limiter := ratelimit.New(1, 1)
+
+		allowed, _ := limiter.Allow("luke", now)
+		require.True(t, allowed)
+
+		allowed, _ = limiter.Allow("luke", now)
+		require.False(t, allowed)
+
+		allowed, _ = limiter.Allow("leia", now)
+		require.True(t, allowed)
+	})
+
+	t.Run("pruned buckets start full", func(t *testing.T) {
+		limiter := ratelimit.New(60, 2)
+
+		for range 2 {
+			allowed, _ := limiter.Allow("luke", now)
+			require.True(t, allowed)
+		}
+
+		later := now.Add(time.Hour)
+		for range 2 {
+			allowed, _ := limiter.Allow("luke", later)
+			require.True(t, allowed)
+		}
+	})
+}
@@ -0,0 +1,82 @@
+package migrations
+
+import (
+	"github.com/pocketbase/pocketbase/core"
+	m "github.com/pocketbase/pocketbase/migrations"
+	"github.com/pocketbase/pocketbase/tools/types"
+)
+
+func init() {
+	m.Register(func(app core.App) error {
+		families, err := app.FindCollectionByNameOrId(FamiliesId)
+		if err != nil {
+			return err
+		}
+
+		familyRule := `@request.auth.id != "" && @collection.familyMembers:membership.family ?= id && @collection.familyMembers:membership.user ?= @request.auth.id`
+		families.ViewRule = types.Pointer(familyRule)
+		families.ListRule = types.Pointer(familyRule)
+
+		if err := app.Save(families); err != nil {
+			return err
+		}
+
+		familyMembers, err := app.FindCollectionByNameOrId(FamilyMembersId)
+		if err != nil {
+			return err
+		}
+
+		familyMemberRule := `@request.auth.id != "" && @collection.familyMembers:membership.family ?= family && @collection.familyMembers:membership.user ?= @request.auth.id`
+		familyMembers.ViewRule = types.Pointer(familyMemberRule)
+		familyMembers.ListRule = types.Pointer(familyMemberRule)
+
+		if err := app.Save(familyMembers); err != nil {
+			return err
+		}
+
+		locations, err := app.FindCollectionByNameOrId(LocationsId)
+		if err != nil {
+			return err
+		}
+
+		locationRule := `@request.auth.id != "" && (user = @request.auth.id || (@collection.familyMembers:theirs.user ?= user && @collection.familyMembers:mine.user ?= @request.auth.id && @collection.familyMembers:mine.family ?= @collection.familyMembers:theirs.family))`
+		locations.ViewRule = types.Pointer(locationRule)
+		locations.ListRule = types.Pointer(locationRule)
+
+		return app.Save(locations)
+	}, func(app core.App) error {
+		families, err := app.FindCollectionByNameOrId(FamiliesId)
+		if err != nil {
+			return err
+		}
+
+		families.ViewRule = types.Pointer(`@request.auth.id != "" && createdBy = @request.auth.id`)
+		families.ListRule = types.Pointer(`@request.auth.id != "" && createdBy = @request.auth.id`)
+
+		if err := app.Save(families); err != nil {
+			return err
+		}
+
+		familyMembers, err := app.FindCollectionByNameOrId(FamilyMembersId)
+		if err != nil {
+			return err
+		}
+
+		familyMembers.ViewRule = nil
+		familyMembers.ListRule = nil
+
+		if err := app.Save(familyMembers); err != nil {
+			return err
+		}
+
+		locations, err := app.FindCollectionByNameOrId(LocationsId)
+		if err != nil {
+			return err
+		}
+
+		locations.ViewRule = types.Pointer(`@request.auth.id != "" && user.id = @request.auth.id`)
+		locations.ListRule = types.Pointer(`@request.auth.id != "" && user.id = @request.auth.id`)
+
+		return app.Save(locations)
+	})
+}
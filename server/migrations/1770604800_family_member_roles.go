@@ -0,0 +1,50 @@
+package migrations
+
+import (
+	"github.com/pocketbase/pocketbase/core"
+	m "github.com/pocketbase/pocketbase/migrations"
+)
+
+func init() {
+	m.Register(func(app core.App) error {
+		familyMembers, err := app.FindCollectionByNameOrId(FamilyMembersId)
+		if err != nil {
+			return err
+		}
+
+		role := &core.SelectField{
+			Name:      "role",
+			MaxSelect: 1,
+			Values:    []string{"owner", "admin", "member"},
+		}
+		familyMembers.Fields.Add(role)
+
+		if err := app.Save(familyMembers); err != nil {
+			return err
+		}
+
+		_, err = app.DB().NewQuery(`
+      update familyMembers
+      set role = case
+        when user = (select f.createdBy from families f where f.id = familyMembers.family) then 'owner'
+        else 'member'
+      end
+    `).Execute()
+		if err != nil {
+			return err
+		}
+
+		role.Required = true
+
+		return app.Save(familyMembers)
+	}, func(app core.App) error {
+		familyMembers, err := app.FindCollectionByNameOrId(FamilyMembersId)
+		if err != nil {
+			return err
+		}
+
+		familyMembers.Fields.RemoveByName("role")
+
+		return app.Save(familyMembers)
+	})
+}
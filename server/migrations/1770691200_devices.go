@@ -0,0 +1,112 @@
+package migrations
+
+import (
+	"github.com/pocketbase/pocketbase/core"
+	m "github.com/pocketbase/pocketbase/migrations"
+	"github.com/pocketbase/pocketbase/tools/types"
+)
+
+const DevicesId = "devices"
+
+func init() {
+	m.Register(func(app core.App) error {
+		users, err := app.FindCollectionByNameOrId(UsersId)
+		if err != nil {
+			return err
+		}
+
+		devices := core.NewBaseCollection(DevicesId)
+
+		devices.ViewRule = types.Pointer(`@request.auth.id != "" && user = @request.auth.id`)
+		devices.ListRule = types.Pointer(`@request.auth.id != "" && user = @request.auth.id`)
+
+		devices.Fields.Add(&core.RelationField{
+			Name:          "user",
+			CollectionId:  users.Id,
+			MaxSelect:     1,
+			CascadeDelete: true,
+			Required:      true,
+		})
+
+		devices.Fields.Add(&core.TextField{
+			Name:        "name",
+			Min:         1,
+			Max:         64,
+			Presentable: true,
+			Required:    true,
+		})
+
+		devices.Fields.Add(&core.SelectField{
+			Name:      "platform",
+			MaxSelect: 1,
+			Values:    []string{"ios", "android", "web", "display"},
+			Required:  true,
+		})
+
+		devices.Fields.Add(&core.TextField{
+			Name: "appVersion",
+			Max:  32,
+		})
+
+		devices.Fields.Add(&core.BoolField{
+			Name: "sharesLocation",
+		})
+
+		devices.Fields.Add(&core.DateField{
+			Name: "lastSeenAt",
+		})
+
+		devices.Fields.Add(&core.DateField{
+			Name: "revokedAt",
+		})
+
+		devices.Fields.Add(&core.AutodateField{
+			Name:     "createdAt",
+			System:   true,
+			OnCreate: true,
+		})
+
+		devices.Fields.Add(&core.AutodateField{
+			Name:     "updatedAt",
+			System:   true,
+			OnCreate: true,
+			OnUpdate: true,
+		})
+
+		devices.AddIndex("idx_device_user", false, "user", "")
+
+		if err := app.Save(devices); err != nil {
+			return err
+		}
+
+		locations, err := app.FindCollectionByNameOrId(LocationsId)
+		if err != nil {
+			return err
+		}
+
+		locations.Fields.Add(&core.RelationField{
+			Name:         "device",
+			CollectionId: devices.Id,
+			MaxSelect:    1,
+		})
+
+		return app.Save(locations)
+	}, func(app core.App) error {
+		locations, err := app.FindCollectionByNameOrId(LocationsId)
+		if err != nil {
+			return err
+		}
+
+		locations.Fields.RemoveByName("device")
+		if err := app.Save(locations); err != nil {
+			return err
+		}
+
+		devices, err := app.FindCollectionByNameOrId(DevicesId)
+		if err != nil {
+			return err
+		}
+
+		return app.Delete(devices)
+	})
+}
@@ -0,0 +1,78 @@
+package migrations
+
+import (
+	"github.com/pocketbase/pocketbase/core"
+	m "github.com/pocketbase/pocketbase/migrations"
+)
+
+const DisplayTokensId = "displayTokens"
+
+func init() {
+	m.Register(func(app core.App) error {
+		users, err := app.FindCollectionByNameOrId(UsersId)
+		if err != nil {
+			return err
+		}
+
+		families, err := app.FindCollectionByNameOrId(FamiliesId)
+		if err != nil {
+			return err
+		}
+
+		displayTokens := core.NewBaseCollection(DisplayTokensId)
+
+		displayTokens.Fields.Add(&core.RelationField{
+			Name:          "family",
+			CollectionId:  families.Id,
+			MaxSelect:     1,
+			CascadeDelete: true,
+			Required:      true,
+		})
+
+		displayTokens.Fields.Add(&core.RelationField{
+			Name:         "createdBy",
+			CollectionId: users.Id,
+			MaxSelect:    1,
+		})
+
+		displayTokens.Fields.Add(&core.TextField{
+			Name:        "name",
+			Min:         1,
+			Max:         64,
+			Presentable: true,
+			Required:    true,
+		})
+
+		displayTokens.Fields.Add(&core.TextField{
+			Name:     "tokenHash",
+			Hidden:   true,
+			Required: true,
+		})
+
+		displayTokens.Fields.Add(&core.DateField{
+			Name: "lastUsedAt",
+		})
+
+		displayTokens.Fields.Add(&core.DateField{
+			Name: "revokedAt",
+		})
+
+		displayTokens.Fields.Add(&core.AutodateField{
+			Name:     "createdAt",
+			System:   true,
+			OnCreate: true,
+		})
+
+		displayTokens.AddIndex("idx_display_token_family", false, "family", "")
+		displayTokens.AddIndex("idx_display_token_hash", true, "tokenHash", "")
+
+		return app.Save(displayTokens)
+	}, func(app core.App) error {
+		displayTokens, err := app.FindCollectionByNameOrId(DisplayTokensId)
+		if err != nil {
+			return err
+		}
+
+		return app.Delete(displayTokens)
+	})
+}
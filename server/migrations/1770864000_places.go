@@ -0,0 +1,91 @@
+package migrations
+
+import (
+	"github.com/pocketbase/pocketbase/core"
+	m "github.com/pocketbase/pocketbase/migrations"
+	"github.com/pocketbase/pocketbase/tools/types"
+)
+
+const PlacesId = "places"
+
+func init() {
+	m.Register(func(app core.App) error {
+		users, err := app.FindCollectionByNameOrId(UsersId)
+		if err != nil {
+			return err
+		}
+
+		families, err := app.FindCollectionByNameOrId(FamiliesId)
+		if err != nil {
+			return err
+		}
+
+		places := core.NewBaseCollection(PlacesId)
+
+		memberRule := `@request.auth.id != "" && @collection.familyMembers:membership.family ?= family && @collection.familyMembers:membership.user ?= @request.auth.id`
+		places.ViewRule = types.Pointer(memberRule)
+		places.ListRule = types.Pointer(memberRule)
+		places.CreateRule = types.Pointer(memberRule + ` && (@request.body.createdBy:isset = false || @request.body.createdBy = @request.auth.id)`)
+		places.UpdateRule = types.Pointer(memberRule + ` && (@request.body.family:isset = false || @request.body.family = family)`)
+		places.DeleteRule = types.Pointer(memberRule)
+
+		places.Fields.Add(&core.RelationField{
+			Name:          "family",
+			CollectionId:  families.Id,
+			MaxSelect:     1,
+			CascadeDelete: true,
+			Required:      true,
+		})
+
+		places.Fields.Add(&core.RelationField{
+			Name:         "createdBy",
+			CollectionId: users.Id,
+			MaxSelect:    1,
+		})
+
+		places.Fields.Add(&core.TextField{
+			Name:        "name",
+			Min:         1,
+			Max:         64,
+			Presentable: true,
+			Required:    true,
+		})
+
+		places.Fields.Add(&core.GeoPointField{
+			Name:     "coordinates",
+			Required: true,
+		})
+
+		// geofence radius in meters
+		places.Fields.Add(&core.NumberField{
+			Name:     "radius",
+			Min:      types.Pointer(10.0),
+			Max:      types.Pointer(10000.0),
+			Required: true,
+		})
+
+		places.Fields.Add(&core.AutodateField{
+			Name:     "createdAt",
+			System:   true,
+			OnCreate: true,
+		})
+
+		places.Fields.Add(&core.AutodateField{
+			Name:     "updatedAt",
+			System:   true,
+			OnCreate: true,
+			OnUpdate: true,
+		})
+
+		places.AddIndex("idx_place_family", false, "family", "")
+
+		return app.Save(places)
+	}, func(app core.App) error {
+		places, err := app.FindCollectionByNameOrId(PlacesId)
+		if err != nil {
+			return err
+		}
+
+		return app.Delete(places)
+	})
+}
@@ -0,0 +1,54 @@
+package migrations
+
+import (
+	"github.com/pocketbase/pocketbase/core"
+	m "github.com/pocketbase/pocketbase/migrations"
+	"github.com/pocketbase/pocketbase/tools/types"
+)
+
+// versionedCollections hold records edited from several devices, whose
+// updates are checked against a version to detect conflicting edits.
+var versionedCollections = []string{UsersId, FamiliesId}
+
+func init() {
+	m.Register(func(app core.App) error {
+		for _, name := range versionedCollections {
+			collection, err := app.FindCollectionByNameOrId(name)
+			if err != nil {
+				return err
+			}
+
+			collection.Fields.Add(&core.NumberField{
+				Name:    "version",
+				OnlyInt: true,
+				Min:     types.Pointer(0.0),
+			})
+
+			if err := app.Save(collection); err != nil {
+				return err
+			}
+
+			_, err = app.DB().NewQuery("update " + name + " set version = 1").Execute()
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}, func(app core.App) error {
+		for _, name := range versionedCollections {
+			collection, err := app.FindCollectionByNameOrId(name)
+			if err != nil {
+				return err
+			}
+
+			collection.Fields.RemoveByName("version")
+
+			if err := app.Save(collection); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
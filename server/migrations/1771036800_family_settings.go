@@ -0,0 +1,64 @@
+package migrations
+
+import (
+	"github.com/pocketbase/pocketbase/core"
+	m "github.com/pocketbase/pocketbase/migrations"
+	"github.com/pocketbase/pocketbase/tools/types"
+)
+
+func init() {
+	m.Register(func(app core.App) error {
+		families, err := app.FindCollectionByNameOrId(FamiliesId)
+		if err != nil {
+			return err
+		}
+
+		// updates go through PATCH /mobile/families/{id}, which checks member
+		// roles and record versions
+		families.UpdateRule = nil
+
+		families.Fields.Add(&core.TextField{
+			Name: "description",
+			Max:  280,
+		})
+
+		// an emoji or icon name shown when the family has no image
+		families.Fields.Add(&core.TextField{
+			Name: "icon",
+			Max:  32,
+		})
+
+		families.Fields.Add(&core.TextField{
+			Name:    "color",
+			Pattern: `^#[0-9a-fA-F]{6}$`,
+		})
+
+		// the precision new members share their location with by default
+		families.Fields.Add(&core.SelectField{
+			Name:      "sharingPrecision",
+			MaxSelect: 1,
+			Values:    []string{"exact", "approximate", "city"},
+		})
+
+		if err := app.Save(families); err != nil {
+			return err
+		}
+
+		_, err = app.DB().NewQuery("update families set sharingPrecision = 'exact'").Execute()
+		return err
+	}, func(app core.App) error {
+		families, err := app.FindCollectionByNameOrId(FamiliesId)
+		if err != nil {
+			return err
+		}
+
+		families.UpdateRule = types.Pointer(`@request.auth.id != "" && createdBy = @request.auth.id`)
+
+		families.Fields.RemoveByName("description")
+		families.Fields.RemoveByName("icon")
+		families.Fields.RemoveByName("color")
+		families.Fields.RemoveByName("sharingPrecision")
+
+		return app.Save(families)
+	})
+}
@@ -0,0 +1,48 @@
+package migrations
+
+import (
+	"fmt"
+
+	"github.com/pocketbase/pocketbase/core"
+	m "github.com/pocketbase/pocketbase/migrations"
+)
+
+func init() {
+	m.Register(func(app core.App) error {
+		users, err := app.FindCollectionByNameOrId(UsersId)
+		if err != nil {
+			return err
+		}
+
+		avatarField, ok := users.Fields.GetByName("avatar").(*core.FileField)
+		if !ok {
+			return fmt.Errorf("%w: expected file field", ErrInvalidFieldType)
+		}
+
+		families, err := app.FindCollectionByNameOrId(FamiliesId)
+		if err != nil {
+			return err
+		}
+
+		families.Fields.Add(&core.FileField{
+			Name:      "image",
+			MaxSelect: 1,
+			MaxSize:   5 << 20,
+			// accept the same images as user avatars
+			MimeTypes: avatarField.MimeTypes,
+			// map markers and list rows
+			Thumbs: []string{"64x64", "128x128"},
+		})
+
+		return app.Save(families)
+	}, func(app core.App) error {
+		families, err := app.FindCollectionByNameOrId(FamiliesId)
+		if err != nil {
+			return err
+		}
+
+		families.Fields.RemoveByName("image")
+
+		return app.Save(families)
+	})
+}
@@ -0,0 +1,47 @@
+package migrations
+
+import (
+	"fmt"
+
+	"github.com/pocketbase/pocketbase/core"
+	m "github.com/pocketbase/pocketbase/migrations"
+	"github.com/pocketbase/pocketbase/tools/types"
+)
+
+func init() {
+	m.Register(func(app core.App) error {
+		users, err := app.FindCollectionByNameOrId(UsersId)
+		if err != nil {
+			return err
+		}
+
+		avatarField, ok := users.Fields.GetByName("avatar").(*core.FileField)
+		if !ok {
+			return fmt.Errorf("%w: expected file field", ErrInvalidFieldType)
+		}
+
+		// protected files are only served with a file token of a user allowed
+		// to view the record
+		avatarField.Protected = true
+
+		users.ViewRule = types.Pointer(`@request.auth.id != "" && (id = @request.auth.id || (@collection.familyMembers:theirs.user ?= id && @collection.familyMembers:mine.user ?= @request.auth.id && @collection.familyMembers:mine.family ?= @collection.familyMembers:theirs.family))`)
+
+		return app.Save(users)
+	}, func(app core.App) error {
+		users, err := app.FindCollectionByNameOrId(UsersId)
+		if err != nil {
+			return err
+		}
+
+		avatarField, ok := users.Fields.GetByName("avatar").(*core.FileField)
+		if !ok {
+			return fmt.Errorf("%w: expected file field", ErrInvalidFieldType)
+		}
+
+		avatarField.Protected = false
+
+		users.ViewRule = types.Pointer("id = @request.auth.id")
+
+		return app.Save(users)
+	})
+}
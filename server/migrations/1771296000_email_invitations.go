@@ -0,0 +1,62 @@
+package migrations
+
+import (
+	"fmt"
+
+	"github.com/pocketbase/pocketbase/core"
+	m "github.com/pocketbase/pocketbase/migrations"
+	"github.com/pocketbase/pocketbase/tools/types"
+)
+
+func init() {
+	m.Register(func(app core.App) error {
+		invitations, err := app.FindCollectionByNameOrId(InvitationsId)
+		if err != nil {
+			return err
+		}
+
+		recipient, ok := invitations.Fields.GetByName("recipient").(*core.RelationField)
+		if !ok {
+			return fmt.Errorf("%w: expected relation field", ErrInvalidFieldType)
+		}
+
+		// invitations to people without an account are addressed to an email
+		// and get a recipient once they sign up
+		recipient.Required = false
+
+		invitations.Fields.Add(&core.EmailField{
+			Name: "email",
+		})
+
+		// invitations are sent through the server so email invitations can be
+		// mailed
+		invitations.CreateRule = nil
+
+		invitations.AddIndex("idx_invitation_email", false, "email", "")
+
+		return app.Save(invitations)
+	}, func(app core.App) error {
+		invitations, err := app.FindCollectionByNameOrId(InvitationsId)
+		if err != nil {
+			return err
+		}
+
+		_, err = app.DB().NewQuery("delete from invitations where recipient = ''").Execute()
+		if err != nil {
+			return err
+		}
+
+		recipient, ok := invitations.Fields.GetByName("recipient").(*core.RelationField)
+		if !ok {
+			return fmt.Errorf("%w: expected relation field", ErrInvalidFieldType)
+		}
+
+		recipient.Required = true
+
+		invitations.Fields.RemoveByName("email")
+		invitations.RemoveIndex("idx_invitation_email")
+		invitations.CreateRule = types.Pointer(`@request.auth.id != ""`)
+
+		return app.Save(invitations)
+	})
+}
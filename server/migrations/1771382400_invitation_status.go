@@ -0,0 +1,93 @@
+package migrations
+
+import (
+	"github.com/pocketbase/pocketbase/core"
+	m "github.com/pocketbase/pocketbase/migrations"
+	"github.com/pocketbase/pocketbase/tools/types"
+)
+
+func init() {
+	m.Register(func(app core.App) error {
+		invitations, err := app.FindCollectionByNameOrId(InvitationsId)
+		if err != nil {
+			return err
+		}
+
+		status := &core.SelectField{
+			Name:      "status",
+			MaxSelect: 1,
+			Values:    []string{"pending", "accepted", "declined", "revoked", "expired"},
+		}
+		invitations.Fields.Add(status)
+
+		invitations.Fields.Add(&core.DateField{
+			Name: "expiresAt",
+		})
+
+		// sentAt is when the invitation was last sent, which resends update
+		invitations.Fields.Add(&core.DateField{
+			Name: "sentAt",
+		})
+
+		// the status is only changed through the server
+		invitations.UpdateRule = nil
+
+		if err := app.Save(invitations); err != nil {
+			return err
+		}
+
+		// invitations whose recipient has joined were accepted, and of several
+		// pending invitations for the same person only the latest is kept.
+		// Existing invitations get a full lifetime from now on rather than
+		// expiring all at once.
+		_, err = app.DB().NewQuery(`
+      update invitations
+      set status = case
+          when exists (
+            select 1
+            from familyMembers fm
+            where fm.family = invitations.family
+              and fm.user = invitations.recipient
+          ) then 'accepted'
+          when exists (
+            select 1
+            from invitations later
+            where later.family = invitations.family
+              and later.recipient = invitations.recipient
+              and (invitations.recipient != '' or lower(later.email) = lower(invitations.email))
+              and later.createdAt > invitations.createdAt
+          ) then 'revoked'
+          else 'pending'
+        end,
+        email = lower(email),
+        sentAt = createdAt,
+        expiresAt = strftime('%Y-%m-%d %H:%M:%fZ', 'now', '+14 days')
+    `).Execute()
+		if err != nil {
+			return err
+		}
+
+		status.Required = true
+
+		invitations.AddIndex("idx_invitation_pending_recipient", true, "family, recipient", "status = 'pending' and recipient != ''")
+		invitations.AddIndex("idx_invitation_pending_email", true, "family, email", "status = 'pending' and recipient = ''")
+
+		return app.Save(invitations)
+	}, func(app core.App) error {
+		invitations, err := app.FindCollectionByNameOrId(InvitationsId)
+		if err != nil {
+			return err
+		}
+
+		invitations.RemoveIndex("idx_invitation_pending_recipient")
+		invitations.RemoveIndex("idx_invitation_pending_email")
+
+		invitations.Fields.RemoveByName("status")
+		invitations.Fields.RemoveByName("expiresAt")
+		invitations.Fields.RemoveByName("sentAt")
+
+		invitations.UpdateRule = types.Pointer(`@request.auth.id != "" && (sender.id = @request.auth.id || recipient.id = @request.auth.id)`)
+
+		return app.Save(invitations)
+	})
+}
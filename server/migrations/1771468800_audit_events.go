@@ -0,0 +1,88 @@
+package migrations
+
+import (
+	"github.com/pocketbase/pocketbase/core"
+	m "github.com/pocketbase/pocketbase/migrations"
+)
+
+const AuditEventsId = "auditEvents"
+
+func init() {
+	m.Register(func(app core.App) error {
+		users, err := app.FindCollectionByNameOrId(UsersId)
+		if err != nil {
+			return err
+		}
+
+		auditEvents := core.NewBaseCollection(AuditEventsId)
+
+		// family isn't a relation so the events of a family outlive it
+		auditEvents.Fields.Add(&core.TextField{
+			Name:     "family",
+			Max:      15,
+			Required: true,
+		})
+
+		auditEvents.Fields.Add(&core.RelationField{
+			Name:         "actor",
+			CollectionId: users.Id,
+			MaxSelect:    1,
+		})
+
+		// names are copied so the log stays readable if users change them
+		auditEvents.Fields.Add(&core.TextField{
+			Name: "actorName",
+			Max:  128,
+		})
+
+		auditEvents.Fields.Add(&core.SelectField{
+			Name:      "action",
+			MaxSelect: 1,
+			Values: []string{
+				"family.created",
+				"family.renamed",
+				"family.deleted",
+				"member.invited",
+				"member.joined",
+				"member.left",
+				"member.removed",
+				"member.roleChanged",
+			},
+			Presentable: true,
+			Required:    true,
+		})
+
+		auditEvents.Fields.Add(&core.RelationField{
+			Name:         "subject",
+			CollectionId: users.Id,
+			MaxSelect:    1,
+		})
+
+		auditEvents.Fields.Add(&core.TextField{
+			Name: "subjectName",
+			Max:  128,
+		})
+
+		auditEvents.Fields.Add(&core.JSONField{
+			Name:    "data",
+			MaxSize: 2048,
+		})
+
+		auditEvents.Fields.Add(&core.AutodateField{
+			Name:     "createdAt",
+			System:   true,
+			OnCreate: true,
+		})
+
+		auditEvents.AddIndex("idx_audit_event_family", false, "family, createdAt", "")
+
+		return app.Save(auditEvents)
+	}, func(app core.App) error {
+		auditEvents, err := app.FindCollectionByNameOrId(AuditEventsId)
+		if err != nil {
+			return err
+		}
+
+		return app.Delete(auditEvents)
+	})
+}
@@ -0,0 +1,61 @@
+package migrations
+
+import (
+	"github.com/pocketbase/pocketbase/core"
+	m "github.com/pocketbase/pocketbase/migrations"
+)
+
+func init() {
+	m.Register(func(app core.App) error {
+		devices, err := app.FindCollectionByNameOrId(DevicesId)
+		if err != nil {
+			return err
+		}
+
+		// the platform doesn't tell an iPhone from an iPad
+		devices.Fields.Add(&core.SelectField{
+			Name:      "formFactor",
+			MaxSelect: 1,
+			Values:    []string{"phone", "tablet", "desktop", "tv"},
+		})
+
+		if err := app.Save(devices); err != nil {
+			return err
+		}
+
+		// a user's location comes from a single device. Of several devices
+		// sharing it, the one seen last is kept.
+		_, err = app.DB().NewQuery(`
+      update devices
+      set sharesLocation = false
+      where sharesLocation = true
+        and revokedAt = ''
+        and exists (
+          select 1
+          from devices later
+          where later.user = devices.user
+            and later.sharesLocation = true
+            and later.revokedAt = ''
+            and (later.lastSeenAt > devices.lastSeenAt
+              or (later.lastSeenAt = devices.lastSeenAt and later.id > devices.id))
+        )
+    `).Execute()
+		if err != nil {
+			return err
+		}
+
+		devices.AddIndex("idx_device_sharing_user", true, "user", "sharesLocation = true and revokedAt = ''")
+
+		return app.Save(devices)
+	}, func(app core.App) error {
+		devices, err := app.FindCollectionByNameOrId(DevicesId)
+		if err != nil {
+			return err
+		}
+
+		devices.RemoveIndex("idx_device_sharing_user")
+		devices.Fields.RemoveByName("formFactor")
+
+		return app.Save(devices)
+	})
+}
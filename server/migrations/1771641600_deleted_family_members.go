@@ -0,0 +1,52 @@
+package migrations
+
+import (
+	"github.com/pocketbase/pocketbase/core"
+	m "github.com/pocketbase/pocketbase/migrations"
+)
+
+const DeletedFamilyMembersId = "deletedFamilyMembers"
+
+func init() {
+	m.Register(func(app core.App) error {
+		deleted := core.NewBaseCollection(DeletedFamilyMembersId)
+
+		// the ids aren't relations since the records they point to are gone
+		// or may be deleted too
+		deleted.Fields.Add(&core.TextField{
+			Name:     "member",
+			Max:      15,
+			Required: true,
+		})
+
+		deleted.Fields.Add(&core.TextField{
+			Name:     "family",
+			Max:      15,
+			Required: true,
+		})
+
+		deleted.Fields.Add(&core.TextField{
+			Name:     "user",
+			Max:      15,
+			Required: true,
+		})
+
+		deleted.Fields.Add(&core.AutodateField{
+			Name:     "deletedAt",
+			System:   true,
+			OnCreate: true,
+		})
+
+		deleted.AddIndex("idx_deleted_family_member_family", false, "family, deletedAt", "")
+		deleted.AddIndex("idx_deleted_family_member_user", false, "user, deletedAt", "")
+
+		return app.Save(deleted)
+	}, func(app core.App) error {
+		deleted, err := app.FindCollectionByNameOrId(DeletedFamilyMembersId)
+		if err != nil {
+			return err
+		}
+
+		return app.Delete(deleted)
+	})
+}
@@ -0,0 +1,56 @@
+package migrations
+
+import (
+	"fmt"
+	"slices"
+
+	"github.com/pocketbase/pocketbase/core"
+	m "github.com/pocketbase/pocketbase/migrations"
+)
+
+// heicMimeTypes can't be decoded by the server, so uploads in these formats
+// can't be normalized and are rejected.
+var heicMimeTypes = []string{"image/heic", "image/heif"}
+
+func init() {
+	m.Register(func(app core.App) error {
+		return updateImageMimeTypes(app, func(mimeTypes []string) []string {
+			return slices.DeleteFunc(mimeTypes, func(mimeType string) bool {
+				return slices.Contains(heicMimeTypes, mimeType)
+			})
+		})
+	}, func(app core.App) error {
+		return updateImageMimeTypes(app, func(mimeTypes []string) []string {
+			return append(mimeTypes, heicMimeTypes...)
+		})
+	})
+}
+
+// updateImageMimeTypes changes the accepted types of user avatars and family
+// images.
+func updateImageMimeTypes(app core.App, update func([]string) []string) error {
+	fields := []struct{ collection, field string }{
+		{UsersId, "avatar"},
+		{FamiliesId, "image"},
+	}
+
+	for _, f := range fields {
+		collection, err := app.FindCollectionByNameOrId(f.collection)
+		if err != nil {
+			return err
+		}
+
+		field, ok := collection.Fields.GetByName(f.field).(*core.FileField)
+		if !ok {
+			return fmt.Errorf("%w: expected file field", ErrInvalidFieldType)
+		}
+
+		field.MimeTypes = update(slices.Clone(field.MimeTypes))
+
+		if err := app.Save(collection); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
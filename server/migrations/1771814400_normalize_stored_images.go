@@ -0,0 +1,87 @@
+package migrations
+
+import (
+	"bytes"
+	"io"
+
+	"github.com/ian-shakespeare/tribe-tracker/server/internal/images"
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/core"
+	m "github.com/pocketbase/pocketbase/migrations"
+	"github.com/pocketbase/pocketbase/tools/filesystem"
+)
+
+// storedImageMaxDimension matches the cap applied to uploads.
+const storedImageMaxDimension = 1024
+
+func init() {
+	m.Register(func(app core.App) error {
+		if err := normalizeStoredImages(app, UsersId, "avatar"); err != nil {
+			return err
+		}
+
+		return normalizeStoredImages(app, FamiliesId, "image")
+	}, nil)
+}
+
+// normalizeStoredImages normalizes the images uploaded before uploads were,
+// so none of them keeps the camera metadata it was stored with. Images that
+// can't be decoded, such as HEIC photos, can't be stripped either and are
+// removed.
+func normalizeStoredImages(app core.App, collection, field string) error {
+	records, err := app.FindAllRecords(collection, dbx.NewExp("["+field+"] != ''"))
+	if err != nil {
+		return err
+	}
+
+	fsys, err := app.NewFilesystem()
+	if err != nil {
+		return err
+	}
+	defer fsys.Close()
+
+	for _, record := range records {
+		name := record.GetString(field)
+		key := record.BaseFilesPath() + "/" + name
+
+		content, err := readStoredFile(fsys, key)
+		if err != nil {
+			app.Logger().Warn("Failed to read stored image", "collection", collection, "id", record.Id, "error", err)
+			continue
+		}
+
+		if vector, err := images.IsVector(bytes.NewReader(content)); err != nil || vector {
+			continue
+		}
+
+		normalized, err := images.Normalize(bytes.NewReader(content), storedImageMaxDimension)
+		if err != nil {
+			record.Set(field, nil)
+			if err := app.SaveNoValidate(record); err != nil {
+				return err
+			}
+
+			continue
+		}
+
+		// the file keeps its name, so neither the record nor the URLs clients
+		// cached change, and is served with the content type detected here
+		if err := fsys.Upload(normalized, key); err != nil {
+			return err
+		}
+
+		fsys.DeletePrefix(record.BaseFilesPath() + "/thumbs_" + name + "/")
+	}
+
+	return nil
+}
+
+func readStoredFile(fsys *filesystem.System, key string) ([]byte, error) {
+	r, err := fsys.GetReader(key)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	return io.ReadAll(r)
+}
@@ -0,0 +1,48 @@
+package migrations
+
+import (
+	"github.com/pocketbase/pocketbase/core"
+	m "github.com/pocketbase/pocketbase/migrations"
+)
+
+func init() {
+	m.Register(func(app core.App) error {
+		locations, err := app.FindCollectionByNameOrId(LocationsId)
+		if err != nil {
+			return err
+		}
+
+		// locations pushed after being recorded offline are stored long after
+		// the device recorded them
+		locations.Fields.Add(&core.DateField{
+			Name: "recordedAt",
+		})
+
+		if err := app.Save(locations); err != nil {
+			return err
+		}
+
+		_, err = app.DB().NewQuery(`
+      update locations
+      set recordedAt = createdAt
+      where recordedAt = ''
+    `).Execute()
+		if err != nil {
+			return err
+		}
+
+		locations.AddIndex("idx_location_user_recorded_at", false, "user, recordedAt", "")
+
+		return app.Save(locations)
+	}, func(app core.App) error {
+		locations, err := app.FindCollectionByNameOrId(LocationsId)
+		if err != nil {
+			return err
+		}
+
+		locations.RemoveIndex("idx_location_user_recorded_at")
+		locations.Fields.RemoveByName("recordedAt")
+
+		return app.Save(locations)
+	})
+}
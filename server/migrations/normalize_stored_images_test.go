@@ -0,0 +1,37 @@
+package migrations_test
+
+import (
+	"bytes"
+	"image"
+	"io"
+	"testing"
+
+	"github.com/pocketbase/pocketbase/tests"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNormalizeStoredImages(t *testing.T) {
+	app, err := tests.NewTestApp(testDataDir)
+	require.NoError(t, err)
+	defer app.Cleanup()
+
+	// Padmé's avatar was uploaded as a PNG
+	padme, err := app.FindRecordById("users", "zp17d7nbbm6dwrk")
+	require.NoError(t, err)
+
+	fsys, err := app.NewFilesystem()
+	require.NoError(t, err)
+	defer fsys.Close()
+
+	r, err := fsys.GetReader(padme.BaseFilesPath() + "/" + padme.GetString("avatar"))
+	require.NoError(t, err)
+	defer r.Close()
+
+	content, err := io.ReadAll(r)
+	require.NoError(t, err)
+
+	_, format, err := image.DecodeConfig(bytes.NewReader(content))
+	require.NoError(t, err)
+	require.Equal(t, "jpeg", format)
+	require.Equal(t, "image/jpeg", r.ContentType())
+}
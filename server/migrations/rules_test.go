@@ -0,0 +1,273 @@
+package migrations_test
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/pocketbase/pocketbase/apis"
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/tests"
+	"github.com/pocketbase/pocketbase/tools/subscriptions"
+	"github.com/stretchr/testify/require"
+
+	_ "github.com/ian-shakespeare/tribe-tracker/server/migrations"
+)
+
+const testDataDir = "../testdata"
+
+const skywalkersId = "3re9axqzawl3esv"
+
+// subscribe registers a realtime client authenticated as the user with the
+// given email and returns a channel receiving its messages.
+func subscribe(t *testing.T, app core.App, email string, topics ...string) <-chan subscriptions.Message {
+	t.Helper()
+
+	auth, err := app.FindAuthRecordByEmail("users", email)
+	require.NoError(t, err)
+
+	client := subscriptions.NewDefaultClient()
+	client.Set(apis.RealtimeClientAuthKey, auth)
+	client.Subscribe(topics...)
+	app.SubscriptionsBroker().Register(client)
+
+	messages := make(chan subscriptions.Message, 16)
+	go func() {
+		for message := range client.Channel() {
+			messages <- message
+		}
+	}()
+
+	t.Cleanup(func() {
+		app.SubscriptionsBroker().Unregister(client.Id())
+	})
+
+	return messages
+}
+
+// requireMessages waits for one message per topic, in any order.
+func requireMessages(t *testing.T, messages <-chan subscriptions.Message, topics ...string) {
+	t.Helper()
+
+	received := make([]string, 0, len(topics))
+	for range topics {
+		select {
+		case message := <-messages:
+			received = append(received, message.Name)
+		case <-time.After(time.Second):
+			require.Fail(t, "expected realtime messages", "%v, got %v", topics, received)
+		}
+	}
+
+	require.ElementsMatch(t, topics, received)
+}
+
+func requireNoMessage(t *testing.T, messages <-chan subscriptions.Message) {
+	t.Helper()
+
+	select {
+	case message := <-messages:
+		require.Fail(t, "unexpected realtime message", message.Name)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func setupRealtimeApp(t *testing.T) *tests.TestApp {
+	t.Helper()
+
+	app, err := tests.NewTestApp(testDataDir)
+	require.NoError(t, err)
+	t.Cleanup(app.Cleanup)
+
+	// the router registers the realtime record broadcast hooks
+	_, err = apis.NewRouter(app)
+	require.NoError(t, err)
+
+	return app
+}
+
+func TestRealtimeLocations(t *testing.T) {
+	app := setupRealtimeApp(t)
+
+	owner := subscribe(t, app, "luke.skywalker@email.com", "locations/*")
+	member := subscribe(t, app, "leia.organa@email.com", "locations/*")
+	stranger := subscribe(t, app, "darth.vader@email.com", "locations/*")
+
+	locations, err := app.FindCollectionByNameOrId("locations")
+	require.NoError(t, err)
+
+	luke, err := app.FindAuthRecordByEmail("users", "luke.skywalker@email.com")
+	require.NoError(t, err)
+
+	location := core.NewRecord(locations)
+	location.Set("user", luke.Id)
+	location.Set("coordinates", map[string]float64{"lon": 8.99, "lat": 33.47})
+	require.NoError(t, app.Save(location))
+
+	requireMessages(t, owner, "locations/*")
+	requireMessages(t, member, "locations/*")
+	requireNoMessage(t, stranger)
+}
+
+func TestRealtimeFamilies(t *testing.T) {
+	app := setupRealtimeApp(t)
+
+	member := subscribe(t, app, "leia.organa@email.com", "families/*", "families/"+skywalkersId)
+	stranger := subscribe(t, app, "darth.vader@email.com", "families/*", "families/"+skywalkersId)
+
+	family, err := app.FindRecordById("families", skywalkersId)
+	require.NoError(t, err)
+
+	family.Set("name", "Skywalker Clan")
+	require.NoError(t, app.Save(family))
+
+	requireMessages(t, member, "families/*", "families/"+skywalkersId)
+	requireNoMessage(t, stranger)
+}
+
+func TestRealtimeFamilyMembers(t *testing.T) {
+	app := setupRealtimeApp(t)
+
+	member := subscribe(t, app, "leia.organa@email.com", "familyMembers/*")
+	stranger := subscribe(t, app, "darth.vader@email.com", "familyMembers/*")
+
+	familyMembers, err := app.FindCollectionByNameOrId("familyMembers")
+	require.NoError(t, err)
+
+	vader, err := app.FindAuthRecordByEmail("users", "darth.vader@email.com")
+	require.NoError(t, err)
+
+	families, err := app.FindCollectionByNameOrId("families")
+	require.NoError(t, err)
+
+	empire := core.NewRecord(families)
+	empire.Set("name", "Empire")
+	empire.Set("code", "death-star")
+	empire.Set("createdBy", vader.Id)
+	require.NoError(t, app.Save(empire))
+
+	membership := core.NewRecord(familyMembers)
+	membership.Set("family", empire.Id)
+	membership.Set("user", vader.Id)
+	membership.Set("role", "owner")
+	require.NoError(t, app.Save(membership))
+
+	requireMessages(t, stranger, "familyMembers/*")
+	requireNoMessage(t, member)
+}
+
+func TestLocationListRule(t *testing.T) {
+	leia := generateToken(t, "leia.organa@email.com")
+	vader := generateToken(t, "darth.vader@email.com")
+
+	scenarios := []tests.ApiScenario{
+		{
+			Name:   "family member",
+			Method: http.MethodGet,
+			URL:    "/api/collections/locations/records",
+			Headers: map[string]string{
+				"Authorization": leia,
+			},
+			ExpectedStatus:  http.StatusOK,
+			ExpectedContent: []string{`"totalItems":3`, `"pjrriu6noxafz76"`},
+		},
+		{
+			Name:   "non-member",
+			Method: http.MethodGet,
+			URL:    "/api/collections/locations/records",
+			Headers: map[string]string{
+				"Authorization": vader,
+			},
+			ExpectedStatus:     http.StatusOK,
+			ExpectedContent:    []string{`"totalItems":1`, `"edhmc5ydeq7xb4h"`},
+			NotExpectedContent: []string{`"pjrriu6noxafz76"`},
+		},
+	}
+
+	for _, scenario := range scenarios {
+		scenario.TestAppFactory = func(t testing.TB) *tests.TestApp {
+			app, err := tests.NewTestApp(testDataDir)
+			require.NoError(t, err)
+
+			return app
+		}
+		scenario.Test(t)
+	}
+}
+
+func generateToken(t *testing.T, email string) string {
+	t.Helper()
+
+	app, err := tests.NewTestApp(testDataDir)
+	require.NoError(t, err)
+	defer app.Cleanup()
+
+	record, err := app.FindAuthRecordByEmail("users", email)
+	require.NoError(t, err)
+
+	token, err := record.NewAuthToken()
+	require.NoError(t, err)
+
+	return token
+}
+
+func generateFileToken(t *testing.T, email string) string {
+	t.Helper()
+
+	app, err := tests.NewTestApp(testDataDir)
+	require.NoError(t, err)
+	defer app.Cleanup()
+
+	record, err := app.FindAuthRecordByEmail("users", email)
+	require.NoError(t, err)
+
+	token, err := record.NewFileToken()
+	require.NoError(t, err)
+
+	return token
+}
+
+func TestProtectedAvatars(t *testing.T) {
+	path := "/api/files/users/pjrriu6noxafz76/luke_lii4ry6x0q.jpeg"
+
+	scenarios := []tests.ApiScenario{
+		{
+			Name:            "no token",
+			Method:          http.MethodGet,
+			URL:             path,
+			ExpectedStatus:  http.StatusNotFound,
+			ExpectedContent: []string{`"status":404`},
+		},
+		{
+			Name:            "stranger",
+			Method:          http.MethodGet,
+			URL:             path + "?token=" + generateFileToken(t, "darth.vader@email.com"),
+			ExpectedStatus:  http.StatusNotFound,
+			ExpectedContent: []string{`"status":404`},
+		},
+		{
+			Name:               "family member",
+			Method:             http.MethodGet,
+			URL:                path + "?thumb=100x100&token=" + generateFileToken(t, "leia.organa@email.com"),
+			ExpectedStatus:     http.StatusOK,
+			NotExpectedContent: []string{`"status":404`},
+		},
+		{
+			Name:               "own avatar",
+			Method:             http.MethodGet,
+			URL:                path + "?token=" + generateFileToken(t, "luke.skywalker@email.com"),
+			ExpectedStatus:     http.StatusOK,
+			NotExpectedContent: []string{`"status":404`},
+		},
+	}
+
+	for _, scenario := range scenarios {
+		scenario.TestAppFactory = func(t testing.TB) *tests.TestApp {
+			app, err := tests.NewTestApp(testDataDir)
+			require.NoError(t, err)
+
+			return app
+		}
+		scenario.Test(t)
+	}
+}
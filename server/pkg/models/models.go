@@ -3,43 +3,190 @@ package models
 import "github.com/pocketbase/pocketbase/tools/types"
 
 type User struct {
-	ID        string         `db:"id" json:"id"`
-	Email     string         `db:"email" json:"email"`
-	FirstName string         `db:"firstName" json:"firstName"`
-	LastName  string         `db:"lastName" json:"lastName"`
-	Avatar    string         `db:"avatar" json:"avatar"`
-	CreatedAt types.DateTime `db:"createdAt" json:"createdAt"`
-	UpdatedAt types.DateTime `db:"updatedAt" json:"updatedAt"`
-	IsDeleted bool           `db:"isDeleted" json:"isDeleted"`
+	ID        string `db:"id" json:"id"`
+	Email     string `db:"email" json:"email"`
+	FirstName string `db:"firstName" json:"firstName"`
+	LastName  string `db:"lastName" json:"lastName"`
+	Avatar    string `db:"avatar" json:"avatar"`
+	// AvatarURL and AvatarThumbURL are resolved by the server when the user
+	// is returned to a client.
+	AvatarURL      string         `db:"-" json:"avatarUrl"`
+	AvatarThumbURL string         `db:"-" json:"avatarThumbUrl"`
+	CreatedAt      types.DateTime `db:"createdAt" json:"createdAt"`
+	UpdatedAt      types.DateTime `db:"updatedAt" json:"updatedAt"`
+	IsDeleted      bool           `db:"isDeleted" json:"isDeleted"`
+	// Version increases with every update and is used to detect
+	// conflicting edits.
+	Version int `db:"version" json:"version"`
 }
 
 type Family struct {
-	ID        string         `db:"id" json:"id"`
-	Name      string         `db:"name" json:"name"`
-	CreatedBy string         `db:"createdBy" json:"createdBy"`
-	CreatedAt types.DateTime `db:"createdAt" json:"createdAt"`
-	UpdatedAt types.DateTime `db:"updatedAt" json:"updatedAt"`
-	IsDeleted bool           `db:"isDeleted" json:"isDeleted"`
+	ID               string         `db:"id" json:"id"`
+	Name             string         `db:"name" json:"name"`
+	Description      string         `db:"description" json:"description"`
+	Icon             string         `db:"icon" json:"icon"`
+	Color            string         `db:"color" json:"color"`
+	SharingPrecision string         `db:"sharingPrecision" json:"sharingPrecision"`
+	Image            string         `db:"image" json:"image"`
+	CreatedBy        string         `db:"createdBy" json:"createdBy"`
+	CreatedAt        types.DateTime `db:"createdAt" json:"createdAt"`
+	UpdatedAt        types.DateTime `db:"updatedAt" json:"updatedAt"`
+	IsDeleted        bool           `db:"isDeleted" json:"isDeleted"`
+	// Version increases with every update and is used to detect
+	// conflicting edits.
+	Version int `db:"version" json:"version"`
 }
 
+// Precisions a location can be shared with.
+const (
+	PrecisionExact       = "exact"
+	PrecisionApproximate = "approximate"
+	PrecisionCity        = "city"
+)
+
+// Roles a user can hold within a family.
+const (
+	RoleOwner  = "owner"
+	RoleAdmin  = "admin"
+	RoleMember = "member"
+)
+
 type FamilyMember struct {
 	ID        string         `db:"id" json:"id"`
 	User      string         `db:"user" json:"user"`
 	Family    string         `db:"family" json:"family"`
+	Role      string         `db:"role" json:"role"`
 	CreatedAt types.DateTime `db:"createdAt" json:"createdAt"`
 }
 
+// Member is a user's membership in a family joined with their profile.
+type Member struct {
+	ID        string `db:"id" json:"id"`
+	User      string `db:"user" json:"user"`
+	Email     string `db:"email" json:"email"`
+	FirstName string `db:"firstName" json:"firstName"`
+	LastName  string `db:"lastName" json:"lastName"`
+	Avatar    string `db:"avatar" json:"avatar"`
+	// AvatarURL and AvatarThumbURL are resolved by the server when the
+	// member is returned to a client.
+	AvatarURL      string         `db:"-" json:"avatarUrl"`
+	AvatarThumbURL string         `db:"-" json:"avatarThumbUrl"`
+	Role           string         `db:"role" json:"role"`
+	CreatedAt      types.DateTime `db:"createdAt" json:"createdAt"`
+}
+
 type Invitation struct {
-	ID        string         `db:"id" json:"id"`
-	Sender    string         `db:"sender" json:"sender"`
+	ID     string `db:"id" json:"id"`
+	Sender string `db:"sender" json:"sender"`
+	// Recipient is empty for invitations to an email address that doesn't
+	// belong to a user yet.
 	Recipient string         `db:"recipient" json:"recipient"`
+	Email     string         `db:"email" json:"email"`
 	Family    string         `db:"family" json:"family"`
+	Status    string         `db:"status" json:"status"`
+	ExpiresAt types.DateTime `db:"expiresAt" json:"expiresAt"`
+	// SentAt is when the invitation was last sent.
+	SentAt    types.DateTime `db:"sentAt" json:"sentAt"`
 	CreatedAt types.DateTime `db:"createdAt" json:"createdAt"`
 }
 
+// Statuses of an invitation. Only pending invitations can be answered.
+const (
+	InvitationPending  = "pending"
+	InvitationAccepted = "accepted"
+	InvitationDeclined = "declined"
+	InvitationRevoked  = "revoked"
+	InvitationExpired  = "expired"
+)
+
+// AuditEvent records an action that affected a family. Actor and Subject
+// are empty when the user no longer exists or the actor was an administrator;
+// their names are kept as they were at the time.
+type AuditEvent struct {
+	ID          string         `db:"id" json:"id"`
+	Family      string         `db:"family" json:"family"`
+	Actor       string         `db:"actor" json:"actor"`
+	ActorName   string         `db:"actorName" json:"actorName"`
+	Action      string         `db:"action" json:"action"`
+	Subject     string         `db:"subject" json:"subject"`
+	SubjectName string         `db:"subjectName" json:"subjectName"`
+	Data        types.JSONRaw  `db:"data" json:"data"`
+	CreatedAt   types.DateTime `db:"createdAt" json:"createdAt"`
+}
+
+// Actions recorded in the audit log.
+const (
+	AuditFamilyCreated     = "family.created"
+	AuditFamilyRenamed     = "family.renamed"
+	AuditFamilyDeleted     = "family.deleted"
+	AuditMemberInvited     = "member.invited"
+	AuditMemberJoined      = "member.joined"
+	AuditMemberLeft        = "member.left"
+	AuditMemberRemoved     = "member.removed"
+	AuditMemberRoleChanged = "member.roleChanged"
+)
+
 type Location struct {
 	ID          string         `db:"id" json:"id"`
 	User        string         `db:"user" json:"user"`
+	Device      string         `db:"device" json:"device"`
+	Coordinates string         `db:"coordinates" json:"coordinates"`
+	CreatedAt   types.DateTime `db:"createdAt" json:"createdAt"`
+	// RecordedAt is when the device recorded the location, which is earlier
+	// than CreatedAt for locations recorded offline.
+	RecordedAt types.DateTime `db:"recordedAt" json:"recordedAt"`
+}
+
+// Place is a named geofence shared by a family.
+type Place struct {
+	ID          string         `db:"id" json:"id"`
+	Family      string         `db:"family" json:"family"`
+	Name        string         `db:"name" json:"name"`
 	Coordinates string         `db:"coordinates" json:"coordinates"`
+	Radius      float64        `db:"radius" json:"radius"`
 	CreatedAt   types.DateTime `db:"createdAt" json:"createdAt"`
+	UpdatedAt   types.DateTime `db:"updatedAt" json:"updatedAt"`
+}
+
+// Platforms a device can run on.
+const (
+	PlatformIOS     = "ios"
+	PlatformAndroid = "android"
+	PlatformWeb     = "web"
+	PlatformDisplay = "display"
+)
+
+// Form factors of a device. Platforms like iOS run on phones and tablets
+// alike, and only phones share their location by default.
+const (
+	FormFactorPhone   = "phone"
+	FormFactorTablet  = "tablet"
+	FormFactorDesktop = "desktop"
+	FormFactorTV      = "tv"
+)
+
+type Device struct {
+	ID             string         `db:"id" json:"id"`
+	User           string         `db:"user" json:"user"`
+	Name           string         `db:"name" json:"name"`
+	Platform       string         `db:"platform" json:"platform"`
+	FormFactor     string         `db:"formFactor" json:"formFactor"`
+	AppVersion     string         `db:"appVersion" json:"appVersion"`
+	SharesLocation bool           `db:"sharesLocation" json:"sharesLocation"`
+	LastSeenAt     types.DateTime `db:"lastSeenAt" json:"lastSeenAt"`
+	RevokedAt      types.DateTime `db:"revokedAt" json:"revokedAt"`
+	CreatedAt      types.DateTime `db:"createdAt" json:"createdAt"`
+	UpdatedAt      types.DateTime `db:"updatedAt" json:"updatedAt"`
+}
+
+// DisplayToken is a read-only credential scoped to a single family, used by
+// wall displays instead of a user account.
+type DisplayToken struct {
+	ID         string         `db:"id" json:"id"`
+	Family     string         `db:"family" json:"family"`
+	CreatedBy  string         `db:"createdBy" json:"createdBy"`
+	Name       string         `db:"name" json:"name"`
+	LastUsedAt types.DateTime `db:"lastUsedAt" json:"lastUsedAt"`
+	RevokedAt  types.DateTime `db:"revokedAt" json:"revokedAt"`
+	CreatedAt  types.DateTime `db:"createdAt" json:"createdAt"`
 }